@@ -11,3 +11,83 @@ var Base = struct {
 	Domain:      "127.0.0.1",
 	Port:        "80",
 }
+
+var RequestID = struct {
+	Format          string
+	SnowflakeNodeID int64
+	TrustedProxies  []string
+	UntrustedAction string
+}{
+	Format:          "default", // default / ulid / uuidv7 / snowflake
+	SnowflakeNodeID: 0,         // Only used by snowflake, [0, 1023]
+	TrustedProxies:  nil,       // IPs or CIDRs allowed to set X-Request-ID, empty trusts no client
+	UntrustedAction: "replace", // replace / prefix
+}
+
+var Tracing = struct {
+	Exporter       string
+	FilePath       string
+	OTLPEndpoint   string
+	QueueSize      int
+	BatchSize      int
+	FlushInterval  int
+	Collector      bool
+	CollectorToken string
+}{
+	Exporter:       "none", // none / file / otlp
+	FilePath:       "trace_output.jsonl",
+	OTLPEndpoint:   "http://127.0.0.1:80/tool/v1/traces", // Local collector stand-in
+	QueueSize:      2048,
+	BatchSize:      128,
+	FlushInterval:  5,     // Seconds
+	Collector:      false, // Mount the local collector stand-in on tool/v1/traces and tool/traces
+	CollectorToken: "",    // Bearer token of the collector routes and the otlp exporter, required by Collector
+}
+
+var RateLimit = struct {
+	PolicyFile  string
+	HeaderStyle string
+	FailureMode string
+	RedisAddr   string
+	Shadow      bool
+	ReportToken string
+}{
+	PolicyFile:  "config/rate_limit_policy.json", // Empty to disable rate limit policies
+	HeaderStyle: "both",                          // legacy / ietf / both
+	FailureMode: "closed",                        // closed / open
+	RedisAddr:   "",                              // Redis of the fixed_window limits, other algorithms stay in memory; empty for in-memory limits
+	Shadow:      false,                           // Compute limits without rejecting, see tool/rate_limit/shadow
+	ReportToken: "",                              // Bearer token of tool/rate_limit/shadow, empty to not mount it
+}
+
+var Quota = struct {
+	ConfigFile     string
+	JWTSecret      string
+	IdentityHeader string
+}{
+	ConfigFile:     "config/quota.json", // Plans, default plan, hashed API keys and identity assignments
+	JWTSecret:      "",                  // HS256 secret of Bearer JWTs, empty to ignore JWTs
+	IdentityHeader: "",                  // Identity header set by a gateway, e.g. X-User-ID; empty to ignore it
+}
+
+var ConcurrencyLimit = struct {
+	GlobalLimit      int
+	RouteLimit       int
+	MaxQueue         int
+	QueueTimeout     int
+	Adaptive         string
+	MinLimitRatio    float64
+	MaxLimitRatio    float64
+	LatencyThreshold int
+	RetryAfter       int
+}{
+	GlobalLimit:      0, // In-flight requests of all routes, 0 to disable the global limit
+	RouteLimit:       0, // In-flight requests of each route, 0 to disable route limits
+	MaxQueue:         128,
+	QueueTimeout:     100, // Milliseconds
+	Adaptive:         "",  // "" / aimd / vegas
+	MinLimitRatio:    0.1, // Only used by adaptive limits
+	MaxLimitRatio:    2,   // Only used by adaptive limits
+	LatencyThreshold: 500, // Milliseconds, only used by aimd
+	RetryAfter:       1,   // Seconds
+}
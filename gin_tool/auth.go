@@ -0,0 +1,30 @@
+package gin_tool
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BearerTokenAuthTool struct{}
+
+// Middleware rejects requests without one of tokens as Bearer token with 401,
+// no tokens means every request is rejected.
+func (t BearerTokenAuthTool) Middleware(tokens ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok && token != "" {
+			for _, expected := range tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+					return
+				}
+			}
+		}
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse[struct{}](
+			http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized), nil,
+		))
+	}
+}
@@ -37,6 +37,8 @@ type HttpRequest struct {
 	// POST body
 	RawBody  []byte         `json:"raw_body,omitempty"`
 	JsonBody map[string]any `json:"json_body,omitempty"`
+	// BodyTruncated is set when only the beginning of the body was captured
+	BodyTruncated bool `json:"body_truncated,omitempty"`
 	// POST form data
 	FormValues url.Values `json:"form_values,omitempty"`
 	// POST form files
@@ -45,6 +47,8 @@ type HttpRequest struct {
 	// time
 	ReceivedTime time.Time `json:"received_time"`
 	RequestID    string    `json:"request_id,omitempty"`
+	// OriginalRequestID is the inbound request ID rejected by RequestIDPolicy
+	OriginalRequestID string `json:"original_request_id,omitempty"`
 }
 
 /*
@@ -67,6 +71,8 @@ type HttpResponse struct {
 	// response body
 	Body     []byte         `json:"body,omitempty"`
 	JsonBody map[string]any `json:"json_body,omitempty"`
+	// BodyTruncated is set when only the beginning of the body was captured
+	BodyTruncated bool `json:"body_truncated,omitempty"`
 
 	// error info
 	Error      string `json:"error,omitempty"`
@@ -0,0 +1,330 @@
+package gin_tool
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ConcurrencyAdaptiveNone  = ""
+	ConcurrencyAdaptiveAIMD  = "aimd"
+	ConcurrencyAdaptiveVegas = "vegas"
+)
+
+var ErrConcurrencyLimitExceeded = errors.New("concurrency limit exceeded")
+
+// DefaultVegasMinLatencyWindow is how long VegasConcurrencyAdapter keeps a minimum latency.
+const DefaultVegasMinLatencyWindow = time.Minute
+
+// Check if adapters implement IConcurrencyAdapter
+var (
+	_ IConcurrencyAdapter = &AIMDConcurrencyAdapter{}
+	_ IConcurrencyAdapter = &VegasConcurrencyAdapter{}
+)
+
+/*
+ConcurrencyLimiter:
+    1. ConcurrencyLimiter caps the number of in-flight requests,
+        requests over the limit wait in a FIFO queue for at most QueueTimeout.
+    2. An IConcurrencyAdapter adjusts the limit from the latency of finished requests:
+        - aimd:  +1 per limit successful requests, *Backoff when latency > LatencyThreshold or on failure.
+        - vegas: estimates the queue as limit * (1 - minLatency / latency),
+                 +1 when it is below Alpha, -1 when it is above Beta.
+                 minLatency is the minimum of the current and the previous MinLatencyWindow,
+                 so it follows the no-load latency when it grows, e.g. after a deploy.
+    3. ConcurrencyLimitTool.Middleware applies a global limiter and a limiter per route,
+        rejected requests get 503 with Retry-After.
+*/
+// IConcurrencyAdapter computes the next limit from a finished request.
+type IConcurrencyAdapter interface {
+	Update(limit float64, latency time.Duration, failed bool) float64
+}
+
+// AIMDConcurrencyAdapter is additive increase, multiplicative decrease.
+type AIMDConcurrencyAdapter struct {
+	LatencyThreshold time.Duration
+	// Backoff is the ratio applied on overload, in (0, 1).
+	Backoff float64
+}
+
+func (a *AIMDConcurrencyAdapter) Update(limit float64, latency time.Duration, failed bool) float64 {
+	if failed || latency > a.LatencyThreshold {
+		return limit * a.Backoff
+	}
+	return limit + 1/limit
+}
+
+// VegasConcurrencyAdapter follows TCP Vegas, the queue is estimated from the minimum latency.
+type VegasConcurrencyAdapter struct {
+	Alpha float64
+	Beta  float64
+	// MinLatencyWindow is how long a minimum latency is kept, 0 means DefaultVegasMinLatencyWindow.
+	MinLatencyWindow time.Duration
+
+	mu  sync.Mutex
+	now func() time.Time
+	// minLatency is the minimum since the start of the previous window,
+	// windowMinLatency the minimum since windowStart.
+	minLatency       time.Duration
+	windowMinLatency time.Duration
+	windowStart      time.Time
+}
+
+func (a *VegasConcurrencyAdapter) Update(limit float64, latency time.Duration, failed bool) float64 {
+	if latency <= 0 {
+		return limit
+	}
+	a.mu.Lock()
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	window := a.MinLatencyWindow
+	if window <= 0 {
+		window = DefaultVegasMinLatencyWindow
+	}
+	if a.windowStart.IsZero() {
+		a.windowStart = now
+	} else if now.Sub(a.windowStart) >= window {
+		// the minimum of older windows is dropped
+		a.minLatency, a.windowMinLatency, a.windowStart = a.windowMinLatency, 0, now
+	}
+	if a.windowMinLatency == 0 || latency < a.windowMinLatency {
+		a.windowMinLatency = latency
+	}
+	if a.minLatency == 0 || latency < a.minLatency {
+		a.minLatency = latency
+	}
+	minLatency := a.minLatency
+	a.mu.Unlock()
+
+	if failed {
+		return limit - 1
+	}
+	queue := limit * (1 - float64(minLatency)/float64(latency))
+	switch {
+	case queue < a.Alpha:
+		return limit + 1
+	case queue > a.Beta:
+		return limit - 1
+	default:
+		return limit
+	}
+}
+
+// ConcurrencyLimiter caps in-flight requests.
+type ConcurrencyLimiter struct {
+	minLimit float64
+	maxLimit float64
+	maxQueue int
+	adapter  IConcurrencyAdapter
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	waiters  *list.List
+}
+
+// NewConcurrencyLimiter creates a limiter starting at limit, adapted within [minLimit, maxLimit].
+// maxQueue is the maximum number of waiting requests, a nil adapter means a fixed limit.
+func NewConcurrencyLimiter(
+	limit int, minLimit int, maxLimit int, maxQueue int, adapter IConcurrencyAdapter,
+) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		minLimit: float64(max(minLimit, 1)),
+		maxLimit: float64(max(maxLimit, limit)),
+		maxQueue: maxQueue,
+		adapter:  adapter,
+		limit:    float64(limit),
+		waiters:  list.New(),
+	}
+}
+
+// Limit returns the current limit.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of in-flight requests.
+func (l *ConcurrencyLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Acquire waits for a slot for at most timeout, an acquired slot must be given back
+// with Release, or with Cancel if the request was not processed.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, timeout time.Duration) error {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) && l.waiters.Len() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if timeout <= 0 || l.waiters.Len() >= l.maxQueue {
+		l.mu.Unlock()
+		return ErrConcurrencyLimitExceeded
+	}
+	granted := make(chan struct{})
+	element := l.waiters.PushBack(granted)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	err := ErrConcurrencyLimitExceeded
+	select {
+	case <-granted:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-granted:
+		// granted while giving up, keep the slot
+		return nil
+	default:
+		l.waiters.Remove(element)
+		return err
+	}
+}
+
+// Release gives back a slot and adapts the limit with the latency of the request.
+func (l *ConcurrencyLimiter) Release(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.adapter != nil {
+		l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, l.adapter.Update(l.limit, latency, failed)))
+	}
+	l.release()
+}
+
+// Cancel gives back a slot without adapting the limit.
+func (l *ConcurrencyLimiter) Cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.release()
+}
+
+// release must be called with l.mu held.
+func (l *ConcurrencyLimiter) release() {
+	l.inFlight--
+	// hand the free slots to the waiters in order
+	for l.inFlight < int(l.limit) && l.waiters.Len() > 0 {
+		granted := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.inFlight++
+		close(granted)
+	}
+}
+
+// ConcurrencyLimitConfig configures ConcurrencyLimitTool.Middleware.
+type ConcurrencyLimitConfig struct {
+	// GlobalLimit caps in-flight requests of all routes, 0 means no global limit.
+	GlobalLimit int
+	// RouteLimit caps in-flight requests of each route, 0 means no route limit.
+	RouteLimit int
+	// MaxQueue is the maximum number of waiting requests per limiter.
+	MaxQueue int
+	// QueueTimeout is how long a request may wait, 0 means rejecting at once.
+	QueueTimeout time.Duration
+	// Adaptive is one of ConcurrencyAdaptive*.
+	Adaptive string
+	// MinLimitRatio and MaxLimitRatio bound adaptive limits, as ratios of the configured limit.
+	MinLimitRatio float64
+	MaxLimitRatio float64
+	// LatencyThreshold is the overload latency of aimd.
+	LatencyThreshold time.Duration
+	// RetryAfter is sent on 503.
+	RetryAfter time.Duration
+}
+
+func (cfg ConcurrencyLimitConfig) newLimiter(limit int) *ConcurrencyLimiter {
+	var adapter IConcurrencyAdapter
+	switch cfg.Adaptive {
+	case ConcurrencyAdaptiveAIMD:
+		adapter = &AIMDConcurrencyAdapter{LatencyThreshold: cfg.LatencyThreshold, Backoff: 0.9}
+	case ConcurrencyAdaptiveVegas:
+		adapter = &VegasConcurrencyAdapter{Alpha: 3, Beta: 6}
+	}
+	minLimit, maxLimit := limit, limit
+	if adapter != nil {
+		minLimit = int(math.Ceil(float64(limit) * cfg.MinLimitRatio))
+		maxLimit = int(float64(limit) * cfg.MaxLimitRatio)
+	}
+	return NewConcurrencyLimiter(limit, minLimit, maxLimit, cfg.MaxQueue, adapter)
+}
+
+type ConcurrencyLimitTool struct{}
+
+// Middleware sheds load once the global or the route limit is reached.
+func (t ConcurrencyLimitTool) Middleware(cfg ConcurrencyLimitConfig) gin.HandlerFunc {
+	switch cfg.Adaptive {
+	case ConcurrencyAdaptiveNone, ConcurrencyAdaptiveAIMD, ConcurrencyAdaptiveVegas:
+	default:
+		panic(fmt.Sprintf("Unknown adaptive concurrency algorithm: %q", cfg.Adaptive))
+	}
+
+	var global *ConcurrencyLimiter
+	if cfg.GlobalLimit > 0 {
+		global = cfg.newLimiter(cfg.GlobalLimit)
+	}
+	routes := sync.Map{}
+
+	return func(c *gin.Context) {
+		limiters := make([]*ConcurrencyLimiter, 0, 2)
+		if global != nil {
+			limiters = append(limiters, global)
+		}
+		if cfg.RouteLimit > 0 {
+			route, ok := routes.Load(c.FullPath())
+			if !ok {
+				route, _ = routes.LoadOrStore(c.FullPath(), cfg.newLimiter(cfg.RouteLimit))
+			}
+			limiters = append(limiters, route.(*ConcurrencyLimiter))
+		}
+
+		acquired := make([]*ConcurrencyLimiter, 0, len(limiters))
+		// the queue timeout is shared by all limiters
+		deadline := time.Now().Add(cfg.QueueTimeout)
+		for _, limiter := range limiters {
+			if err := limiter.Acquire(c.Request.Context(), time.Until(deadline)); err != nil {
+				for _, a := range acquired {
+					a.Cancel()
+				}
+				c.Header("Retry-After", fmt.Sprintf("%d", max(ceilSeconds(cfg.RetryAfter), 1)))
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrorResponse(
+					http.StatusServiceUnavailable, "Server overloaded, retry later", &struct{}{},
+				))
+				return
+			}
+			acquired = append(acquired, limiter)
+		}
+
+		startTime := time.Now()
+		defer func() {
+			latency := time.Since(startTime)
+			failed := c.Writer.Status() >= http.StatusInternalServerError
+			for _, a := range acquired {
+				a.Release(latency, failed)
+			}
+		}()
+
+		// Proceed with the request
+		c.Next()
+	}
+}
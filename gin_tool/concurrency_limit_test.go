@@ -0,0 +1,180 @@
+package gin_tool
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	t.Run("Queue", TestConcurrencyLimiterQueue)
+	t.Run("AIMD", TestAIMDConcurrencyAdapter)
+	t.Run("Vegas", TestVegasConcurrencyAdapter)
+	t.Run("Load Shedding", TestConcurrencyLimitMiddleware)
+	t.Run("Canceled Request", TestConcurrencyLimitCanceledRequest)
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, 1, 1, 1, nil)
+	if err := limiter.Acquire(context.Background(), 0); err != nil {
+		t.Fatal("First Acquire failed:", err)
+	}
+
+	// The waiter gets the slot once it is released
+	acquired := make(chan error)
+	go func() {
+		acquired <- limiter.Acquire(context.Background(), time.Second)
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	// The queue is full
+	if err := limiter.Acquire(context.Background(), time.Second); err != ErrConcurrencyLimitExceeded {
+		t.Fatal("Expected ErrConcurrencyLimitExceeded with a full queue, got:", err)
+	}
+
+	limiter.Release(time.Millisecond, false)
+	if err := <-acquired; err != nil {
+		t.Fatal("Queued Acquire failed:", err)
+	}
+	if inFlight := limiter.InFlight(); inFlight != 1 {
+		t.Fatal("Expected 1 in-flight request, got:", inFlight)
+	}
+
+	// Waiting times out
+	if err := limiter.Acquire(context.Background(), time.Millisecond*20); err != ErrConcurrencyLimitExceeded {
+		t.Fatal("Expected ErrConcurrencyLimitExceeded after timeout, got:", err)
+	}
+}
+
+func TestAIMDConcurrencyAdapter(t *testing.T) {
+	adapter := &AIMDConcurrencyAdapter{LatencyThreshold: time.Millisecond * 100, Backoff: 0.5}
+	limiter := NewConcurrencyLimiter(10, 2, 20, 0, adapter)
+
+	// Slow requests halve the limit, down to the minimum
+	for i := 0; i < 5; i++ {
+		if err := limiter.Acquire(context.Background(), 0); err != nil {
+			t.Fatal("Acquire failed:", err)
+		}
+		limiter.Release(time.Millisecond*200, false)
+	}
+	if limit := limiter.Limit(); limit != 2 {
+		t.Fatal("Expected limit 2 after slow requests, got:", limit)
+	}
+
+	// Fast requests grow the limit by 1 per limit requests
+	for i := 0; i < 3; i++ {
+		if err := limiter.Acquire(context.Background(), 0); err != nil {
+			t.Fatal("Acquire failed:", err)
+		}
+		limiter.Release(time.Millisecond, false)
+	}
+	if limit := limiter.Limit(); limit != 3 {
+		t.Fatal("Expected limit 3 after fast requests, got:", limit)
+	}
+}
+
+func TestVegasConcurrencyAdapter(t *testing.T) {
+	clock := newFakeClock()
+	adapter := &VegasConcurrencyAdapter{Alpha: 3, Beta: 6, MinLatencyWindow: time.Minute, now: clock.Now}
+
+	// 10ms is the no-load latency, 100ms means a queue of 9 at limit 10
+	if limit := adapter.Update(10, time.Millisecond*10, false); limit != 11 {
+		t.Fatal("Expected the limit increased at the minimum latency, got:", limit)
+	}
+	if limit := adapter.Update(10, time.Millisecond*100, false); limit != 9 {
+		t.Fatal("Expected the limit decreased at 10x the minimum latency, got:", limit)
+	}
+
+	// the no-load latency grows to 100ms, the old minimum is kept for up to two windows
+	clock.Advance(time.Minute)
+	if limit := adapter.Update(10, time.Millisecond*100, false); limit != 9 {
+		t.Fatal("Expected the minimum of the previous window kept, got:", limit)
+	}
+	clock.Advance(time.Minute)
+	if limit := adapter.Update(10, time.Millisecond*100, false); limit != 11 {
+		t.Fatal("Expected the limit increased once the old minimum expired, got:", limit)
+	}
+	if limit := adapter.Update(10, time.Millisecond*100, true); limit != 9 {
+		t.Fatal("Expected the limit decreased on failure, got:", limit)
+	}
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(ConcurrencyLimitTool{}.Middleware(ConcurrencyLimitConfig{
+		RouteLimit:   1,
+		MaxQueue:     1,
+		QueueTimeout: time.Millisecond * 20,
+		RetryAfter:   time.Second * 2,
+	}))
+	release := make(chan struct{})
+	engine.GET("/slow", func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		done <- w.Code
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	// The second request waits in the queue and is shed
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatal("Expected 503, got:", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Fatal("Unexpected Retry-After:", retryAfter)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatal("Expected 200 for the first request, got:", code)
+	}
+}
+
+// TestConcurrencyLimitCanceledRequest checks that a queued request stops waiting once its client is gone.
+func TestConcurrencyLimitCanceledRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(ConcurrencyLimitTool{}.Middleware(ConcurrencyLimitConfig{
+		GlobalLimit:  1,
+		MaxQueue:     1,
+		QueueTimeout: time.Minute,
+	}))
+	release := make(chan struct{})
+	engine.GET("/slow", func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusOK)
+	})
+	defer close(release)
+	go engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	time.Sleep(time.Millisecond * 20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+		done <- w.Code
+	}()
+	time.Sleep(time.Millisecond * 20)
+	cancel()
+	select {
+	case code := <-done:
+		if code != http.StatusServiceUnavailable {
+			t.Fatal("Expected 503 for the canceled request, got:", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the canceled request to leave the queue")
+	}
+}
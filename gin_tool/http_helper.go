@@ -110,6 +110,9 @@ func (h HttpHelper) DecodeRequest(c *gin.Context) *HttpRequest {
 	if requestID, exists := GetRequestID(c); exists {
 		req.RequestID = requestID
 	}
+	if originalRequestID, exists := GetOriginalRequestID(c); exists {
+		req.OriginalRequestID = originalRequestID
+	}
 
 	return &req
 }
@@ -143,6 +146,55 @@ func (h HttpHelper) DecodeResponse(
 	return &resp
 }
 
+// DecodeOutboundRequest decodes an outbound HTTP request made by http.Client,
+// the body is not read, PropagationTransport captures it while it is sent.
+func (h HttpHelper) DecodeOutboundRequest(r *http.Request) *HttpRequest {
+	req := HttpRequest{
+		Method:   r.Method,
+		Protocol: r.Proto,
+		Host:     r.URL.Host,
+		URL:      r.URL.String(),
+		Path:     r.URL.Path,
+
+		Header:    r.Header.Clone(),
+		Cookies:   r.Cookies(),
+		UserAgent: r.UserAgent(),
+
+		QueryParams: r.URL.Query(),
+		ContentType: r.Header.Get("Content-Type"),
+
+		ReceivedTime: time.Now(),
+		RequestID:    r.Header.Get(RequestIDHeaderKey),
+	}
+
+	return &req
+}
+
+// DecodeOutboundResponse decodes the response (or error) of an outbound HTTP request,
+// the body is not read, PropagationTransport captures it while the caller reads it.
+func (h HttpHelper) DecodeOutboundResponse(
+	requestID string, r *http.Response, err error,
+) *HttpResponse {
+	resp := HttpResponse{
+		ResponseTime: time.Now(),
+		RequestID:    requestID,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	if r == nil {
+		return &resp
+	}
+
+	resp.Status = r.StatusCode
+	resp.StatusText = http.StatusText(r.StatusCode)
+	resp.ContentType = r.Header.Get("Content-Type")
+	resp.Header = r.Header.Clone()
+	resp.Cookies = r.Cookies()
+
+	return &resp
+}
+
 func (h HttpHelper) SetHttpRequest(c *gin.Context, req *HttpRequest) {
 	if req == nil {
 		return
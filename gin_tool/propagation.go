@@ -0,0 +1,327 @@
+package gin_tool
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const TraceparentHeaderKey = "traceparent"
+
+// DefaultOutboundBodyLogLimit is how many bytes of each outbound body are logged by default.
+const DefaultOutboundBodyLogLimit = 64 << 10
+
+type contextKey string
+
+const (
+	requestIDContextKey    contextKey = "request_id"
+	traceContextContextKey contextKey = "trace_context"
+)
+
+// Check if PropagationTransport implements http.RoundTripper
+var (
+	_ http.RoundTripper = &PropagationTransport{}
+)
+
+/*
+Propagation:
+    1. PropagationTool.Middleware copies the request ID and the W3C trace context
+        (traceparent header) of the inbound request into c.Request.Context(),
+        a new trace is started if the request has no valid traceparent.
+    2. ContextFromGin returns that context, optionally with a deadline,
+        to be passed to downstream calls.
+    3. PropagationTransport is an http.RoundTripper which injects X-Request-ID and
+        traceparent from the outbound request context, and logs the outbound exchange
+        in the same HttpRequest/HttpResponse format as HttpLoggerTool.
+    4. The bodies are captured while they are streamed, up to BodyLogLimit bytes each,
+        the exchange is logged once the caller closes the response body.
+        The logged duration is the time until the response headers.
+*/
+// TraceContext is the W3C trace context carried by the traceparent header.
+type TraceContext struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+	Sampled bool   `json:"sampled"`
+	// Remote is set if the trace context was received from another service,
+	// its SpanID is then a span of that service.
+	Remote bool `json:"remote,omitempty"`
+}
+
+func NewTraceContext() TraceContext {
+	return TraceContext{
+		TraceID: NewTraceID(),
+		SpanID:  NewSpanID(),
+		Sampled: true,
+	}
+}
+
+// Child returns a trace context in the same trace with a new span ID.
+func (t TraceContext) Child() TraceContext {
+	return TraceContext{
+		TraceID: t.TraceID,
+		SpanID:  NewSpanID(),
+		Sampled: t.Sampled,
+	}
+}
+
+// Traceparent formats the trace context as a traceparent header value.
+func (t TraceContext) Traceparent() string {
+	flags := "00"
+	if t.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceID, t.SpanID, flags)
+}
+
+// ParseTraceparent parses a traceparent header value.
+// A version 00 value has exactly 4 fields, a higher version is parsed as 00
+// and its trailing fields are ignored, the version ff is invalid.
+func ParseTraceparent(traceparent string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || !isLowerHex(parts[0], 2) || parts[0] == "ff" ||
+		parts[0] == "00" && len(parts) != 4 ||
+		!isLowerHex(parts[1], 32) || !isLowerHex(parts[2], 16) || !isLowerHex(parts[3], 2) ||
+		parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return TraceContext{}, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return TraceContext{
+		TraceID: parts[1],
+		SpanID:  parts[2],
+		Sampled: flags[0]&0x01 == 0x01,
+		Remote:  true,
+	}, true
+}
+
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !(s[i] >= '0' && s[i] <= '9' || s[i] >= 'a' && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDContextKey).(string)
+	return requestID, ok && requestID != ""
+}
+
+func WithTraceContext(ctx context.Context, traceContext TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextContextKey, traceContext)
+}
+
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	traceContext, ok := ctx.Value(traceContextContextKey).(TraceContext)
+	return traceContext, ok
+}
+
+type PropagationTool struct{}
+
+// Middleware must be registered after RequestIDTool.Middleware.
+func (t PropagationTool) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if requestID, exists := GetRequestID(c); exists {
+			ctx = WithRequestID(ctx, requestID)
+		}
+		traceContext, ok := ParseTraceparent(c.GetHeader(TraceparentHeaderKey))
+		if !ok {
+			traceContext = NewTraceContext()
+		}
+		ctx = WithTraceContext(ctx, traceContext)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Writer.Header().Set(TraceparentHeaderKey, traceContext.Traceparent())
+	}
+}
+
+// ContextFromGin returns the request context carrying the request ID and trace context,
+// with a deadline if timeout > 0. An earlier deadline of the request context is kept.
+func ContextFromGin(c *gin.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := c.Request.Context()
+	if _, ok := RequestIDFromContext(ctx); !ok {
+		if requestID, exists := GetRequestID(c); exists {
+			ctx = WithRequestID(ctx, requestID)
+		}
+	}
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// PropagationTransport injects the request ID and trace context into outbound requests.
+type PropagationTransport struct {
+	// Base is the underlying transport, nil means http.DefaultTransport.
+	Base http.RoundTripper
+	// Logger is called after every outbound exchange, nil means no logging.
+	Logger func(*HttpRequest, *HttpResponse, int64)
+	// BodyLogLimit is how many bytes of each body are logged, 0 means DefaultOutboundBodyLogLimit.
+	BodyLogLimit int
+}
+
+func NewPropagationClient(
+	timeout time.Duration, logger func(*HttpRequest, *HttpResponse, int64),
+) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &PropagationTransport{Logger: logger},
+	}
+}
+
+func (t *PropagationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// RoundTrip must not modify the original request
+	outReq := req.Clone(req.Context())
+	if requestID, ok := RequestIDFromContext(req.Context()); ok &&
+		outReq.Header.Get(RequestIDHeaderKey) == "" {
+		outReq.Header.Set(RequestIDHeaderKey, requestID)
+	}
+	if traceContext, ok := TraceContextFromContext(req.Context()); ok &&
+		outReq.Header.Get(TraceparentHeaderKey) == "" {
+		outReq.Header.Set(TraceparentHeaderKey, traceContext.Child().Traceparent())
+	}
+
+	if t.Logger == nil {
+		return base.RoundTrip(outReq)
+	}
+
+	limit := t.BodyLogLimit
+	if limit <= 0 {
+		limit = DefaultOutboundBodyLogLimit
+	}
+	helper := HttpHelper{}
+	httpRequest := helper.DecodeOutboundRequest(outReq)
+	var requestBody *bodyCapture
+	if outReq.Body != nil && outReq.Body != http.NoBody {
+		requestBody = newBodyCapture(outReq.Body, limit)
+		outReq.Body = requestBody
+	}
+
+	startTime := time.Now()
+	resp, err := base.RoundTrip(outReq)
+	duration := time.Since(startTime).Microseconds()
+	httpResponse := helper.DecodeOutboundResponse(httpRequest.RequestID, resp, err)
+
+	log := func(responseBody *bodyCapture) {
+		if requestBody != nil {
+			body, _, truncated, _ := requestBody.captured()
+			httpRequest.RawBody, httpRequest.BodyTruncated = body, truncated
+			if strings.Contains(httpRequest.ContentType, "application/json") && len(body) > 0 && !truncated {
+				_ = json.Unmarshal(body, &httpRequest.JsonBody)
+			}
+		}
+		if responseBody != nil {
+			body, size, truncated, readErr := responseBody.captured()
+			httpResponse.Body, httpResponse.Size, httpResponse.BodyTruncated = body, size, truncated
+			if readErr != nil && httpResponse.Error == "" {
+				httpResponse.Error = readErr.Error()
+			}
+			if strings.Contains(httpResponse.ContentType, "application/json") && len(body) > 0 && !truncated {
+				_ = json.Unmarshal(body, &httpResponse.JsonBody)
+			}
+		}
+		t.Logger(httpRequest, httpResponse, duration)
+	}
+
+	// the body of a protocol switch is the connection itself
+	if resp == nil || resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		log(nil)
+		return resp, err
+	}
+	responseBody := newBodyCapture(resp.Body, limit)
+	responseBody.onClose = func() { log(responseBody) }
+	resp.Body = responseBody
+	return resp, err
+}
+
+// bodyCapture is a body which keeps the first limit bytes read from it.
+type bodyCapture struct {
+	body  io.ReadCloser
+	limit int
+	// onClose is called once on the first Close
+	onClose func()
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	size      int
+	truncated bool
+	readErr   error
+	closeOnce sync.Once
+}
+
+func newBodyCapture(body io.ReadCloser, limit int) *bodyCapture {
+	return &bodyCapture{body: body, limit: limit}
+}
+
+func (b *bodyCapture) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.size += n
+	if remaining := b.limit - b.buf.Len(); n > remaining {
+		b.buf.Write(p[:remaining])
+		b.truncated = true
+	} else {
+		b.buf.Write(p[:n])
+	}
+	if err != nil && err != io.EOF {
+		b.readErr = err
+	}
+	return n, err
+}
+
+func (b *bodyCapture) Close() error {
+	err := b.body.Close()
+	b.closeOnce.Do(func() {
+		if b.onClose != nil {
+			b.onClose()
+		}
+	})
+	return err
+}
+
+// captured returns a copy of the captured bytes, the count of bytes read,
+// whether bytes were left out and the read error.
+func (b *bodyCapture) captured() ([]byte, int, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes()), b.size, b.truncated, b.readErr
+}
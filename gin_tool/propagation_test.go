@@ -0,0 +1,258 @@
+package gin_tool
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPropagation(t *testing.T) {
+	t.Run("Parse Traceparent", TestParseTraceparent)
+	t.Run("Middleware", TestPropagationMiddleware)
+	t.Run("Header Injection", TestPropagationTransportHeaders)
+	t.Run("Body Capture", TestPropagationTransportBodies)
+	t.Run("Streaming Response", TestPropagationTransportStreaming)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, spanID := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	traceContext, ok := ParseTraceparent("00-" + traceID + "-" + spanID + "-01")
+	if !ok || traceContext.TraceID != traceID || traceContext.SpanID != spanID || !traceContext.Sampled || !traceContext.Remote {
+		t.Fatal("Unexpected trace context:", traceContext, ok)
+	}
+	if traceContext.Traceparent() != "00-"+traceID+"-"+spanID+"-01" {
+		t.Fatal("Expected the same traceparent formatted back, got:", traceContext.Traceparent())
+	}
+	if traceContext, ok := ParseTraceparent(" 00-" + traceID + "-" + spanID + "-02 "); !ok || traceContext.Sampled {
+		t.Fatal("Expected an unsampled trace context, got:", traceContext, ok)
+	}
+	// a higher version is parsed as 00, its trailing fields are ignored
+	for _, future := range []string{
+		"01-" + traceID + "-" + spanID + "-01",
+		"cc-" + traceID + "-" + spanID + "-01-what-the-future-holds",
+	} {
+		if traceContext, ok := ParseTraceparent(future); !ok || traceContext.TraceID != traceID || !traceContext.Sampled {
+			t.Fatal("Expected a higher version parsed as 00:", future, traceContext, ok)
+		}
+	}
+
+	for _, invalid := range []string{
+		"",
+		"ff-" + traceID + "-" + spanID + "-01",
+		"0-" + traceID + "-" + spanID + "-01",
+		"FF-" + traceID + "-" + spanID + "-01",
+		"01-" + traceID + "-" + spanID,
+		"00-" + strings.ToUpper(traceID) + "-" + spanID + "-01",
+		"00-" + traceID[1:] + "-" + spanID + "-01",
+		"00-" + traceID + "-" + spanID + "-1",
+		"00-" + traceID + "-" + spanID + "-01-extra",
+		"00-" + strings.Repeat("0", 32) + "-" + spanID + "-01",
+		"00-" + traceID + "-" + strings.Repeat("0", 16) + "-01",
+		"00-" + traceID + "-" + "zzf067aa0ba902b7" + "-01",
+	} {
+		if _, ok := ParseTraceparent(invalid); ok {
+			t.Fatal("Expected invalid traceparent:", invalid)
+		}
+	}
+
+	child := traceContext.Child()
+	if child.TraceID != traceID || child.SpanID == spanID || child.Sampled != traceContext.Sampled || child.Remote {
+		t.Fatal("Expected a child in the same trace with a new span, got:", child)
+	}
+	if _, ok := ParseTraceparent(NewTraceContext().Traceparent()); !ok {
+		t.Fatal("Expected a generated trace context to be valid")
+	}
+}
+
+func TestPropagationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	inbound := NewTraceContext()
+
+	var requestID string
+	var traceContext TraceContext
+	engine := gin.New()
+	engine.GET("/", RequestIDTool{}.Middleware(fixedRequestIDGenerator("svc:generated")), PropagationTool{}.Middleware(), func(c *gin.Context) {
+		ctx, cancel := ContextFromGin(c, time.Second)
+		defer cancel()
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("Expected a deadline on the context")
+		}
+		requestID, _ = RequestIDFromContext(ctx)
+		traceContext, _ = TraceContextFromContext(ctx)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentHeaderKey, inbound.Traceparent())
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if requestID != "svc:generated" {
+		t.Fatal("Expected the request id in the context, got:", requestID)
+	}
+	if traceContext.TraceID != inbound.TraceID || traceContext.SpanID != inbound.SpanID || !traceContext.Remote {
+		t.Fatal("Expected the remote inbound trace context, got:", traceContext)
+	}
+	if w.Header().Get(TraceparentHeaderKey) != traceContext.Traceparent() {
+		t.Fatal("Expected the traceparent of the request in the response, got:", w.Header().Get(TraceparentHeaderKey))
+	}
+}
+
+func TestPropagationTransportHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer server.Close()
+
+	traceContext := NewTraceContext()
+	ctx := WithTraceContext(WithRequestID(context.Background(), "svc:request"), traceContext)
+	client := &http.Client{Transport: &PropagationTransport{}}
+	send := func(req *http.Request) http.Header {
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		resp.Body.Close()
+		return <-received
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	header := send(req)
+	if header.Get(RequestIDHeaderKey) != "svc:request" {
+		t.Fatal("Expected the request id injected, got:", header.Get(RequestIDHeaderKey))
+	}
+	outbound, ok := ParseTraceparent(header.Get(TraceparentHeaderKey))
+	if !ok || outbound.TraceID != traceContext.TraceID || outbound.SpanID == traceContext.SpanID {
+		t.Fatal("Expected a child traceparent injected, got:", header.Get(TraceparentHeaderKey))
+	}
+	if req.Header.Get(RequestIDHeaderKey) != "" || req.Header.Get(TraceparentHeaderKey) != "" {
+		t.Fatal("Expected the original request left unmodified, got:", req.Header)
+	}
+
+	// headers set by the caller are kept
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	req.Header.Set(RequestIDHeaderKey, "caller")
+	if header := send(req); header.Get(RequestIDHeaderKey) != "caller" {
+		t.Fatal("Expected the request id of the caller kept, got:", header.Get(RequestIDHeaderKey))
+	}
+
+	// nothing is injected without a propagated context
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	if header := send(req); header.Get(RequestIDHeaderKey) != "" || header.Get(TraceparentHeaderKey) != "" {
+		t.Fatal("Expected no headers injected, got:", header)
+	}
+}
+
+func TestPropagationTransportBodies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	logged := make(chan [2]any, 2)
+	logger := func(req *HttpRequest, resp *HttpResponse, _ int64) {
+		logged <- [2]any{req, resp}
+	}
+	send := func(limit int, body string) (*HttpRequest, *HttpResponse, string) {
+		client := &http.Client{Transport: &PropagationTransport{Logger: logger, BodyLogLimit: limit}}
+		resp, err := client.Post(server.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		select {
+		case <-logged:
+			t.Fatal("Expected nothing logged before the body is closed")
+		default:
+		}
+		received, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body.Close()
+		exchange := <-logged
+		if len(logged) != 0 {
+			t.Fatal("Expected the exchange logged once")
+		}
+		return exchange[0].(*HttpRequest), exchange[1].(*HttpResponse), string(received)
+	}
+
+	req, resp, received := send(0, `{"name":"value"}`)
+	if received != `{"name":"value"}` {
+		t.Fatal("Expected the caller to read the whole body, got:", received)
+	}
+	if string(req.RawBody) != received || req.JsonBody["name"] != "value" || req.BodyTruncated {
+		t.Fatal("Unexpected logged request body:", string(req.RawBody), req.JsonBody)
+	}
+	if string(resp.Body) != received || resp.JsonBody["name"] != "value" || resp.Size != len(received) || resp.Status != http.StatusOK {
+		t.Fatal("Unexpected logged response:", resp)
+	}
+
+	// only the beginning of a large body is kept
+	large := `{"data":"` + strings.Repeat("x", 1000) + `"}`
+	req, resp, received = send(16, large)
+	if received != large {
+		t.Fatal("Expected the caller to read the whole body, got", len(received), "bytes")
+	}
+	if string(req.RawBody) != large[:16] || !req.BodyTruncated || req.JsonBody != nil {
+		t.Fatal("Expected the request body truncated, got:", string(req.RawBody))
+	}
+	if string(resp.Body) != large[:16] || !resp.BodyTruncated || resp.Size != len(large) {
+		t.Fatal("Expected the response body truncated, got:", string(resp.Body), resp.Size)
+	}
+}
+
+func TestPropagationTransportStreaming(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("second"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	logged := make(chan *HttpResponse, 1)
+	client := &http.Client{Transport: &PropagationTransport{
+		Logger: func(_ *HttpRequest, resp *HttpResponse, _ int64) { logged <- resp },
+	}}
+
+	// RoundTrip returns with the headers, it does not wait for the whole body
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Error("Request failed:", err)
+		}
+		done <- resp
+	}()
+	var resp *http.Response
+	select {
+	case resp = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the response returned before the end of the stream")
+	}
+	if resp == nil {
+		return
+	}
+
+	first := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, first); err != nil || string(first) != "first" {
+		t.Fatal("Expected the first chunk, got:", string(first), err)
+	}
+	resp.Body.Close()
+	select {
+	case logged := <-logged:
+		if string(logged.Body) != "first" {
+			t.Fatal("Expected the bytes read before Close logged, got:", string(logged.Body))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the exchange logged on Close")
+	}
+}
@@ -0,0 +1,509 @@
+package gin_tool
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	QuotaIdentityKey = "_quota_identity"
+
+	APIKeyHeaderKey = "X-API-Key"
+)
+
+// Check if APIKeyHashStore implements IAPIKeyStore
+var (
+	_ IAPIKeyStore = APIKeyHashStore{}
+)
+
+/*
+Quota:
+    1. QuotaManager resolves the identity of a request with its resolvers, in order:
+        API key, JWT subject, identity set by an auth middleware or a gateway header...
+        the client IP is the fallback identity.
+        Resolvers only return verified identities: API keys must be in an IAPIKeyStore,
+        JWTs must be signed with the configured secret, unknown credentials fall back to the client IP.
+        HeaderIdentityResolver trusts its header, which must be set by a gateway that strips it from clients.
+    2. Each identity is assigned a plan (free/pro/internal...), unassigned identities use the default plan.
+        A plan has short-term rate limits and a monthly quota, both keyed by identity.
+    3. Monthly usage is counted per calendar month in UTC and kept in memory,
+        the usage of past months is dropped when a new month starts.
+    4. UsageHandler returns the usage of the caller, inspecting another identity
+        or resetting usage with ResetHandler requires a plan with Admin = true.
+        UsageHandler resolves the caller itself, it does not need Middleware and counts no request.
+    5. Middleware counts a request in the monthly quota before the rate limits of the plan,
+        a request rejected by a rate limit is uncounted, a request over the quota is not rate limited.
+*/
+// QuotaPlan is a tier of limits.
+type QuotaPlan struct {
+	Name   string          `json:"name"`
+	Limits []RateLimitRule `json:"limits,omitempty"`
+	// MonthlyQuota is the number of requests per calendar month, 0 means unlimited.
+	MonthlyQuota int64 `json:"monthly_quota"`
+	// Admin allows the plan to inspect and reset the usage of other identities.
+	Admin bool `json:"admin,omitempty"`
+}
+
+// QuotaConfig is the content of a quota config file.
+type QuotaConfig struct {
+	Plans       []QuotaPlan `json:"plans"`
+	DefaultPlan string      `json:"default_plan"`
+	// APIKeys maps the hex SHA-256 of API keys to names, the identity of a key is "api_key:<name>",
+	// see HashAPIKey.
+	APIKeys map[string]string `json:"api_keys,omitempty"`
+	// Assignments maps identities (e.g. "api_key:service-a", "jwt:user-1") to plan names.
+	Assignments map[string]string `json:"assignments"`
+}
+
+// QuotaIdentity is the resolved identity of a request.
+type QuotaIdentity struct {
+	// ID is prefixed by its source, e.g. "api_key:service-a", "jwt:user-1", "ip:127.0.0.1".
+	ID   string `json:"id"`
+	Plan string `json:"plan"`
+}
+
+// QuotaUsage is the monthly usage of an identity.
+type QuotaUsage struct {
+	Identity  string    `json:"identity"`
+	Plan      string    `json:"plan"`
+	Month     string    `json:"month"`
+	Used      int64     `json:"used"`
+	Quota     int64     `json:"quota"`
+	Remaining int64     `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// QuotaIdentityResolver returns the identity of a request, or "" if it can not resolve one.
+type QuotaIdentityResolver func(c *gin.Context) string
+
+// IAPIKeyStore checks API keys.
+type IAPIKeyStore interface {
+	// Lookup returns the name of apiKey, ok is false for unknown keys.
+	Lookup(apiKey string) (name string, ok bool)
+}
+
+// APIKeyHashStore maps the hex SHA-256 of API keys to names,
+// so that the config does not hold the keys themselves.
+type APIKeyHashStore map[string]string
+
+func (s APIKeyHashStore) Lookup(apiKey string) (string, bool) {
+	name, ok := s[HashAPIKey(apiKey)]
+	return name, ok && name != ""
+}
+
+// HashAPIKey returns the hex SHA-256 of an API key, the key of APIKeyHashStore.
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyIdentityResolver resolves the identity from an API key header,
+// keys unknown to store are ignored.
+func APIKeyIdentityResolver(header string, store IAPIKeyStore) QuotaIdentityResolver {
+	return func(c *gin.Context) string {
+		apiKey := c.GetHeader(header)
+		if apiKey == "" {
+			return ""
+		}
+		if name, ok := store.Lookup(apiKey); ok {
+			return "api_key:" + name
+		}
+		return ""
+	}
+}
+
+// JWTSubjectIdentityResolver resolves the identity from the "sub" claim of a Bearer JWT signed
+// with HS256 and secret. Tokens with another alg, a bad signature, or out of exp/nbf are ignored,
+// an empty secret ignores every token.
+func JWTSubjectIdentityResolver(secret []byte) QuotaIdentityResolver {
+	return func(c *gin.Context) string {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || len(secret) == 0 {
+			return ""
+		}
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return ""
+		}
+
+		header := struct {
+			Algorithm string `json:"alg"`
+		}{}
+		if !decodeJWTPart(parts[0], &header) || header.Algorithm != "HS256" {
+			return ""
+		}
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return ""
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ""
+		}
+
+		claims := struct {
+			Subject   string `json:"sub"`
+			ExpiresAt *int64 `json:"exp"`
+			NotBefore *int64 `json:"nbf"`
+		}{}
+		if !decodeJWTPart(parts[1], &claims) || claims.Subject == "" {
+			return ""
+		}
+		now := time.Now().Unix()
+		if claims.ExpiresAt != nil && now >= *claims.ExpiresAt || claims.NotBefore != nil && now < *claims.NotBefore {
+			return ""
+		}
+		return "jwt:" + claims.Subject
+	}
+}
+
+// decodeJWTPart decodes a base64url JSON part of a JWT into v.
+func decodeJWTPart(part string, v any) bool {
+	content, err := base64.RawURLEncoding.DecodeString(part)
+	return err == nil && json.Unmarshal(content, v) == nil
+}
+
+// ContextIdentityResolver resolves the identity set with c.Set(key, id) by an auth middleware
+// registered before the quota middleware.
+func ContextIdentityResolver(key string) QuotaIdentityResolver {
+	return func(c *gin.Context) string {
+		if id := c.GetString(key); id != "" {
+			return "auth:" + id
+		}
+		return ""
+	}
+}
+
+// HeaderIdentityResolver resolves the identity from a header set by a gateway,
+// e.g. "X-User-ID". The header is trusted as is, the gateway must strip it from client requests.
+func HeaderIdentityResolver(header string) QuotaIdentityResolver {
+	return func(c *gin.Context) string {
+		if value := c.GetHeader(header); value != "" {
+			return "header:" + value
+		}
+		return ""
+	}
+}
+
+type quotaPlan struct {
+	QuotaPlan
+	limiters []IRateLimiter
+}
+
+type quotaUsage struct {
+	month string
+	used  int64
+}
+
+// QuotaManager enforces plans for identities.
+type QuotaManager struct {
+	// HeaderStyle is the RateLimitTool.HeaderStyle of the plan rate limits.
+	HeaderStyle string
+
+	plans       map[string]*quotaPlan
+	defaultPlan string
+	resolvers   []QuotaIdentityResolver
+	now         func() time.Time
+
+	mu          sync.Mutex
+	assignments map[string]string
+	usage       map[string]*quotaUsage
+	// month is the month of the latest counted request
+	month string
+}
+
+// LoadQuotaConfig loads a QuotaConfig from a JSON file.
+func LoadQuotaConfig(filePath string) (*QuotaConfig, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	quotaConfig := QuotaConfig{}
+	if err := json.Unmarshal(content, &quotaConfig); err != nil {
+		return nil, fmt.Errorf("invalid quota config file %s: %w", filePath, err)
+	}
+	return &quotaConfig, nil
+}
+
+func NewQuotaManager(
+	quotaConfig *QuotaConfig, resolvers ...QuotaIdentityResolver,
+) (*QuotaManager, error) {
+	m := &QuotaManager{
+		plans:       map[string]*quotaPlan{},
+		defaultPlan: quotaConfig.DefaultPlan,
+		resolvers:   resolvers,
+		now:         time.Now,
+		assignments: map[string]string{},
+		usage:       map[string]*quotaUsage{},
+	}
+	for _, plan := range quotaConfig.Plans {
+		p := &quotaPlan{QuotaPlan: plan}
+		for _, rule := range plan.Limits {
+			period, err := time.ParseDuration(rule.Period)
+			if err != nil {
+				return nil, fmt.Errorf("plan %s: invalid period %q: %w", plan.Name, rule.Period, err)
+			}
+			rateLimiter, err := NewRateLimiter(rule.Algorithm, RateLimitRate{
+				Limit:  rule.Limit,
+				Period: period,
+				Burst:  rule.Burst,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("plan %s: %w", plan.Name, err)
+			}
+			p.limiters = append(p.limiters, rateLimiter)
+		}
+		m.plans[plan.Name] = p
+	}
+	if _, ok := m.plans[m.defaultPlan]; !ok {
+		return nil, fmt.Errorf("unknown default plan: %q", m.defaultPlan)
+	}
+	for identity, plan := range quotaConfig.Assignments {
+		if err := m.AssignPlan(identity, plan); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// AssignPlan assigns a plan to an identity.
+func (m *QuotaManager) AssignPlan(identity string, plan string) error {
+	if _, ok := m.plans[plan]; !ok {
+		return fmt.Errorf("unknown plan: %q", plan)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.assignments[identity] = plan
+	return nil
+}
+
+// Resolve returns the identity and plan of a request.
+func (m *QuotaManager) Resolve(c *gin.Context) QuotaIdentity {
+	id := ""
+	for _, resolver := range m.resolvers {
+		if id = resolver(c); id != "" {
+			break
+		}
+	}
+	if id == "" {
+		id = "ip:" + c.ClientIP()
+	}
+	return QuotaIdentity{ID: id, Plan: m.planOf(id)}
+}
+
+func (m *QuotaManager) planOf(identity string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if plan, ok := m.assignments[identity]; ok {
+		return plan
+	}
+	return m.defaultPlan
+}
+
+// Consume counts one request of the identity, it returns false if the monthly quota is exhausted.
+func (m *QuotaManager) Consume(identity QuotaIdentity) (QuotaUsage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := m.currentUsage(identity.ID)
+	plan := m.plans[identity.Plan]
+	allowed := plan.MonthlyQuota == 0 || usage.used < plan.MonthlyQuota
+	if allowed {
+		usage.used++
+	}
+	return m.buildUsage(identity, usage), allowed
+}
+
+// release uncounts a request counted by Consume in the current month.
+func (m *QuotaManager) release(identity QuotaIdentity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage, ok := m.usage[identity.ID]
+	if ok && usage.month == m.now().UTC().Format("2006-01") && usage.used > 0 {
+		usage.used--
+	}
+}
+
+// Usage returns the monthly usage of an identity.
+func (m *QuotaManager) Usage(identity string) QuotaUsage {
+	plan := m.planOf(identity)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage, ok := m.usage[identity]
+	if month := m.now().UTC().Format("2006-01"); !ok || usage.month != month {
+		// looking up an identity does not keep it
+		usage = &quotaUsage{month: month}
+	}
+	return m.buildUsage(QuotaIdentity{ID: identity, Plan: plan}, usage)
+}
+
+// Reset resets the monthly usage of an identity, or of every identity if identity is "".
+func (m *QuotaManager) Reset(identity string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if identity == "" {
+		m.usage = map[string]*quotaUsage{}
+		return
+	}
+	delete(m.usage, identity)
+}
+
+// currentUsage must be called with m.mu held.
+func (m *QuotaManager) currentUsage(identity string) *quotaUsage {
+	month := m.now().UTC().Format("2006-01")
+	if month != m.month {
+		// a new month starts, the usage of past months is not needed anymore
+		for id, usage := range m.usage {
+			if usage.month != month {
+				delete(m.usage, id)
+			}
+		}
+		m.month = month
+	}
+	usage, ok := m.usage[identity]
+	if !ok || usage.month != month {
+		usage = &quotaUsage{month: month}
+		m.usage[identity] = usage
+	}
+	return usage
+}
+
+func (m *QuotaManager) buildUsage(identity QuotaIdentity, usage *quotaUsage) QuotaUsage {
+	now := m.now().UTC()
+	quota := m.plans[identity.Plan].MonthlyQuota
+	result := QuotaUsage{
+		Identity: identity.ID,
+		Plan:     identity.Plan,
+		Month:    usage.month,
+		Used:     usage.used,
+		Quota:    quota,
+		Reset:    time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if quota > 0 {
+		result.Remaining = max(quota-usage.used, 0)
+	}
+	return result
+}
+
+// Middleware enforces the monthly quota and the rate limits of the plan of each request.
+func (m *QuotaManager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := m.Resolve(c)
+		c.Set(QuotaIdentityKey, identity)
+		plan := m.plans[identity.Plan]
+
+		// the quota is checked first, so a request over the quota is not counted by the rate limits
+		usage, allowed := m.Consume(identity)
+		if !allowed {
+			setQuotaHeaders(c, usage)
+			c.Header("Retry-After", fmt.Sprintf("%d", max(ceilSeconds(time.Until(usage.Reset)), 1)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse(
+				http.StatusTooManyRequests, "Monthly quota exceeded", &usage,
+			))
+			return
+		}
+
+		keys := make([]string, len(plan.limiters))
+		for i := range plan.limiters {
+			keys[i] = fmt.Sprintf("quota:%s:%d", identity.ID, i)
+		}
+		if !(RateLimitTool{HeaderStyle: m.HeaderStyle}).applyLimits(c, rateLimitSource{
+			policy:     "quota:" + identity.Plan,
+			identifier: identity.ID,
+		}, plan.limiters, keys) {
+			// a rate limited request does not use the quota
+			m.release(identity)
+			return
+		}
+		setQuotaHeaders(c, usage)
+	}
+}
+
+func setQuotaHeaders(c *gin.Context, usage QuotaUsage) {
+	c.Header("X-Quota-Plan", usage.Plan)
+	if usage.Quota > 0 {
+		c.Header("X-Quota-Limit", fmt.Sprintf("%d", usage.Quota))
+		c.Header("X-Quota-Remaining", fmt.Sprintf("%d", usage.Remaining))
+		c.Header("X-Quota-Reset", fmt.Sprintf("%d", usage.Reset.Unix()))
+	}
+}
+
+// GetQuotaIdentity returns the identity resolved by QuotaManager.Middleware.
+func GetQuotaIdentity(c *gin.Context) (QuotaIdentity, bool) {
+	identityRaw, ok := c.Get(QuotaIdentityKey)
+	if !ok {
+		return QuotaIdentity{}, false
+	}
+	identity, ok := identityRaw.(QuotaIdentity)
+	return identity, ok
+}
+
+// targetIdentity returns the identity a usage request is about,
+// another identity than the caller requires an admin plan.
+func (m *QuotaManager) targetIdentity(c *gin.Context, target string) (string, bool) {
+	caller, ok := GetQuotaIdentity(c)
+	if !ok {
+		caller = m.Resolve(c)
+	}
+	if target == "" || target == caller.ID {
+		return caller.ID, true
+	}
+	return target, m.plans[caller.Plan].Admin
+}
+
+// UsageHandler returns the usage of the caller, or of ?identity= for admin plans.
+func (m *QuotaManager) UsageHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, ok := m.targetIdentity(c, c.Query("identity"))
+		if !ok {
+			c.JSON(http.StatusForbidden, ErrorResponse[struct{}](
+				http.StatusForbidden, http.StatusText(http.StatusForbidden), nil,
+			))
+			return
+		}
+		usage := m.Usage(identity)
+		c.JSON(http.StatusOK, SuccessResponse(&usage))
+	}
+}
+
+// ResetHandler resets the usage of {"identity": ...}, or of every identity with {"all": true},
+// it requires an admin plan.
+func (m *QuotaManager) ResetHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := struct {
+			Identity string `json:"identity"`
+			All      bool   `json:"all"`
+		}{}
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Identity == "") == !req.All {
+			c.JSON(http.StatusBadRequest, ErrorResponse[struct{}](
+				http.StatusBadRequest, "Invalid request: one of identity and all is required", nil,
+			))
+			return
+		}
+
+		caller, ok := GetQuotaIdentity(c)
+		if !ok {
+			caller = m.Resolve(c)
+		}
+		if !m.plans[caller.Plan].Admin {
+			c.JSON(http.StatusForbidden, ErrorResponse[struct{}](
+				http.StatusForbidden, http.StatusText(http.StatusForbidden), nil,
+			))
+			return
+		}
+		m.Reset(req.Identity)
+		c.JSON(http.StatusOK, SuccessResponse(&req.Identity))
+	}
+}
@@ -0,0 +1,319 @@
+package gin_tool
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testJWTSecret = []byte("test-secret")
+
+// signJWT creates a JWT with header and claims signed by HS256 and secret.
+func signJWT(secret []byte, header map[string]any, claims map[string]any) string {
+	encode := func(v map[string]any) string {
+		content, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(content)
+	}
+	signed := encode(header) + "." + encode(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newTestQuotaManager(t *testing.T) *QuotaManager {
+	gin.SetMode(gin.TestMode)
+	manager, err := NewQuotaManager(&QuotaConfig{
+		Plans: []QuotaPlan{
+			{Name: "free", MonthlyQuota: 2},
+			{Name: "pro", Limits: []RateLimitRule{{Limit: 1, Period: "1m", Algorithm: RateLimitAlgorithmGCRA}}},
+			{Name: "internal", Admin: true},
+		},
+		DefaultPlan: "free",
+		APIKeys: map[string]string{
+			HashAPIKey("key-a"):     "service-a",
+			HashAPIKey("admin-key"): "ops",
+		},
+		Assignments: map[string]string{
+			"api_key:service-a": "pro",
+			"api_key:ops":       "internal",
+		},
+	},
+		ContextIdentityResolver("user_id"),
+		APIKeyIdentityResolver(APIKeyHeaderKey, APIKeyHashStore(map[string]string{
+			HashAPIKey("key-a"):     "service-a",
+			HashAPIKey("admin-key"): "ops",
+		})),
+		JWTSubjectIdentityResolver(testJWTSecret),
+	)
+	if err != nil {
+		t.Fatal("NewQuotaManager failed:", err)
+	}
+	return manager
+}
+
+// resolveQuota resolves the identity of a request from 10.0.0.1 with header.
+func resolveQuota(manager *QuotaManager, header map[string]string) QuotaIdentity {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.RemoteAddr = "10.0.0.1:1234"
+	for name, value := range header {
+		c.Request.Header.Set(name, value)
+	}
+	return manager.Resolve(c)
+}
+
+func TestQuota(t *testing.T) {
+	t.Run("API Key", TestQuotaAPIKeyResolver)
+	t.Run("JWT", TestQuotaJWTResolver)
+	t.Run("Context Identity", TestQuotaContextResolver)
+	t.Run("Header Identity", TestQuotaHeaderResolver)
+	t.Run("Plans", TestQuotaPlans)
+	t.Run("Monthly Quota", TestMonthlyQuota)
+	t.Run("Admin", TestQuotaAdmin)
+}
+
+func TestQuotaAPIKeyResolver(t *testing.T) {
+	manager := newTestQuotaManager(t)
+
+	if identity := resolveQuota(manager, map[string]string{APIKeyHeaderKey: "key-a"}); identity.ID != "api_key:service-a" || identity.Plan != "pro" {
+		t.Fatal("Expected the known key resolved to its name and plan, got:", identity)
+	}
+	// unknown keys fall back to the client IP and the default plan
+	for _, apiKey := range []string{"unknown", "", HashAPIKey("key-a")} {
+		if identity := resolveQuota(manager, map[string]string{APIKeyHeaderKey: apiKey}); identity.ID != "ip:10.0.0.1" || identity.Plan != "free" {
+			t.Fatalf("Expected key %q resolved to the client IP, got: %v", apiKey, identity)
+		}
+	}
+}
+
+func TestQuotaJWTResolver(t *testing.T) {
+	manager := newTestQuotaManager(t)
+	header := map[string]any{"alg": "HS256", "typ": "JWT"}
+	now := time.Now().Unix()
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	valid := signJWT(testJWTSecret, header, map[string]any{"sub": "user-1", "exp": now + 60})
+	if identity := resolveQuota(manager, bearer(valid)); identity.ID != "jwt:user-1" {
+		t.Fatal("Expected the subject of a valid token, got:", identity)
+	}
+
+	parts := strings.Split(valid, ".")
+	forgedClaims, _ := json.Marshal(map[string]any{"sub": "admin", "exp": now + 60})
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	for name, token := range map[string]string{
+		"wrong secret":  signJWT([]byte("other"), header, map[string]any{"sub": "user-1"}),
+		"forged claims": parts[0] + "." + base64.RawURLEncoding.EncodeToString(forgedClaims) + "." + parts[2],
+		"alg none":      unsigned + "." + parts[1] + ".",
+		"expired":       signJWT(testJWTSecret, header, map[string]any{"sub": "user-1", "exp": now - 1}),
+		"not yet valid": signJWT(testJWTSecret, header, map[string]any{"sub": "user-1", "nbf": now + 60}),
+		"no subject":    signJWT(testJWTSecret, header, map[string]any{"exp": now + 60}),
+		"malformed":     "a.b",
+	} {
+		if identity := resolveQuota(manager, bearer(token)); identity.ID != "ip:10.0.0.1" {
+			t.Fatalf("Expected the %s token ignored, got: %v", name, identity)
+		}
+	}
+
+	// without a secret no token is trusted
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header.Set("Authorization", "Bearer "+signJWT(nil, header, map[string]any{"sub": "user-1"}))
+	if id := JWTSubjectIdentityResolver(nil)(c); id != "" {
+		t.Fatal("Expected no identity without a secret, got:", id)
+	}
+}
+
+func TestQuotaContextResolver(t *testing.T) {
+	manager := newTestQuotaManager(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header.Set(APIKeyHeaderKey, "key-a")
+	// the identity set by an auth middleware comes first
+	c.Set("user_id", "user-2")
+	if identity := manager.Resolve(c); identity.ID != "auth:user-2" || identity.Plan != "free" {
+		t.Fatal("Expected the identity of the auth middleware, got:", identity)
+	}
+}
+
+func TestQuotaHeaderResolver(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	resolver := HeaderIdentityResolver("X-User-ID")
+	if id := resolver(c); id != "" {
+		t.Fatal("Expected no identity without the header, got:", id)
+	}
+	c.Request.Header.Set("X-User-ID", "user-3")
+	if id := resolver(c); id != "header:user-3" {
+		t.Fatal("Expected the identity of the header, got:", id)
+	}
+}
+
+func TestQuotaPlans(t *testing.T) {
+	manager := newTestQuotaManager(t)
+	if err := manager.AssignPlan("jwt:user-1", "pro"); err != nil {
+		t.Fatal("AssignPlan failed:", err)
+	}
+	if err := manager.AssignPlan("jwt:user-1", "gold"); err == nil {
+		t.Fatal("Expected an error for an unknown plan")
+	}
+	token := signJWT(testJWTSecret, map[string]any{"alg": "HS256"}, map[string]any{"sub": "user-1"})
+	if identity := resolveQuota(manager, map[string]string{"Authorization": "Bearer " + token}); identity.Plan != "pro" {
+		t.Fatal("Expected the assigned plan, got:", identity)
+	}
+
+	// the pro plan allows one request per minute
+	engine := gin.New()
+	engine.GET("/limited", manager.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.Header.Set(APIKeyHeaderKey, "key-a")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+		if w.Header().Get("X-Quota-Plan") != "pro" && w.Code == http.StatusOK {
+			t.Fatal("Expected the plan header, got:", w.Header())
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Fatal("Expected the rate limit of the plan, got:", codes)
+	}
+	// the rate limited request does not use the quota
+	if usage := manager.Usage("api_key:service-a"); usage.Used != 1 {
+		t.Fatal("Expected only the allowed request counted, got:", usage)
+	}
+
+	// a request over the quota is not counted by the rate limits
+	fixedWindow, err := NewRateLimiter(RateLimitAlgorithmFixedWindow, RateLimitRate{Limit: 2, Period: time.Hour})
+	if err != nil {
+		t.Fatal("NewRateLimiter failed:", err)
+	}
+	manager.plans["free"].MonthlyQuota = 1
+	manager.plans["free"].limiters = []IRateLimiter{fixedWindow}
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/limited", nil))
+		if w.Code != expected {
+			t.Fatal("Expected status", expected, "for request", i, "got:", w.Code)
+		}
+	}
+	result, err := fixedWindow.(IRateLimitPeeker).Peek(context.Background(), "quota:ip:192.0.2.1:0")
+	if err != nil || result.Remaining != 1 {
+		t.Fatal("Expected only the request within the quota rate limited, got:", result, err)
+	}
+
+	if _, err := NewQuotaManager(&QuotaConfig{Plans: []QuotaPlan{{Name: "free"}}, DefaultPlan: "pro"}); err == nil {
+		t.Fatal("Expected an error for an unknown default plan")
+	}
+	if _, err := NewQuotaManager(&QuotaConfig{
+		Plans: []QuotaPlan{{Name: "free"}}, DefaultPlan: "free", Assignments: map[string]string{"ip:1": "pro"},
+	}); err == nil {
+		t.Fatal("Expected an error for an unknown assigned plan")
+	}
+}
+
+func TestMonthlyQuota(t *testing.T) {
+	manager := newTestQuotaManager(t)
+	clock := &fakeClock{now: time.Date(2025, 1, 31, 23, 0, 0, 0, time.UTC)}
+	manager.now = clock.Now
+
+	identity := QuotaIdentity{ID: "ip:10.0.0.1", Plan: "free"}
+	for i := 0; i < 2; i++ {
+		if _, allowed := manager.Consume(identity); !allowed {
+			t.Fatal("Expected request", i, "within the quota")
+		}
+	}
+	usage, allowed := manager.Consume(identity)
+	if allowed || usage.Used != 2 || usage.Remaining != 0 || usage.Reset != time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC) {
+		t.Fatal("Expected the quota exhausted until February, got:", usage, allowed)
+	}
+
+	// looking up other identities does not keep them
+	for _, id := range []string{"ip:10.0.0.2", "api_key:unknown"} {
+		if usage := manager.Usage(id); usage.Used != 0 {
+			t.Fatal("Expected no usage, got:", usage)
+		}
+	}
+	if len(manager.usage) != 1 {
+		t.Fatal("Expected only the counted identity kept, got:", len(manager.usage))
+	}
+
+	// a new month restores the quota and drops the usage of the past month
+	clock.Advance(time.Hour)
+	manager.Consume(QuotaIdentity{ID: "ip:10.0.0.3", Plan: "free"})
+	if len(manager.usage) != 1 || manager.Usage(identity.ID).Used != 0 {
+		t.Fatal("Expected the usage of January dropped, got:", len(manager.usage))
+	}
+	if _, allowed := manager.Consume(identity); !allowed {
+		t.Fatal("Expected the quota restored in February")
+	}
+}
+
+func TestQuotaAdmin(t *testing.T) {
+	manager := newTestQuotaManager(t)
+	manager.plans["free"].MonthlyQuota = 0
+	engine := gin.New()
+	engine.GET("/limited", manager.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	engine.GET("/usage", manager.UsageHandler())
+	engine.POST("/reset", manager.Middleware(), manager.ResetHandler())
+	serve := func(method string, target string, apiKey string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		if apiKey != "" {
+			req.Header.Set(APIKeyHeaderKey, apiKey)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	usageOf := func(w *httptest.ResponseRecorder) QuotaUsage {
+		body := CommonResponse[QuotaUsage]{}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return *body.Data
+	}
+
+	serve(http.MethodGet, "/limited", "", "")
+	// looking up the usage does not count a request
+	for i := 0; i < 2; i++ {
+		if w := serve(http.MethodGet, "/usage", "", ""); w.Code != http.StatusOK || usageOf(w).Identity != "ip:10.0.0.1" || usageOf(w).Used != 1 {
+			t.Fatal("Expected the usage of the caller, got:", w.Code, w.Body.String())
+		}
+	}
+
+	// only admin plans inspect and reset other identities, an unknown key is not an admin
+	if w := serve(http.MethodGet, "/usage?identity=ip:10.0.0.2", "key-a", ""); w.Code != http.StatusForbidden {
+		t.Fatal("Expected usage of another identity forbidden for a pro plan, got:", w.Code)
+	}
+	for _, apiKey := range []string{"", "local-internal-key"} {
+		if w := serve(http.MethodGet, "/usage?identity=ip:10.0.0.2", apiKey, ""); w.Code != http.StatusForbidden {
+			t.Fatalf("Expected usage of another identity forbidden with key %q, got: %d", apiKey, w.Code)
+		}
+		if w := serve(http.MethodPost, "/reset", apiKey, `{"all": true}`); w.Code != http.StatusForbidden {
+			t.Fatalf("Expected reset forbidden with key %q, got: %d", apiKey, w.Code)
+		}
+	}
+
+	if w := serve(http.MethodGet, "/usage?identity=ip:10.0.0.1", "admin-key", ""); w.Code != http.StatusOK || usageOf(w).Used == 0 {
+		t.Fatal("Expected the admin to inspect another identity, got:", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, "/reset", "admin-key", `{"identity": "ip:10.0.0.1"}`); w.Code != http.StatusOK {
+		t.Fatal("Expected the admin to reset an identity, got:", w.Code)
+	}
+	if usage := manager.Usage("ip:10.0.0.1"); usage.Used != 0 {
+		t.Fatal("Expected the usage reset, got:", usage)
+	}
+	if w := serve(http.MethodPost, "/reset", "admin-key", `{"identity": "ip:10.0.0.1", "all": true}`); w.Code != http.StatusBadRequest {
+		t.Fatal("Expected a bad request with both identity and all, got:", w.Code)
+	}
+}
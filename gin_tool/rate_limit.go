@@ -3,82 +3,287 @@ package gin_tool
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
 	"github.com/ulule/limiter"
 	memory_store "github.com/ulule/limiter/drivers/store/memory"
-	redis_store "github.com/ulule/limiter/drivers/store/redis"
 )
 
-type RateLimitTool struct{}
+const (
+	// RateLimitHeaderLegacy emits X-RateLimit-Limit/Remaining/Reset, Reset is a unix timestamp.
+	RateLimitHeaderLegacy = "legacy"
+	// RateLimitHeaderIETF emits RateLimit and RateLimit-Policy of the IETF httpapi draft.
+	RateLimitHeaderIETF = "ietf"
+	// RateLimitHeaderBoth emits both.
+	RateLimitHeaderBoth = "both"
+)
+
+/*
+RateLimitTool:
+    1. HeaderStyle selects the rate limit response headers, empty means RateLimitHeaderLegacy:
+        - legacy: X-RateLimit-Limit: 10, X-RateLimit-Remaining: 5, X-RateLimit-Reset: 1700000000
+        - ietf:   RateLimit: limit=10, remaining=5, reset=3
+                  RateLimit-Policy: 10;w=1, 1000;w=3600
+    2. Rejected requests always get Retry-After in seconds,
+        and a CommonResponse with RateLimitExceeded as data.
+    3. FailureMode decides what happens when the limiter returns an error, empty means RateLimitFailClosed:
+        - closed: the request is rejected with 503 and Retry-After.
+        - open:   the request is let through without limit.
+        Wrap limiters with NewFallbackRateLimiter to keep limiting locally while the store is down.
+    4. Shadow computes limits without rejecting, decisions are recorded in ShadowReport,
+        see RateLimitShadowReport.
+*/
+// RateLimitTool is the rate limit middleware factory.
+type RateLimitTool struct {
+	HeaderStyle  string
+	FailureMode  string
+	Shadow       bool
+	ShadowReport *RateLimitShadowReport
+}
+
+// rateLimitSource describes which limit a result comes from.
+type rateLimitSource struct {
+	policy     string
+	identifier string
+	shadow     bool
+}
+
+// RateLimitExceeded is the data of the 429 response.
+type RateLimitExceeded struct {
+	// Policy is the RateLimit-Policy of the route, e.g. "10;w=1, 1000;w=3600".
+	Policy     string    `json:"policy"`
+	Limit      int64     `json:"limit"`
+	Remaining  int64     `json:"remaining"`
+	Reset      time.Time `json:"reset"`
+	RetryAfter int64     `json:"retry_after"`
+}
 
+// Middleware is NewMiddleware panicking for an invalid rate.
 func (t RateLimitTool) Middleware(
 	store limiter.Store, rateLimit int64, period int,
 	identifierBuilder func(c *gin.Context) string,
 ) gin.HandlerFunc {
-	rate := limiter.Rate{
+	middleware, err := t.NewMiddleware(store, rateLimit, period, identifierBuilder)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create rate limiter: %v", err))
+	}
+	return middleware
+}
+
+// NewMiddleware limits requests to rateLimit per period seconds in store,
+// it returns an error for an invalid rate.
+func (t RateLimitTool) NewMiddleware(
+	store limiter.Store, rateLimit int64, period int,
+	identifierBuilder func(c *gin.Context) string,
+) (gin.HandlerFunc, error) {
+	rate := RateLimitRate{
 		Limit:  rateLimit,
 		Period: time.Duration(period) * time.Second,
 	}
-	limiterInstance := limiter.New(store, rate)
+	rateLimiter, err := NewStoreRateLimiter(store, rate)
+	if err != nil {
+		return nil, err
+	}
+	return t.MiddlewareWithLimiter(rateLimiter, identifierBuilder), nil
+}
 
+// MiddlewareWithLimiter limits requests with any IRateLimiter, see NewRateLimiter.
+func (t RateLimitTool) MiddlewareWithLimiter(
+	rateLimiter IRateLimiter, identifierBuilder func(c *gin.Context) string,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		identifier := identifierBuilder(c)
-		context, err := limiterInstance.Get(c, identifier)
+		result, err := rateLimiter.Allow(c, identifier)
 
 		if err != nil {
-			c.AbortWithStatusJSON(
-				http.StatusInternalServerError,
-				ErrorResponse(
-					http.StatusInternalServerError,
-					fmt.Sprintf("Rate limit error: %v", err),
-					&struct{}{},
-				))
+			t.applyError(c, err)
 			return
 		}
 
-		// set response headers
-		c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", rate.Limit))
-		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", context.Remaining))
-		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", context.Reset))
+		t.applyResult(c, rateLimitSource{
+			policy:     FormatRateLimitPolicy(rateLimiter.Rate()),
+			identifier: identifier,
+		}, result, rateLimiter.Rate())
+	}
+}
+
+// applyResult sets the rate limit headers and aborts the request if the limit is reached,
+// it returns whether the request is allowed. rates are the limits checked for the request.
+func (t RateLimitTool) applyResult(
+	c *gin.Context, source rateLimitSource, result *RateLimitResult, rates ...RateLimitRate,
+) bool {
+	policy := FormatRateLimitPolicy(rates...)
+	resetSeconds := ceilSeconds(time.Until(result.Reset))
+
+	// set response headers
+	if t.HeaderStyle == "" || t.HeaderStyle == RateLimitHeaderLegacy || t.HeaderStyle == RateLimitHeaderBoth {
+		c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", result.Limit))
+		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", result.Remaining))
+		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", result.Reset.Unix()))
+	}
+	if t.HeaderStyle == RateLimitHeaderIETF || t.HeaderStyle == RateLimitHeaderBoth {
+		c.Header("RateLimit", fmt.Sprintf(
+			"limit=%d, remaining=%d, reset=%d", result.Limit, result.Remaining, resetSeconds,
+		))
+		if policy != "" {
+			c.Header("RateLimit-Policy", policy)
+		}
+	}
 
-		// check if the rate limit is reached
-		if context.Reached {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, CommonResponse[struct{}]{
-				Code:    http.StatusTooManyRequests,
-				Message: http.StatusText(http.StatusTooManyRequests),
+	// in shadow mode the request is only recorded
+	if t.Shadow || source.shadow {
+		if t.ShadowReport != nil {
+			t.ShadowReport.Record(&RateLimitShadowEvent{
+				Policy:     source.policy,
+				Identifier: source.identifier,
+				Method:     c.Request.Method,
+				Path:       c.FullPath(),
+				Result:     result,
 			})
-			return
 		}
+		if result.Reached {
+			c.Header(RateLimitShadowHeaderKey, "throttled")
+		}
+		return true
+	}
+
+	// check if the rate limit is reached
+	if result.Reached {
+		retryAfter := max(ceilSeconds(result.RetryAfter), 1)
+		c.Header("Retry-After", fmt.Sprintf("%d", retryAfter))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse(
+			http.StatusTooManyRequests,
+			http.StatusText(http.StatusTooManyRequests),
+			&RateLimitExceeded{
+				Policy:     policy,
+				Limit:      result.Limit,
+				Remaining:  result.Remaining,
+				Reset:      result.Reset,
+				RetryAfter: retryAfter,
+			},
+		))
+		return false
+	}
+	return true
+}
+
+// applyError handles an error of the limiter according to FailureMode,
+// it returns whether the request is allowed.
+func (t RateLimitTool) applyError(c *gin.Context, err error) bool {
+	if t.FailureMode == RateLimitFailOpen {
+		return true
+	}
+	c.Header("Retry-After", fmt.Sprintf("%d", ceilSeconds(DefaultRateLimitRetryInterval)))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrorResponse(
+		http.StatusServiceUnavailable,
+		fmt.Sprintf("Rate limit error: %v", err),
+		&struct{}{},
+	))
+	return false
+}
+
+// FormatRateLimitPolicy formats rates as a RateLimit-Policy header value.
+func FormatRateLimitPolicy(rates ...RateLimitRate) string {
+	policies := make([]string, 0, len(rates))
+	for _, rate := range rates {
+		policy := fmt.Sprintf("%d;w=%d", rate.Limit, ceilSeconds(rate.Period))
+		if rate.Burst > 0 && rate.Burst != rate.Limit {
+			policy += fmt.Sprintf(";burst=%d", rate.Burst)
+		}
+		policies = append(policies, policy)
+	}
+	return strings.Join(policies, ", ")
+}
+
+// ceilSeconds rounds a duration up to whole seconds, negative durations are 0.
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
 
+// MiddlewareWithAlgorithm is NewMiddlewareWithAlgorithm panicking for an invalid algorithm or rate.
+func (t RateLimitTool) MiddlewareWithAlgorithm(
+	algorithm string, rate RateLimitRate,
+	identifierBuilder func(c *gin.Context) string,
+) gin.HandlerFunc {
+	middleware, err := t.NewMiddlewareWithAlgorithm(algorithm, rate, identifierBuilder)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create rate limiter: %v", err))
+	}
+	return middleware
+}
+
+// NewMiddlewareWithAlgorithm limits requests with an in-memory limiter of the algorithm,
+// it returns an error for an invalid algorithm or rate.
+func (t RateLimitTool) NewMiddlewareWithAlgorithm(
+	algorithm string, rate RateLimitRate,
+	identifierBuilder func(c *gin.Context) string,
+) (gin.HandlerFunc, error) {
+	rateLimiter, err := NewRateLimiter(algorithm, rate)
+	if err != nil {
+		return nil, err
 	}
+	return t.MiddlewareWithLimiter(rateLimiter, identifierBuilder), nil
 }
 
+// MiddlewareWithMemoryStore is NewMiddlewareWithMemoryStore panicking for an invalid rate.
 func (t RateLimitTool) MiddlewareWithMemoryStore(
 	rateLimit int64, period int,
 	identifierBuilder func(c *gin.Context) string,
 ) gin.HandlerFunc {
+	middleware, err := t.NewMiddlewareWithMemoryStore(rateLimit, period, identifierBuilder)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create rate limiter: %v", err))
+	}
+	return middleware
+}
+
+// NewMiddlewareWithMemoryStore limits requests with a memory store,
+// it returns an error for an invalid rate.
+func (t RateLimitTool) NewMiddlewareWithMemoryStore(
+	rateLimit int64, period int,
+	identifierBuilder func(c *gin.Context) string,
+) (gin.HandlerFunc, error) {
 	store := memory_store.NewStore()
 	// store, err := memory_store.NewStoreWithOptions(limiter.StoreOptions{
 	// 	Prefix: "rate_limit", // Memory store prefix
 	// })
-	return t.Middleware(store, rateLimit, period, identifierBuilder)
+	return t.NewMiddleware(store, rateLimit, period, identifierBuilder)
 }
 
+// MiddlewareWithRedisStore is NewMiddlewareWithRedisStore panicking for an invalid rate.
 func (t RateLimitTool) MiddlewareWithRedisStore(
 	redisClient *redis.Client, rateLimit int64, period int,
 	identifierBuilder func(c *gin.Context) string,
 ) gin.HandlerFunc {
-	store, err := redis_store.NewStore(redisClient)
-	// store, err := redis_store.NewStoreWithOptions(redisClient, limiter.StoreOptions{
-	// 	Prefix: "rate_limit", // Redis 键前缀
-	// })
+	middleware, err := t.NewMiddlewareWithRedisStore(redisClient, rateLimit, period, identifierBuilder)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create rate limiter: %v", err))
+	}
+	return middleware
+}
+
+// NewMiddlewareWithRedisStore limits requests with a Redis store,
+// requests are limited in memory while Redis is unavailable, see NewRedisRateLimiter.
+// It returns an error for an invalid rate.
+func (t RateLimitTool) NewMiddlewareWithRedisStore(
+	redisClient *redis.Client, rateLimit int64, period int,
+	identifierBuilder func(c *gin.Context) string,
+) (gin.HandlerFunc, error) {
+	rate := RateLimitRate{
+		Limit:  rateLimit,
+		Period: time.Duration(period) * time.Second,
+	}
+	rateLimiter, err := NewRedisRateLimiter("redis", redisClient, rate)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create Redis store: %v", err))
+		return nil, err
 	}
-	return t.Middleware(store, rateLimit, period, identifierBuilder)
+	return t.MiddlewareWithLimiter(rateLimiter, identifierBuilder), nil
 }
 
 // DefaultIdentifierBuilder is a default implementation of the identifier builder function.
@@ -0,0 +1,329 @@
+package gin_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/ulule/limiter"
+	redis_store "github.com/ulule/limiter/drivers/store/redis"
+)
+
+const (
+	// RateLimitFailClosed rejects requests with 503 when the limiter fails (default).
+	RateLimitFailClosed = "closed"
+	// RateLimitFailOpen lets requests through when the limiter fails.
+	RateLimitFailOpen = "open"
+)
+
+// DefaultRateLimitRetryInterval is how long a failed store is skipped before it is tried again.
+const DefaultRateLimitRetryInterval = time.Second * 5
+
+const (
+	// rateLimitStoreMinBackoff and rateLimitStoreMaxBackoff bound the wait between two failed
+	// attempts to create the store of a lazyStoreRateLimiter, doubled after each failure.
+	rateLimitStoreMinBackoff = time.Second
+	rateLimitStoreMaxBackoff = time.Minute
+)
+
+var ErrRateLimitStoreUnavailable = errors.New("rate limit store unavailable")
+
+// Check if limiters implement IRateLimiter
+var (
+	_ IRateLimiter = &FallbackRateLimiter{}
+	_ IRateLimiter = &lazyStoreRateLimiter{}
+
+	_ IRateLimitPeeker = &FallbackRateLimiter{}
+	_ IRateLimitPeeker = &lazyStoreRateLimiter{}
+)
+
+/*
+FallbackRateLimiter:
+    1. FallbackRateLimiter uses the primary limiter, usually backed by a shared store like Redis,
+        and switches to the fallback limiter, usually in memory, when the primary returns an error.
+    2. While degraded the primary is skipped and tried again every RetryInterval,
+        the first successful call recovers.
+    3. The in-memory fallback only limits the requests of the current process,
+        with N instances a client may get up to N * Limit requests while degraded.
+    4. Health reports the degraded state, see RateLimitHealthHandler.
+*/
+// FallbackRateLimiter switches to a local limiter while the primary limiter is failing.
+type FallbackRateLimiter struct {
+	Name          string
+	RetryInterval time.Duration
+
+	primary  IRateLimiter
+	fallback IRateLimiter
+	now      func() time.Time
+
+	mu        sync.Mutex
+	degraded  bool
+	since     time.Time
+	nextRetry time.Time
+	lastError error
+	failures  int64
+}
+
+// RateLimitHealth is the state of a FallbackRateLimiter.
+type RateLimitHealth struct {
+	Name     string     `json:"name"`
+	Degraded bool       `json:"degraded"`
+	Since    *time.Time `json:"since,omitempty"`
+	// LastError is the last error of the primary limiter.
+	LastError string `json:"last_error,omitempty"`
+	// Failures counts the errors of the primary limiter.
+	Failures int64 `json:"failures"`
+}
+
+// NewFallbackRateLimiter creates a limiter using fallback while primary fails,
+// a nil fallback means an in-memory limiter of the same algorithm as primary.
+func NewFallbackRateLimiter(
+	name string, primary IRateLimiter, fallback IRateLimiter,
+) (*FallbackRateLimiter, error) {
+	if fallback == nil {
+		var err error
+		if fallback, err = localRateLimiter(primary); err != nil {
+			return nil, err
+		}
+	}
+	return &FallbackRateLimiter{
+		Name:          name,
+		RetryInterval: DefaultRateLimitRetryInterval,
+		primary:       primary,
+		fallback:      fallback,
+		now:           time.Now,
+	}, nil
+}
+
+// localRateLimiter creates an in-memory limiter matching the algorithm of limiter.
+func localRateLimiter(rateLimiter IRateLimiter) (IRateLimiter, error) {
+	rate := rateLimiter.Rate()
+	switch rateLimiter.(type) {
+	case *TokenBucketRateLimiter:
+		return asRateLimiter(NewTokenBucketRateLimiter(rate))
+	case *SlidingWindowRateLimiter:
+		return asRateLimiter(NewSlidingWindowRateLimiter(rate))
+	case *SlidingLogRateLimiter:
+		return asRateLimiter(NewSlidingLogRateLimiter(rate))
+	case *GCRARateLimiter:
+		return asRateLimiter(NewGCRARateLimiter(rate))
+	default:
+		return asRateLimiter(NewStoreRateLimiter(nil, rate))
+	}
+}
+
+func (l *FallbackRateLimiter) Rate() RateLimitRate {
+	return l.primary.Rate()
+}
+
+func (l *FallbackRateLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	l.mu.Lock()
+	skipPrimary := l.degraded && l.now().Before(l.nextRetry)
+	l.mu.Unlock()
+
+	if !skipPrimary {
+		result, err := l.primary.Allow(ctx, key)
+		if err == nil {
+			l.recover()
+			return result, nil
+		}
+		l.fail(err)
+	}
+	return l.fallback.Allow(ctx, key)
+}
+
+// Peek checks key on the limiter Allow would use, without counting a request.
+// It does not change the health, and reports not reached if the limiter cannot peek.
+func (l *FallbackRateLimiter) Peek(ctx context.Context, key string) (*RateLimitResult, error) {
+	l.mu.Lock()
+	skipPrimary := l.degraded && l.now().Before(l.nextRetry)
+	l.mu.Unlock()
+
+	if !skipPrimary {
+		if result, err := peekRateLimiter(ctx, l.primary, key); err == nil {
+			return result, nil
+		}
+	}
+	return peekRateLimiter(ctx, l.fallback, key)
+}
+
+// peekRateLimiter peeks rateLimiter if it is an IRateLimitPeeker, otherwise it reports not reached.
+func peekRateLimiter(ctx context.Context, rateLimiter IRateLimiter, key string) (*RateLimitResult, error) {
+	if peeker, ok := rateLimiter.(IRateLimitPeeker); ok {
+		return peeker.Peek(ctx, key)
+	}
+	return &RateLimitResult{Limit: rateLimiter.Rate().Limit, Remaining: rateLimiter.Rate().Limit}, nil
+}
+
+func (l *FallbackRateLimiter) recover() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.degraded = false
+}
+
+func (l *FallbackRateLimiter) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.degraded {
+		l.degraded = true
+		l.since = now
+	}
+	l.nextRetry = now.Add(l.RetryInterval)
+	l.lastError = err
+	l.failures++
+}
+
+// Health returns the current state.
+func (l *FallbackRateLimiter) Health() RateLimitHealth {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	health := RateLimitHealth{
+		Name:     l.Name,
+		Degraded: l.degraded,
+		Failures: l.failures,
+	}
+	if l.degraded {
+		since := l.since
+		health.Since = &since
+	}
+	if l.lastError != nil {
+		health.LastError = l.lastError.Error()
+	}
+	return health
+}
+
+// lazyStoreRateLimiter creates its store on first use, so a store that is down at startup
+// is retried instead of failing the setup. The store is created outside the lock by one caller,
+// the others get ErrRateLimitStoreUnavailable meanwhile and after a failure, until the backoff ends.
+type lazyStoreRateLimiter struct {
+	rate     RateLimitRate
+	newStore func() (limiter.Store, error)
+	now      func() time.Time
+
+	mu       sync.Mutex
+	limiter  *StoreRateLimiter
+	creating bool
+	backoff  time.Duration
+	retryAt  time.Time
+	lastErr  error
+}
+
+func (l *lazyStoreRateLimiter) Rate() RateLimitRate {
+	return l.rate
+}
+
+func (l *lazyStoreRateLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	storeLimiter, err := l.storeLimiter()
+	if err != nil {
+		return nil, err
+	}
+	return storeLimiter.Allow(ctx, key)
+}
+
+func (l *lazyStoreRateLimiter) Peek(ctx context.Context, key string) (*RateLimitResult, error) {
+	storeLimiter, err := l.storeLimiter()
+	if err != nil {
+		return nil, err
+	}
+	return storeLimiter.Peek(ctx, key)
+}
+
+// storeLimiter returns the limiter, its store is created on the first call.
+func (l *lazyStoreRateLimiter) storeLimiter() (*StoreRateLimiter, error) {
+	l.mu.Lock()
+	if l.limiter != nil {
+		storeLimiter := l.limiter
+		l.mu.Unlock()
+		return storeLimiter, nil
+	}
+	if l.creating || l.now().Before(l.retryAt) {
+		err := l.lastErr
+		l.mu.Unlock()
+		return nil, errors.Join(ErrRateLimitStoreUnavailable, err)
+	}
+	l.creating = true
+	l.mu.Unlock()
+
+	// the store may dial, which must not block the other callers
+	var storeLimiter *StoreRateLimiter
+	store, err := l.newStore()
+	if err == nil {
+		storeLimiter, err = NewStoreRateLimiter(store, l.rate)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.creating = false
+	if err != nil {
+		l.backoff = min(max(l.backoff*2, rateLimitStoreMinBackoff), rateLimitStoreMaxBackoff)
+		l.retryAt = l.now().Add(l.backoff)
+		l.lastErr = err
+		return nil, errors.Join(ErrRateLimitStoreUnavailable, err)
+	}
+	l.limiter = storeLimiter
+	return storeLimiter, nil
+}
+
+// NewRedisRateLimiter creates a fixed window limiter on Redis which falls back to memory
+// while Redis is unavailable, including at startup.
+func NewRedisRateLimiter(
+	name string, redisClient *redis.Client, rate RateLimitRate,
+) (*FallbackRateLimiter, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+	return NewFallbackRateLimiter(name, &lazyStoreRateLimiter{
+		rate: rate,
+		newStore: func() (limiter.Store, error) {
+			return redis_store.NewStore(redisClient)
+		},
+		now: time.Now,
+	}, nil)
+}
+
+// RedisRateLimiterFactory creates limiters with NewRedisRateLimiter, onCreate is called with every limiter.
+// Only fixed_window is supported on Redis, other algorithms are rejected instead of being limited
+// per instance, use NewRateLimiter for in-memory limits.
+func RedisRateLimiterFactory(
+	redisClient *redis.Client, onCreate func(*FallbackRateLimiter),
+) RateLimiterFactory {
+	return func(algorithm string, rate RateLimitRate) (IRateLimiter, error) {
+		if algorithm != "" && algorithm != RateLimitAlgorithmFixedWindow {
+			return nil, fmt.Errorf("rate limit algorithm %q is not supported on Redis, only %q is",
+				algorithm, RateLimitAlgorithmFixedWindow)
+		}
+		rateLimiter, err := NewRedisRateLimiter(FormatRateLimitPolicy(rate), redisClient, rate)
+		if err != nil {
+			return nil, err
+		}
+		if onCreate != nil {
+			onCreate(rateLimiter)
+		}
+		return rateLimiter, nil
+	}
+}
+
+// RateLimitHealthHandler reports the state of limiters,
+// the status is 200 even if degraded since requests are still limited locally.
+func RateLimitHealthHandler(limiters ...*FallbackRateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := struct {
+			Degraded bool              `json:"degraded"`
+			Limiters []RateLimitHealth `json:"limiters"`
+		}{
+			Limiters: make([]RateLimitHealth, 0, len(limiters)),
+		}
+		for _, l := range limiters {
+			health := l.Health()
+			status.Degraded = status.Degraded || health.Degraded
+			status.Limiters = append(status.Limiters, health)
+		}
+		c.JSON(http.StatusOK, SuccessResponse(&status))
+	}
+}
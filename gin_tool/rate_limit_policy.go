@@ -0,0 +1,303 @@
+package gin_tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// RateLimitIdentifierIP limits each client IP separately (default).
+	RateLimitIdentifierIP = "ip"
+	// RateLimitIdentifierGlobal shares one limit between all clients.
+	RateLimitIdentifierGlobal = "global"
+	// RateLimitIdentifierHeader limits each value of a header, e.g. "header:X-API-Key".
+	RateLimitIdentifierHeader = "header:"
+	// RateLimitIdentifierQuery limits each value of a query param, e.g. "query:user_id".
+	RateLimitIdentifierQuery = "query:"
+)
+
+/*
+RateLimitPolicy:
+    1. A policy maps a route pattern and methods to one or more limits,
+        e.g. 10/s and 1000/h on the same route; a request must pass every limit.
+    2. Path is matched against c.FullPath(), the registered route pattern:
+        - "" matches every route
+        - "/tool/*" matches every route with the prefix "/tool/"
+        - other patterns are matched with path.Match, e.g. "/tool/ping"
+    3. Policies are checked in order, only the first matching policy is applied.
+    4. Identifier is "ip", "global", "header:<name>" or "query:<name>",
+        an empty header or query value falls back to the client IP.
+        Header and query values (e.g. API keys) are hashed in the shadow report and its logs.
+    5. Shadow policies are computed but never reject, see RateLimitShadowReport.
+    6. A request rejected by one limit is not counted by the others:
+        limits which cannot be refunded are peeked before any limit is checked,
+        and the limits allowed before a rejection are refunded, see IRateLimitRefunder.
+        Concurrent requests may still be counted by a fixed_window limit peeked as not reached.
+*/
+// RateLimitPolicy is a declarative rate limit of a route.
+type RateLimitPolicy struct {
+	Name       string          `json:"name"`
+	Methods    []string        `json:"methods,omitempty"`
+	Path       string          `json:"path"`
+	Identifier string          `json:"identifier,omitempty"`
+	Limits     []RateLimitRule `json:"limits"`
+	// Shadow computes the limits without rejecting, see RateLimitTool.Shadow.
+	Shadow bool `json:"shadow,omitempty"`
+}
+
+// RateLimitRule is one limit of a policy.
+type RateLimitRule struct {
+	Limit int64 `json:"limit"`
+	// Period is a duration string, e.g. "1s", "1h".
+	Period string `json:"period"`
+	Burst  int64  `json:"burst,omitempty"`
+	// Algorithm is one of RateLimitAlgorithm*, empty means fixed_window.
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// LoadRateLimitPolicies loads policies from a JSON file: {"policies": [...]}.
+func LoadRateLimitPolicies(filePath string) ([]RateLimitPolicy, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	policyFile := struct {
+		Policies []RateLimitPolicy `json:"policies"`
+	}{}
+	if err := json.Unmarshal(content, &policyFile); err != nil {
+		return nil, fmt.Errorf("invalid rate limit policy file %s: %w", filePath, err)
+	}
+	return policyFile.Policies, nil
+}
+
+// compiledRateLimitPolicy is a policy with its limiters.
+type compiledRateLimitPolicy struct {
+	RateLimitPolicy
+	limiters []IRateLimiter
+}
+
+// RateLimiterFactory creates the limiter of a rule, see NewRateLimiter.
+type RateLimiterFactory func(algorithm string, rate RateLimitRate) (IRateLimiter, error)
+
+func compileRateLimitPolicies(
+	policies []RateLimitPolicy, factory RateLimiterFactory,
+) ([]*compiledRateLimitPolicy, error) {
+	compiled := make([]*compiledRateLimitPolicy, 0, len(policies))
+	for i, policy := range policies {
+		if policy.Name == "" {
+			policy.Name = fmt.Sprintf("policy_%d", i)
+		}
+		if _, err := path.Match(policy.Path, "/"); err != nil {
+			return nil, fmt.Errorf("policy %s: invalid path %q: %w", policy.Name, policy.Path, err)
+		}
+		switch {
+		case policy.Identifier == "", policy.Identifier == RateLimitIdentifierIP,
+			policy.Identifier == RateLimitIdentifierGlobal,
+			strings.HasPrefix(policy.Identifier, RateLimitIdentifierHeader),
+			strings.HasPrefix(policy.Identifier, RateLimitIdentifierQuery):
+		default:
+			return nil, fmt.Errorf("policy %s: unknown identifier %q", policy.Name, policy.Identifier)
+		}
+		if len(policy.Limits) == 0 {
+			return nil, fmt.Errorf("policy %s: no limits", policy.Name)
+		}
+
+		c := &compiledRateLimitPolicy{RateLimitPolicy: policy}
+		for _, rule := range policy.Limits {
+			period, err := time.ParseDuration(rule.Period)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: invalid period %q: %w", policy.Name, rule.Period, err)
+			}
+			rateLimiter, err := factory(rule.Algorithm, RateLimitRate{
+				Limit:  rule.Limit,
+				Period: period,
+				Burst:  rule.Burst,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: %w", policy.Name, err)
+			}
+			c.limiters = append(c.limiters, rateLimiter)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// keys returns the limiter keys of identifier, one per limit.
+func (p *compiledRateLimitPolicy) keys(identifier string) []string {
+	keys := make([]string, len(p.limiters))
+	for i := range p.limiters {
+		keys[i] = fmt.Sprintf("%s:%d", identifier, i)
+	}
+	return keys
+}
+
+func (p *compiledRateLimitPolicy) match(method string, fullPath string) bool {
+	if len(p.Methods) > 0 {
+		matched := false
+		for _, m := range p.Methods {
+			if strings.EqualFold(m, method) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	switch {
+	case p.Path == "":
+		return true
+	case strings.HasSuffix(p.Path, "/*"):
+		return strings.HasPrefix(fullPath, strings.TrimSuffix(p.Path, "*"))
+	default:
+		matched, _ := path.Match(p.Path, fullPath)
+		return matched
+	}
+}
+
+// reportedIdentifier returns identifier with a header or query value replaced by a truncated SHA-256,
+// so that secrets such as API keys do not leak into the shadow report.
+func (p *compiledRateLimitPolicy) reportedIdentifier(identifier string) string {
+	if !strings.HasPrefix(p.Identifier, RateLimitIdentifierHeader) &&
+		!strings.HasPrefix(p.Identifier, RateLimitIdentifierQuery) {
+		return identifier
+	}
+	prefix := p.Name + ":" + p.Identifier + ":"
+	value, ok := strings.CutPrefix(identifier, prefix)
+	if !ok {
+		return identifier
+	}
+	return prefix + "sha256:" + HashAPIKey(value)[:16]
+}
+
+func (p *compiledRateLimitPolicy) identifier(c *gin.Context) string {
+	value := ""
+	switch {
+	case p.Identifier == RateLimitIdentifierGlobal:
+		return p.Name + ":global"
+	case strings.HasPrefix(p.Identifier, RateLimitIdentifierHeader):
+		value = c.GetHeader(strings.TrimPrefix(p.Identifier, RateLimitIdentifierHeader))
+	case strings.HasPrefix(p.Identifier, RateLimitIdentifierQuery):
+		value = c.Query(strings.TrimPrefix(p.Identifier, RateLimitIdentifierQuery))
+	}
+	if value == "" {
+		return p.Name + ":ip:" + c.ClientIP()
+	}
+	return p.Name + ":" + p.Identifier + ":" + value
+}
+
+// PolicyMiddleware applies the first matching policy to every request,
+// attach it once with engine.Use before registering routes.
+func (t RateLimitTool) PolicyMiddleware(policies []RateLimitPolicy) gin.HandlerFunc {
+	return t.PolicyMiddlewareWithFactory(policies, NewRateLimiter)
+}
+
+// PolicyMiddlewareWithFactory is PolicyMiddleware with the limiters created by factory,
+// e.g. to keep fixed_window limits in Redis, see RedisRateLimiterFactory.
+func (t RateLimitTool) PolicyMiddlewareWithFactory(
+	policies []RateLimitPolicy, factory RateLimiterFactory,
+) gin.HandlerFunc {
+	middleware, err := t.NewPolicyMiddleware(policies, factory)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid rate limit policies: %v", err))
+	}
+	return middleware
+}
+
+// NewPolicyMiddleware is PolicyMiddlewareWithFactory returning an error for invalid policies.
+func (t RateLimitTool) NewPolicyMiddleware(
+	policies []RateLimitPolicy, factory RateLimiterFactory,
+) (gin.HandlerFunc, error) {
+	compiled, err := compileRateLimitPolicies(policies, factory)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		var policy *compiledRateLimitPolicy
+		for _, p := range compiled {
+			if p.match(c.Request.Method, c.FullPath()) {
+				policy = p
+				break
+			}
+		}
+		if policy == nil {
+			return
+		}
+
+		identifier := policy.identifier(c)
+		t.applyLimits(c, rateLimitSource{
+			policy:     policy.Name,
+			identifier: policy.reportedIdentifier(identifier),
+			shadow:     policy.Shadow,
+		}, policy.limiters, policy.keys(identifier))
+	}, nil
+}
+
+// applyLimits checks the request against every limiter, keys[i] is the key of limiters[i].
+// It returns whether the request is allowed, a rejected request is not counted by the other limiters:
+// limiters which cannot be refunded are peeked first, and the limiters allowed before a rejection are refunded.
+func (t RateLimitTool) applyLimits(
+	c *gin.Context, source rateLimitSource, limiters []IRateLimiter, keys []string,
+) bool {
+	ctx := c.Request.Context()
+	rates := make([]RateLimitRate, 0, len(limiters))
+	for _, rateLimiter := range limiters {
+		rates = append(rates, rateLimiter.Rate())
+	}
+
+	for i, rateLimiter := range limiters {
+		if _, ok := rateLimiter.(IRateLimitRefunder); ok {
+			continue
+		}
+		peeker, ok := rateLimiter.(IRateLimitPeeker)
+		if !ok {
+			continue
+		}
+		// errors are reported by Allow
+		if result, err := peeker.Peek(ctx, keys[i]); err == nil && result.Reached {
+			return t.applyResult(c, source, result, rates...)
+		}
+	}
+
+	// the result with the least remaining requests is reported
+	var reported *RateLimitResult
+	allowed := make([]int, 0, len(limiters))
+	refund := func() {
+		for _, i := range allowed {
+			if refunder, ok := limiters[i].(IRateLimitRefunder); ok {
+				_ = refunder.Refund(ctx, keys[i])
+			}
+		}
+	}
+	for i, rateLimiter := range limiters {
+		result, err := rateLimiter.Allow(ctx, keys[i])
+		if err != nil {
+			if !t.applyError(c, err) {
+				refund()
+				return false
+			}
+			continue
+		}
+		if reported == nil || result.Reached || result.Remaining < reported.Remaining {
+			reported = result
+		}
+		if result.Reached {
+			refund()
+			break
+		}
+		allowed = append(allowed, i)
+	}
+
+	if reported == nil {
+		return true
+	}
+	return t.applyResult(c, source, reported, rates...)
+}
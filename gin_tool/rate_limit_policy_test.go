@@ -0,0 +1,250 @@
+package gin_tool
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clockedRateLimiterFactory creates in-memory limiters on clock and keeps them in created.
+func clockedRateLimiterFactory(clock *fakeClock, created *[]IRateLimiter) RateLimiterFactory {
+	return func(algorithm string, rate RateLimitRate) (IRateLimiter, error) {
+		rateLimiter, err := NewRateLimiter(algorithm, rate)
+		if err != nil {
+			return nil, err
+		}
+		switch l := rateLimiter.(type) {
+		case *TokenBucketRateLimiter:
+			l.now = clock.Now
+		case *SlidingWindowRateLimiter:
+			l.now = clock.Now
+		case *SlidingLogRateLimiter:
+			l.now = clock.Now
+		case *GCRARateLimiter:
+			l.now = clock.Now
+		}
+		*created = append(*created, rateLimiter)
+		return rateLimiter, nil
+	}
+}
+
+// serveN sends n requests to path and returns how many got 200.
+func serveN(engine *gin.Engine, path string, n int) int {
+	ok := 0
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code == http.StatusOK {
+			ok++
+		}
+	}
+	return ok
+}
+
+func newRateLimitedEngine(middleware gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(middleware)
+	engine.GET("/limited", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return engine
+}
+
+func TestRateLimitPolicy(t *testing.T) {
+	t.Run("Route Matching", TestRateLimitPolicyMatch)
+	t.Run("Identifier", TestRateLimitPolicyIdentifier)
+	t.Run("Multiple Limits", TestRateLimitPolicyMultipleLimits)
+	t.Run("Multiple Fixed Windows", TestRateLimitPolicyFixedWindows)
+	t.Run("Invalid Policies", TestInvalidRateLimitPolicies)
+	t.Run("Policy File", TestLoadRateLimitPolicies)
+}
+
+func TestRateLimitPolicyMatch(t *testing.T) {
+	limits := []RateLimitRule{{Limit: 1, Period: "1s"}}
+	compiled, err := compileRateLimitPolicies([]RateLimitPolicy{
+		{Name: "ping", Methods: []string{"get"}, Path: "/tool/ping", Limits: limits},
+		{Name: "tool", Path: "/tool/*", Limits: limits},
+		{Name: "user", Path: "/users/:id/*", Limits: limits},
+		{Name: "all", Path: "", Limits: limits},
+	}, NewRateLimiter)
+	if err != nil {
+		t.Fatal("compileRateLimitPolicies failed:", err)
+	}
+
+	for _, c := range []struct {
+		policy   int
+		method   string
+		fullPath string
+		matched  bool
+	}{
+		{0, http.MethodGet, "/tool/ping", true},
+		{0, http.MethodPost, "/tool/ping", false},
+		{0, http.MethodGet, "/tool/ping/other", false},
+		{1, http.MethodPost, "/tool/ping", true},
+		{1, http.MethodGet, "/tool/a/b", true},
+		{1, http.MethodGet, "/tool", false},
+		{1, http.MethodGet, "/toolbox/a", false},
+		{2, http.MethodGet, "/users/:id/orders", true},
+		{2, http.MethodGet, "/users/1/orders", false},
+		{3, http.MethodDelete, "/anything", true},
+		// an unmatched route has no full path
+		{3, http.MethodGet, "", true},
+	} {
+		if matched := compiled[c.policy].match(c.method, c.fullPath); matched != c.matched {
+			t.Fatalf("Expected policy %s to match %s %q: %v, got: %v",
+				compiled[c.policy].Name, c.method, c.fullPath, c.matched, matched)
+		}
+	}
+}
+
+func TestRateLimitPolicyIdentifier(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limits := []RateLimitRule{{Limit: 1, Period: "1s"}}
+	compiled, err := compileRateLimitPolicies([]RateLimitPolicy{
+		{Name: "ip", Identifier: RateLimitIdentifierIP, Limits: limits},
+		{Name: "global", Identifier: RateLimitIdentifierGlobal, Limits: limits},
+		{Name: "key", Identifier: RateLimitIdentifierHeader + "X-API-Key", Limits: limits},
+		{Name: "user", Identifier: RateLimitIdentifierQuery + "user_id", Limits: limits},
+		{Name: "default", Limits: limits},
+	}, NewRateLimiter)
+	if err != nil {
+		t.Fatal("compileRateLimitPolicies failed:", err)
+	}
+
+	newContext := func(target string, header map[string]string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+		c.Request.RemoteAddr = "10.0.0.1:1234"
+		for name, value := range header {
+			c.Request.Header.Set(name, value)
+		}
+		return c
+	}
+	withValues := newContext("/?user_id=42", map[string]string{"X-API-Key": "secret"})
+	withoutValues := newContext("/", nil)
+
+	for i, expected := range [][2]string{
+		{"ip:ip:10.0.0.1", "ip:ip:10.0.0.1"},
+		{"global:global", "global:global"},
+		{"key:header:X-API-Key:secret", "key:ip:10.0.0.1"},
+		{"user:query:user_id:42", "user:ip:10.0.0.1"},
+		{"default:ip:10.0.0.1", "default:ip:10.0.0.1"},
+	} {
+		// an empty header or query value falls back to the client IP
+		if identifier := compiled[i].identifier(withValues); identifier != expected[0] {
+			t.Fatal("Expected identifier", expected[0], "got:", identifier)
+		}
+		if identifier := compiled[i].identifier(withoutValues); identifier != expected[1] {
+			t.Fatal("Expected identifier", expected[1], "got:", identifier)
+		}
+	}
+}
+
+// TestRateLimitPolicyMultipleLimits checks that a request rejected by one limit
+// is not counted by the other limits of the policy.
+func TestRateLimitPolicyMultipleLimits(t *testing.T) {
+	for _, limits := range [][]RateLimitRule{
+		{
+			{Limit: 3, Period: "1h", Algorithm: RateLimitAlgorithmSlidingLog},
+			{Limit: 1, Period: "1m", Algorithm: RateLimitAlgorithmGCRA},
+		},
+		{
+			{Limit: 1, Period: "1m", Algorithm: RateLimitAlgorithmTokenBucket},
+			{Limit: 3, Period: "1h", Algorithm: RateLimitAlgorithmSlidingWindow},
+		},
+	} {
+		clock := newFakeClock()
+		var created []IRateLimiter
+		middleware, err := RateLimitTool{}.NewPolicyMiddleware([]RateLimitPolicy{{
+			Name:       "limited",
+			Identifier: RateLimitIdentifierGlobal,
+			Limits:     limits,
+		}}, clockedRateLimiterFactory(clock, &created))
+		if err != nil {
+			t.Fatal("NewPolicyMiddleware failed:", err)
+		}
+		engine := newRateLimitedEngine(middleware)
+
+		// the minute limit rejects 4 of 5 requests, the hour limit counts only one
+		for minute := 0; minute < 3; minute++ {
+			if allowed := serveN(engine, "/limited", 5); allowed != 1 {
+				t.Fatalf("Expected 1 request in minute %d, got: %d", minute, allowed)
+			}
+			clock.Advance(time.Minute)
+		}
+		if allowed := serveN(engine, "/limited", 5); allowed != 0 {
+			t.Fatal("Expected the hour limit reached after 3 requests, got:", allowed)
+		}
+	}
+}
+
+func TestRateLimitPolicyFixedWindows(t *testing.T) {
+	var created []IRateLimiter
+	middleware, err := RateLimitTool{}.NewPolicyMiddleware([]RateLimitPolicy{{
+		Name:       "fixed",
+		Identifier: RateLimitIdentifierGlobal,
+		Limits: []RateLimitRule{
+			{Limit: 3, Period: "1h"},
+			{Limit: 1, Period: "1h"},
+		},
+	}}, func(algorithm string, rate RateLimitRate) (IRateLimiter, error) {
+		rateLimiter, err := NewRateLimiter(algorithm, rate)
+		created = append(created, rateLimiter)
+		return rateLimiter, err
+	})
+	if err != nil {
+		t.Fatal("NewPolicyMiddleware failed:", err)
+	}
+	engine := newRateLimitedEngine(middleware)
+
+	if allowed := serveN(engine, "/limited", 5); allowed != 1 {
+		t.Fatal("Expected 1 request, got:", allowed)
+	}
+	// fixed windows cannot be refunded, the reached limit is peeked before the others count
+	result, err := created[0].(IRateLimitPeeker).Peek(context.Background(), "fixed:global:0")
+	if err != nil || result.Remaining != 2 || result.Reached {
+		t.Fatal("Expected 1 request counted by the first limit, got:", result, err)
+	}
+}
+
+func TestInvalidRateLimitPolicies(t *testing.T) {
+	limits := []RateLimitRule{{Limit: 1, Period: "1s"}}
+	for _, policy := range []RateLimitPolicy{
+		{Name: "path", Path: "/[", Limits: limits},
+		{Name: "identifier", Identifier: "cookie:session", Limits: limits},
+		{Name: "no limits"},
+		{Name: "period", Limits: []RateLimitRule{{Limit: 1, Period: "1 second"}}},
+		{Name: "rate", Limits: []RateLimitRule{{Limit: 0, Period: "1s"}}},
+		{Name: "algorithm", Limits: []RateLimitRule{{Limit: 1, Period: "1s", Algorithm: "leaky"}}},
+	} {
+		if _, err := (RateLimitTool{}).NewPolicyMiddleware([]RateLimitPolicy{policy}, NewRateLimiter); err == nil {
+			t.Fatal("Expected an error for the invalid policy:", policy.Name)
+		}
+	}
+}
+
+func TestLoadRateLimitPolicies(t *testing.T) {
+	policies, err := LoadRateLimitPolicies(filepath.Join("..", "config", "rate_limit_policy.json"))
+	if err != nil || len(policies) == 0 {
+		t.Fatal("Failed to load the policies of the service:", policies, err)
+	}
+	if _, err := (RateLimitTool{}).NewPolicyMiddleware(policies, NewRateLimiter); err != nil {
+		t.Fatal("Expected the policies of the service to be valid, got:", err)
+	}
+
+	if _, err := LoadRateLimitPolicies(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	_ = os.WriteFile(invalid, []byte(`{"policies": {}}`), 0o644)
+	if _, err := LoadRateLimitPolicies(invalid); err == nil {
+		t.Fatal("Expected an error for an invalid file")
+	}
+}
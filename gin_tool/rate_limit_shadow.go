@@ -0,0 +1,154 @@
+package gin_tool
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitShadowHeaderKey is set to "throttled" on requests let through by shadow mode.
+const RateLimitShadowHeaderKey = "X-RateLimit-Shadow"
+
+// DefaultRateLimitShadowMaxIdentifiers caps the identifiers kept per policy by RateLimitShadowReport.
+const DefaultRateLimitShadowMaxIdentifiers = 1000
+
+/*
+RateLimitShadow:
+    1. In shadow mode limits are computed and headers are set as usual,
+        but requests over the limit are let through with X-RateLimit-Shadow: throttled.
+    2. RateLimitTool.Shadow puts every limit of the tool in shadow mode,
+        RateLimitPolicy.Shadow only the limits of that policy, to try a new policy next to enforced ones.
+    3. RateLimitTool.ShadowReport counts requests and would-be throttled requests per policy,
+        and which identifiers would have been throttled, up to MaxIdentifiers per policy.
+    4. Handler and ResetHandler expose identifiers and must be protected, e.g. with BearerTokenAuthTool.
+*/
+// RateLimitShadowEvent is a request that would have been throttled.
+type RateLimitShadowEvent struct {
+	Policy     string
+	Identifier string
+	Method     string
+	Path       string
+	Result     *RateLimitResult
+}
+
+// RateLimitShadowStats is the report of one policy.
+type RateLimitShadowStats struct {
+	Policy string `json:"policy"`
+	// Requests is the number of requests checked in shadow mode.
+	Requests int64 `json:"requests"`
+	// Throttled is the number of requests which would have been rejected.
+	Throttled int64 `json:"throttled"`
+	// Identifiers maps would-be throttled identifiers to their throttled requests.
+	Identifiers map[string]int64 `json:"identifiers"`
+	// LastThrottled is the time of the last would-be throttled request.
+	LastThrottled *time.Time `json:"last_throttled,omitempty"`
+}
+
+// RateLimitShadowReport collects the decisions of shadow mode.
+type RateLimitShadowReport struct {
+	// Logger is called with every would-be throttled request, nil means no logging.
+	Logger         func(*RateLimitShadowEvent)
+	MaxIdentifiers int
+
+	mu    sync.Mutex
+	stats map[string]*RateLimitShadowStats
+}
+
+func NewRateLimitShadowReport(logger func(*RateLimitShadowEvent)) *RateLimitShadowReport {
+	return &RateLimitShadowReport{
+		Logger:         logger,
+		MaxIdentifiers: DefaultRateLimitShadowMaxIdentifiers,
+		stats:          map[string]*RateLimitShadowStats{},
+	}
+}
+
+// DefaultRateLimitShadowLogger prints would-be throttled requests.
+func DefaultRateLimitShadowLogger(event *RateLimitShadowEvent) {
+	fmt.Printf(
+		"Rate Limit Shadow: policy %s would throttle %s on %s %s, limit %d\n",
+		event.Policy, event.Identifier, event.Method, event.Path, event.Result.Limit,
+	)
+}
+
+// Record adds a decision of shadow mode.
+func (r *RateLimitShadowReport) Record(event *RateLimitShadowEvent) {
+	r.mu.Lock()
+	stats, ok := r.stats[event.Policy]
+	if !ok {
+		stats = &RateLimitShadowStats{Policy: event.Policy, Identifiers: map[string]int64{}}
+		r.stats[event.Policy] = stats
+	}
+	stats.Requests++
+	if !event.Result.Reached {
+		r.mu.Unlock()
+		return
+	}
+	stats.Throttled++
+	now := time.Now()
+	stats.LastThrottled = &now
+	if _, ok := stats.Identifiers[event.Identifier]; ok || len(stats.Identifiers) < r.MaxIdentifiers {
+		stats.Identifiers[event.Identifier]++
+	}
+	r.mu.Unlock()
+
+	if r.Logger != nil {
+		r.Logger(event)
+	}
+}
+
+// Snapshot returns a copy of the report sorted by policy.
+func (r *RateLimitShadowReport) Snapshot() []RateLimitShadowStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := make([]RateLimitShadowStats, 0, len(r.stats))
+	for _, stats := range r.stats {
+		copied := *stats
+		copied.Identifiers = make(map[string]int64, len(stats.Identifiers))
+		for identifier, count := range stats.Identifiers {
+			copied.Identifiers[identifier] = count
+		}
+		snapshot = append(snapshot, copied)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Policy < snapshot[j].Policy
+	})
+	return snapshot
+}
+
+// Reset drops the report.
+func (r *RateLimitShadowReport) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = map[string]*RateLimitShadowStats{}
+}
+
+// Handler returns the report.
+func (r *RateLimitShadowReport) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		snapshot := r.Snapshot()
+		c.JSON(http.StatusOK, SuccessResponse(&snapshot))
+	}
+}
+
+// ResetHandler returns the report and drops it, register it on a POST route.
+func (r *RateLimitShadowReport) ResetHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r.mu.Lock()
+		stats := r.stats
+		r.stats = map[string]*RateLimitShadowStats{}
+		r.mu.Unlock()
+
+		snapshot := make([]RateLimitShadowStats, 0, len(stats))
+		for _, s := range stats {
+			snapshot = append(snapshot, *s)
+		}
+		sort.Slice(snapshot, func(i, j int) bool {
+			return snapshot[i].Policy < snapshot[j].Policy
+		})
+		c.JSON(http.StatusOK, SuccessResponse(&snapshot))
+	}
+}
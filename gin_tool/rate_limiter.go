@@ -0,0 +1,526 @@
+package gin_tool
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ulule/limiter"
+	memory_store "github.com/ulule/limiter/drivers/store/memory"
+)
+
+const (
+	RateLimitAlgorithmFixedWindow   = "fixed_window"
+	RateLimitAlgorithmTokenBucket   = "token_bucket"
+	RateLimitAlgorithmSlidingWindow = "sliding_window"
+	RateLimitAlgorithmSlidingLog    = "sliding_log"
+	RateLimitAlgorithmGCRA          = "gcra"
+)
+
+// idleStateTimeout is how long the state of an unused key is kept by in-memory limiters.
+const idleStateTimeout = time.Hour
+
+// Check if limiters implement IRateLimiter
+var (
+	_ IRateLimiter = &StoreRateLimiter{}
+	_ IRateLimiter = &TokenBucketRateLimiter{}
+	_ IRateLimiter = &SlidingWindowRateLimiter{}
+	_ IRateLimiter = &SlidingLogRateLimiter{}
+	_ IRateLimiter = &GCRARateLimiter{}
+
+	_ IRateLimitPeeker   = &StoreRateLimiter{}
+	_ IRateLimitRefunder = &TokenBucketRateLimiter{}
+	_ IRateLimitRefunder = &SlidingWindowRateLimiter{}
+	_ IRateLimitRefunder = &SlidingLogRateLimiter{}
+	_ IRateLimitRefunder = &GCRARateLimiter{}
+)
+
+/*
+IRateLimiter:
+    1. IRateLimiter decides whether one more request of a key is allowed.
+    2. Algorithms:
+        - fixed_window:   ulule/limiter store, allows up to 2x Limit around window boundaries.
+        - token_bucket:   refills Limit tokens per Period, bursts up to Burst.
+        - sliding_window: weights the previous fixed window by its overlap with the sliding window.
+        - sliding_log:    keeps the timestamp of every allowed request, exact but O(Limit) memory.
+        - gcra:           generic cell rate algorithm, a token bucket that stores one timestamp per key.
+    3. Except fixed_window, limiters keep their state in memory of the current process.
+    4. Every constructor validates the rate, see RateLimitRate.Validate.
+    5. A request checked against several limits must not use up one limit when another rejects it:
+        - IRateLimitRefunder gives back a request allowed by Allow, implemented by the in-memory algorithms.
+        - IRateLimitPeeker checks a limit without counting the request, implemented by fixed_window
+            since ulule/limiter stores cannot be decremented.
+*/
+// IRateLimiter is a rate limit algorithm.
+type IRateLimiter interface {
+	Allow(ctx context.Context, key string) (*RateLimitResult, error)
+	Rate() RateLimitRate
+}
+
+// IRateLimitRefunder is an IRateLimiter which can give back a request allowed by Allow.
+type IRateLimitRefunder interface {
+	Refund(ctx context.Context, key string) error
+}
+
+// IRateLimitPeeker is an IRateLimiter which can check a key without counting a request.
+type IRateLimitPeeker interface {
+	Peek(ctx context.Context, key string) (*RateLimitResult, error)
+}
+
+// RateLimitRate is Limit requests per Period, with an optional Burst.
+type RateLimitRate struct {
+	Limit  int64         `json:"limit"`
+	Period time.Duration `json:"period"`
+	// Burst is only used by token_bucket and gcra, 0 means Limit.
+	Burst int64 `json:"burst,omitempty"`
+}
+
+// Validate checks that Limit and Period are positive and Period / Limit is at least 1ns,
+// the emission interval of token_bucket and gcra.
+func (r RateLimitRate) Validate() error {
+	if r.Limit <= 0 || r.Period <= 0 || r.Burst < 0 {
+		return fmt.Errorf("invalid rate: %d per %s, burst %d", r.Limit, r.Period, r.Burst)
+	}
+	if r.Period/time.Duration(r.Limit) <= 0 {
+		return fmt.Errorf("invalid rate: %d per %s is more than 1 per ns", r.Limit, r.Period)
+	}
+	return nil
+}
+
+func (r RateLimitRate) burst() int64 {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return r.Limit
+}
+
+// RateLimitResult is the decision of an IRateLimiter.
+type RateLimitResult struct {
+	Limit     int64 `json:"limit"`
+	Remaining int64 `json:"remaining"`
+	// Reset is the time the limit is fully restored.
+	Reset time.Time `json:"reset"`
+	// RetryAfter is how long to wait before the next request is allowed, 0 if not Reached.
+	RetryAfter time.Duration `json:"retry_after"`
+	Reached    bool          `json:"reached"`
+}
+
+// NewRateLimiter creates an in-memory limiter by algorithm name.
+func NewRateLimiter(algorithm string, rate RateLimitRate) (IRateLimiter, error) {
+	switch algorithm {
+	case "", RateLimitAlgorithmFixedWindow:
+		return asRateLimiter(NewStoreRateLimiter(nil, rate))
+	case RateLimitAlgorithmTokenBucket:
+		return asRateLimiter(NewTokenBucketRateLimiter(rate))
+	case RateLimitAlgorithmSlidingWindow:
+		return asRateLimiter(NewSlidingWindowRateLimiter(rate))
+	case RateLimitAlgorithmSlidingLog:
+		return asRateLimiter(NewSlidingLogRateLimiter(rate))
+	case RateLimitAlgorithmGCRA:
+		return asRateLimiter(NewGCRARateLimiter(rate))
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm: %q", algorithm)
+	}
+}
+
+// asRateLimiter returns a nil IRateLimiter on error instead of a typed nil pointer.
+func asRateLimiter[T IRateLimiter](rateLimiter T, err error) (IRateLimiter, error) {
+	if err != nil {
+		return nil, err
+	}
+	return rateLimiter, nil
+}
+
+// keyedState is a per-key state map which drops keys unused for idleStateTimeout.
+type keyedState[T any] struct {
+	mu        sync.Mutex
+	states    map[string]*T
+	lastSeen  map[string]time.Time
+	lastSweep time.Time
+}
+
+func newKeyedState[T any]() *keyedState[T] {
+	return &keyedState[T]{
+		states:   map[string]*T{},
+		lastSeen: map[string]time.Time{},
+	}
+}
+
+// with runs f with the state of key under the lock.
+func (k *keyedState[T]) with(key string, now time.Time, f func(state *T, isNew bool)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if now.Sub(k.lastSweep) > idleStateTimeout {
+		for stateKey, seen := range k.lastSeen {
+			if now.Sub(seen) > idleStateTimeout {
+				delete(k.states, stateKey)
+				delete(k.lastSeen, stateKey)
+			}
+		}
+		k.lastSweep = now
+	}
+
+	state, ok := k.states[key]
+	if !ok {
+		state = new(T)
+		k.states[key] = state
+	}
+	k.lastSeen[key] = now
+	f(state, !ok)
+}
+
+// existing runs f with the state of key under the lock, f is not called for unknown keys.
+func (k *keyedState[T]) existing(key string, f func(state *T)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if state, ok := k.states[key]; ok {
+		f(state)
+	}
+}
+
+// StoreRateLimiter is the fixed window algorithm of ulule/limiter.
+type StoreRateLimiter struct {
+	rate     RateLimitRate
+	instance *limiter.Limiter
+}
+
+// NewStoreRateLimiter creates a fixed window limiter, a nil store means a memory store.
+func NewStoreRateLimiter(store limiter.Store, rate RateLimitRate) (*StoreRateLimiter, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		store = memory_store.NewStore()
+	}
+	return &StoreRateLimiter{
+		rate: rate,
+		instance: limiter.New(store, limiter.Rate{
+			Limit:  rate.Limit,
+			Period: rate.Period,
+		}),
+	}, nil
+}
+
+func (l *StoreRateLimiter) Rate() RateLimitRate {
+	return l.rate
+}
+
+func (l *StoreRateLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	limitContext, err := l.instance.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return storeRateLimitResult(limitContext), nil
+}
+
+// Peek returns whether the next request of key would be allowed without counting it.
+func (l *StoreRateLimiter) Peek(ctx context.Context, key string) (*RateLimitResult, error) {
+	limitContext, err := l.instance.Peek(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	result := storeRateLimitResult(limitContext)
+	// Peek reports the count without the next request
+	if result.Remaining <= 0 && !result.Reached {
+		result.Reached = true
+		result.RetryAfter = max(time.Until(result.Reset), 0)
+	}
+	return result, nil
+}
+
+func storeRateLimitResult(limitContext limiter.Context) *RateLimitResult {
+	result := &RateLimitResult{
+		Limit:     limitContext.Limit,
+		Remaining: limitContext.Remaining,
+		Reset:     time.Unix(limitContext.Reset, 0),
+		Reached:   limitContext.Reached,
+	}
+	if result.Reached {
+		result.RetryAfter = max(time.Until(result.Reset), 0)
+	}
+	return result
+}
+
+// TokenBucketRateLimiter refills Limit tokens per Period into a bucket of Burst tokens.
+type TokenBucketRateLimiter struct {
+	rate   RateLimitRate
+	now    func() time.Time
+	states *keyedState[tokenBucketState]
+}
+
+type tokenBucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucketRateLimiter(rate RateLimitRate) (*TokenBucketRateLimiter, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+	return &TokenBucketRateLimiter{
+		rate:   rate,
+		now:    time.Now,
+		states: newKeyedState[tokenBucketState](),
+	}, nil
+}
+
+func (l *TokenBucketRateLimiter) Rate() RateLimitRate {
+	return l.rate
+}
+
+func (l *TokenBucketRateLimiter) Allow(_ context.Context, key string) (*RateLimitResult, error) {
+	now := l.now()
+	burst := float64(l.rate.burst())
+	// tokens per nanosecond
+	refill := float64(l.rate.Limit) / float64(l.rate.Period)
+
+	result := &RateLimitResult{Limit: l.rate.burst()}
+	l.states.with(key, now, func(state *tokenBucketState, isNew bool) {
+		if isNew {
+			state.tokens = burst
+		} else {
+			state.tokens = math.Min(burst, state.tokens+float64(now.Sub(state.last))*refill)
+		}
+		state.last = now
+
+		if state.tokens >= 1 {
+			state.tokens--
+		} else {
+			result.Reached = true
+			result.RetryAfter = time.Duration(math.Ceil((1 - state.tokens) / refill))
+		}
+		result.Remaining = int64(state.tokens)
+		result.Reset = now.Add(time.Duration(math.Ceil((burst - state.tokens) / refill)))
+	})
+	return result, nil
+}
+
+// Refund puts back the token taken by Allow.
+func (l *TokenBucketRateLimiter) Refund(_ context.Context, key string) error {
+	l.states.existing(key, func(state *tokenBucketState) {
+		state.tokens = math.Min(float64(l.rate.burst()), state.tokens+1)
+	})
+	return nil
+}
+
+// SlidingWindowRateLimiter estimates the count of the sliding window
+// from the current and the previous fixed windows.
+type SlidingWindowRateLimiter struct {
+	rate   RateLimitRate
+	now    func() time.Time
+	states *keyedState[slidingWindowState]
+}
+
+type slidingWindowState struct {
+	windowStart   time.Time
+	currentCount  int64
+	previousCount int64
+}
+
+func NewSlidingWindowRateLimiter(rate RateLimitRate) (*SlidingWindowRateLimiter, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+	return &SlidingWindowRateLimiter{
+		rate:   rate,
+		now:    time.Now,
+		states: newKeyedState[slidingWindowState](),
+	}, nil
+}
+
+func (l *SlidingWindowRateLimiter) Rate() RateLimitRate {
+	return l.rate
+}
+
+func (l *SlidingWindowRateLimiter) Allow(_ context.Context, key string) (*RateLimitResult, error) {
+	now := l.now()
+	period := l.rate.Period
+	windowStart := now.Truncate(period)
+
+	result := &RateLimitResult{Limit: l.rate.Limit}
+	l.states.with(key, now, func(state *slidingWindowState, _ bool) {
+		switch elapsed := windowStart.Sub(state.windowStart); {
+		case elapsed == period:
+			state.previousCount, state.currentCount = state.currentCount, 0
+		case elapsed > period:
+			state.previousCount, state.currentCount = 0, 0
+		}
+		state.windowStart = windowStart
+
+		// weight of the previous window in the sliding window ending now
+		weight := 1 - float64(now.Sub(windowStart))/float64(period)
+		estimated := float64(state.previousCount)*weight + float64(state.currentCount)
+
+		if estimated+1 <= float64(l.rate.Limit) {
+			state.currentCount++
+			estimated++
+		} else {
+			result.Reached = true
+			result.RetryAfter = l.retryAfter(state, now, windowStart)
+		}
+		result.Remaining = max(int64(float64(l.rate.Limit)-estimated), 0)
+		result.Reset = windowStart.Add(2 * period)
+	})
+	return result, nil
+}
+
+// Refund removes the request counted by Allow, from the previous window if the window moved since.
+func (l *SlidingWindowRateLimiter) Refund(_ context.Context, key string) error {
+	l.states.existing(key, func(state *slidingWindowState) {
+		if state.currentCount > 0 {
+			state.currentCount--
+		} else if state.previousCount > 0 {
+			state.previousCount--
+		}
+	})
+	return nil
+}
+
+// retryAfter returns how long until the estimated count drops below the limit.
+func (l *SlidingWindowRateLimiter) retryAfter(
+	state *slidingWindowState, now time.Time, windowStart time.Time,
+) time.Duration {
+	period := float64(l.rate.Period)
+	limit := float64(l.rate.Limit)
+	// the previous window fades out during the current window
+	if state.previousCount > 0 && float64(state.currentCount)+1 <= limit {
+		// previousCount * (1 - t/period) + currentCount + 1 <= limit
+		t := period * (1 - (limit-1-float64(state.currentCount))/float64(state.previousCount))
+		return max(windowStart.Add(time.Duration(math.Ceil(t))).Sub(now), 0)
+	}
+	// the current window becomes the previous window at the next window start
+	nextStart := windowStart.Add(l.rate.Period)
+	if float64(state.currentCount)+1 <= limit {
+		return nextStart.Sub(now)
+	}
+	t := period * (1 - (limit-1)/float64(state.currentCount))
+	return nextStart.Add(time.Duration(math.Ceil(t))).Sub(now)
+}
+
+// SlidingLogRateLimiter keeps the timestamps of the allowed requests of the last Period.
+type SlidingLogRateLimiter struct {
+	rate   RateLimitRate
+	now    func() time.Time
+	states *keyedState[slidingLogState]
+}
+
+type slidingLogState struct {
+	log []time.Time
+}
+
+func NewSlidingLogRateLimiter(rate RateLimitRate) (*SlidingLogRateLimiter, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+	return &SlidingLogRateLimiter{
+		rate:   rate,
+		now:    time.Now,
+		states: newKeyedState[slidingLogState](),
+	}, nil
+}
+
+func (l *SlidingLogRateLimiter) Rate() RateLimitRate {
+	return l.rate
+}
+
+func (l *SlidingLogRateLimiter) Allow(_ context.Context, key string) (*RateLimitResult, error) {
+	now := l.now()
+
+	result := &RateLimitResult{Limit: l.rate.Limit}
+	l.states.with(key, now, func(state *slidingLogState, _ bool) {
+		// drop the timestamps out of the window
+		expired := 0
+		for expired < len(state.log) && !state.log[expired].After(now.Add(-l.rate.Period)) {
+			expired++
+		}
+		state.log = state.log[expired:]
+
+		if int64(len(state.log)) < l.rate.Limit {
+			state.log = append(state.log, now)
+		} else {
+			result.Reached = true
+			result.RetryAfter = state.log[0].Add(l.rate.Period).Sub(now)
+		}
+		result.Remaining = l.rate.Limit - int64(len(state.log))
+		result.Reset = state.log[len(state.log)-1].Add(l.rate.Period)
+	})
+	return result, nil
+}
+
+// Refund removes the latest timestamp of key.
+func (l *SlidingLogRateLimiter) Refund(_ context.Context, key string) error {
+	l.states.existing(key, func(state *slidingLogState) {
+		if len(state.log) > 0 {
+			state.log = state.log[:len(state.log)-1]
+		}
+	})
+	return nil
+}
+
+// GCRARateLimiter is the generic cell rate algorithm,
+// it stores the theoretical arrival time (TAT) of the next request per key.
+type GCRARateLimiter struct {
+	rate   RateLimitRate
+	now    func() time.Time
+	states *keyedState[gcraState]
+}
+
+type gcraState struct {
+	tat time.Time
+}
+
+func NewGCRARateLimiter(rate RateLimitRate) (*GCRARateLimiter, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+	return &GCRARateLimiter{
+		rate:   rate,
+		now:    time.Now,
+		states: newKeyedState[gcraState](),
+	}, nil
+}
+
+func (l *GCRARateLimiter) Rate() RateLimitRate {
+	return l.rate
+}
+
+func (l *GCRARateLimiter) Allow(_ context.Context, key string) (*RateLimitResult, error) {
+	now := l.now()
+	// emission interval and burst tolerance
+	interval := l.rate.Period / time.Duration(l.rate.Limit)
+	tolerance := interval * time.Duration(l.rate.burst()-1)
+
+	result := &RateLimitResult{Limit: l.rate.burst()}
+	l.states.with(key, now, func(state *gcraState, _ bool) {
+		tat := state.tat
+		if tat.Before(now) {
+			tat = now
+		}
+		newTat := tat.Add(interval)
+		allowAt := newTat.Add(-interval - tolerance)
+
+		if now.Before(allowAt) {
+			result.Reached = true
+			result.RetryAfter = allowAt.Sub(now)
+		} else {
+			state.tat = newTat
+			tat = newTat
+		}
+		// requests still allowed now: how many intervals fit before tat exceeds now + tolerance + interval
+		result.Remaining = max(int64((now.Add(tolerance+interval).Sub(tat))/interval), 0)
+		result.Reset = tat
+	})
+	return result, nil
+}
+
+// Refund moves the theoretical arrival time back by the interval added by Allow.
+func (l *GCRARateLimiter) Refund(_ context.Context, key string) error {
+	interval := l.rate.Period / time.Duration(l.rate.Limit)
+	l.states.existing(key, func(state *gcraState) {
+		state.tat = state.tat.Add(-interval)
+	})
+	return nil
+}
@@ -0,0 +1,459 @@
+package gin_tool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	// aligned to a second, so fixed windows start at the beginning of each second
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+// allowN sends n requests and returns how many were allowed.
+func allowN(t *testing.T, limiter IRateLimiter, key string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		result, err := limiter.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatal("Allow failed:", err)
+		}
+		if !result.Reached {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("Token Bucket", TestTokenBucketRateLimiter)
+	t.Run("Sliding Window", TestSlidingWindowRateLimiter)
+	t.Run("Sliding Log", TestSlidingLogRateLimiter)
+	t.Run("GCRA", TestGCRARateLimiter)
+	t.Run("Invalid Rate", TestInvalidRate)
+	t.Run("Window Boundary", TestWindowBoundary)
+	t.Run("Headers", TestRateLimitHeaders)
+	t.Run("Fallback", TestFallbackRateLimiter)
+	t.Run("Failure Mode", TestRateLimitFailureMode)
+	t.Run("Shadow", TestRateLimitShadow)
+}
+
+func TestTokenBucketRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter, err := NewTokenBucketRateLimiter(RateLimitRate{Limit: 10, Period: time.Second, Burst: 5})
+	if err != nil {
+		t.Fatal("NewTokenBucketRateLimiter failed:", err)
+	}
+	limiter.now = clock.Now
+
+	// Burst is available at once
+	if allowed := allowN(t, limiter, "key", 10); allowed != 5 {
+		t.Fatal("Expected burst of 5, got:", allowed)
+	}
+
+	// One token is refilled every 100ms
+	result, _ := limiter.Allow(context.Background(), "key")
+	if !result.Reached || result.RetryAfter != time.Millisecond*100 {
+		t.Fatal("Expected RetryAfter 100ms, got:", result.RetryAfter)
+	}
+	clock.Advance(time.Millisecond * 100)
+	if allowed := allowN(t, limiter, "key", 3); allowed != 1 {
+		t.Fatal("Expected 1 request after 100ms, got:", allowed)
+	}
+
+	// Keys are independent
+	if allowed := allowN(t, limiter, "other_key", 5); allowed != 5 {
+		t.Fatal("Expected burst of 5 for another key, got:", allowed)
+	}
+}
+
+func TestSlidingWindowRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter, err := NewSlidingWindowRateLimiter(RateLimitRate{Limit: 10, Period: time.Second})
+	if err != nil {
+		t.Fatal("NewSlidingWindowRateLimiter failed:", err)
+	}
+	limiter.now = clock.Now
+
+	if allowed := allowN(t, limiter, "key", 20); allowed != 10 {
+		t.Fatal("Expected 10 requests, got:", allowed)
+	}
+
+	// Half way into the next window, half of the previous window is still counted
+	clock.Advance(time.Millisecond * 1500)
+	if allowed := allowN(t, limiter, "key", 20); allowed != 5 {
+		t.Fatal("Expected 5 requests in the middle of the next window, got:", allowed)
+	}
+
+	// Two windows later everything is forgotten
+	clock.Advance(time.Second * 2)
+	if allowed := allowN(t, limiter, "key", 20); allowed != 10 {
+		t.Fatal("Expected 10 requests after two windows, got:", allowed)
+	}
+}
+
+func TestSlidingLogRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter, err := NewSlidingLogRateLimiter(RateLimitRate{Limit: 10, Period: time.Second})
+	if err != nil {
+		t.Fatal("NewSlidingLogRateLimiter failed:", err)
+	}
+	limiter.now = clock.Now
+
+	// 5 requests at 0ms, 5 requests at 500ms
+	allowN(t, limiter, "key", 5)
+	clock.Advance(time.Millisecond * 500)
+	allowN(t, limiter, "key", 5)
+
+	result, _ := limiter.Allow(context.Background(), "key")
+	if !result.Reached || result.RetryAfter != time.Millisecond*500 {
+		t.Fatal("Expected RetryAfter 500ms, got:", result.RetryAfter)
+	}
+
+	// At 1000ms only the first 5 requests left the window
+	clock.Advance(time.Millisecond * 500)
+	if allowed := allowN(t, limiter, "key", 10); allowed != 5 {
+		t.Fatal("Expected 5 requests at 1000ms, got:", allowed)
+	}
+}
+
+func TestGCRARateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter, err := NewGCRARateLimiter(RateLimitRate{Limit: 10, Period: time.Second, Burst: 5})
+	if err != nil {
+		t.Fatal("NewGCRARateLimiter failed:", err)
+	}
+	limiter.now = clock.Now
+
+	result, _ := limiter.Allow(context.Background(), "key")
+	if result.Reached || result.Remaining != 4 {
+		t.Fatal("Expected remaining 4 after first request, got:", result.Remaining)
+	}
+	if allowed := allowN(t, limiter, "key", 10); allowed != 4 {
+		t.Fatal("Expected burst of 5, got:", allowed+1)
+	}
+
+	// One request every 100ms
+	result, _ = limiter.Allow(context.Background(), "key")
+	if !result.Reached || result.RetryAfter != time.Millisecond*100 {
+		t.Fatal("Expected RetryAfter 100ms, got:", result.RetryAfter)
+	}
+	clock.Advance(time.Millisecond * 50)
+	if allowed := allowN(t, limiter, "key", 1); allowed != 0 {
+		t.Fatal("Expected no request after 50ms, got:", allowed)
+	}
+	clock.Advance(time.Millisecond * 50)
+	if allowed := allowN(t, limiter, "key", 3); allowed != 1 {
+		t.Fatal("Expected 1 request after 100ms, got:", allowed)
+	}
+}
+
+func TestInvalidRate(t *testing.T) {
+	for _, rate := range []RateLimitRate{
+		{Limit: 0, Period: time.Second},
+		{Limit: -1, Period: time.Second},
+		{Limit: 10, Period: 0},
+		{Limit: 10, Period: time.Second, Burst: -1},
+		// less than 1ns between requests
+		{Limit: 10, Period: time.Nanosecond * 5},
+	} {
+		for _, algorithm := range []string{
+			RateLimitAlgorithmFixedWindow,
+			RateLimitAlgorithmTokenBucket,
+			RateLimitAlgorithmSlidingWindow,
+			RateLimitAlgorithmSlidingLog,
+			RateLimitAlgorithmGCRA,
+		} {
+			if limiter, err := NewRateLimiter(algorithm, rate); err == nil || limiter != nil {
+				t.Fatal("Expected an error for", algorithm, rate, "got:", limiter, err)
+			}
+		}
+		if _, err := NewRedisRateLimiter("redis", nil, rate); err == nil {
+			t.Fatal("Expected an error for the Redis limiter", rate)
+		}
+	}
+	if _, err := NewRateLimiter(RateLimitAlgorithmGCRA, RateLimitRate{Limit: 10, Period: time.Nanosecond * 10}); err != nil {
+		t.Fatal("Expected 1 request per ns to be valid, got:", err)
+	}
+}
+
+// TestWindowBoundary sends a full limit right before and right after a window boundary.
+// A fixed window allows 2x Limit in that second, the algorithms below must not.
+func TestWindowBoundary(t *testing.T) {
+	rate := RateLimitRate{Limit: 10, Period: time.Second}
+	for _, algorithm := range []string{
+		RateLimitAlgorithmTokenBucket,
+		RateLimitAlgorithmSlidingWindow,
+		RateLimitAlgorithmSlidingLog,
+		RateLimitAlgorithmGCRA,
+	} {
+		t.Run(algorithm, func(t *testing.T) {
+			clock := newFakeClock()
+			limiter, err := NewRateLimiter(algorithm, rate)
+			if err != nil {
+				t.Fatal("NewRateLimiter failed:", err)
+			}
+			switch l := limiter.(type) {
+			case *TokenBucketRateLimiter:
+				l.now = clock.Now
+			case *SlidingWindowRateLimiter:
+				l.now = clock.Now
+			case *SlidingLogRateLimiter:
+				l.now = clock.Now
+			case *GCRARateLimiter:
+				l.now = clock.Now
+			}
+
+			// idle for most of the first window, then a burst at 900ms and at 1000ms
+			clock.Advance(time.Millisecond * 900)
+			allowed := allowN(t, limiter, "key", 10)
+			clock.Advance(time.Millisecond * 100)
+			allowed += allowN(t, limiter, "key", 10)
+
+			// only the token refilled in 100ms may be added to the limit
+			if allowed > int(rate.Limit)+1 {
+				t.Fatalf("Expected at most %d requests around the boundary, got: %d", rate.Limit+1, allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	middleware, err := RateLimitTool{HeaderStyle: RateLimitHeaderIETF}.NewMiddlewareWithAlgorithm(
+		RateLimitAlgorithmSlidingLog, RateLimitRate{Limit: 1, Period: time.Minute}, DefaultIdentifierBuilder,
+	)
+	if err != nil {
+		t.Fatal("NewMiddlewareWithAlgorithm failed:", err)
+	}
+	if _, err := (RateLimitTool{}).NewMiddlewareWithAlgorithm(
+		"leaky", RateLimitRate{Limit: 1, Period: time.Minute}, DefaultIdentifierBuilder,
+	); err == nil {
+		t.Fatal("Expected an error for an unknown algorithm")
+	}
+	if _, err := (RateLimitTool{}).NewMiddlewareWithMemoryStore(0, 60, DefaultIdentifierBuilder); err == nil {
+		t.Fatal("Expected an error for an invalid rate")
+	}
+	engine := gin.New()
+	engine.GET("/limited", middleware, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// First request is allowed
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/limited", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected 200, got:", w.Code)
+	}
+	if policy := w.Header().Get("RateLimit-Policy"); policy != "1;w=60" {
+		t.Fatal("Unexpected RateLimit-Policy:", policy)
+	}
+	if limit := w.Header().Get("RateLimit"); limit != "limit=1, remaining=0, reset=60" {
+		t.Fatal("Unexpected RateLimit:", limit)
+	}
+	if legacy := w.Header().Get("X-RateLimit-Limit"); legacy != "" {
+		t.Fatal("Legacy headers should not be set, got:", legacy)
+	}
+
+	// Second request is rejected with Retry-After and the policy in the body
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/limited", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatal("Expected 429, got:", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "60" {
+		t.Fatal("Unexpected Retry-After:", retryAfter)
+	}
+	body := CommonResponse[RateLimitExceeded]{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Data == nil {
+		t.Fatal("Invalid 429 body:", w.Body.String())
+	}
+	if body.Data.Policy != "1;w=60" || body.Data.RetryAfter != 60 {
+		t.Fatal("Unexpected 429 data:", w.Body.String())
+	}
+}
+
+// failingRateLimiter wraps a limiter and fails while down is set.
+type failingRateLimiter struct {
+	IRateLimiter
+	down bool
+}
+
+func (l *failingRateLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	if l.down {
+		return nil, errors.New("store is down")
+	}
+	return l.IRateLimiter.Allow(ctx, key)
+}
+
+func TestFallbackRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	slidingLog, _ := NewSlidingLogRateLimiter(RateLimitRate{Limit: 5, Period: time.Minute})
+	primary := &failingRateLimiter{IRateLimiter: slidingLog}
+	limiter, err := NewFallbackRateLimiter("test", primary, nil)
+	if err != nil {
+		t.Fatal("NewFallbackRateLimiter failed:", err)
+	}
+	limiter.now = clock.Now
+
+	if allowed := allowN(t, limiter, "key", 2); allowed != 2 {
+		t.Fatal("Expected 2 requests on the primary, got:", allowed)
+	}
+	if limiter.Health().Degraded {
+		t.Fatal("Expected healthy limiter")
+	}
+
+	// The fallback keeps limiting while the primary is down
+	primary.down = true
+	if allowed := allowN(t, limiter, "key", 10); allowed != 5 {
+		t.Fatal("Expected 5 requests on the fallback, got:", allowed)
+	}
+	health := limiter.Health()
+	if !health.Degraded || health.Failures != 1 || health.LastError == "" {
+		t.Fatal("Expected degraded limiter with 1 failure, got:", health)
+	}
+
+	// The primary is tried again after RetryInterval
+	primary.down = false
+	clock.Advance(DefaultRateLimitRetryInterval)
+	if allowed := allowN(t, limiter, "key", 5); allowed != 3 {
+		t.Fatal("Expected 3 requests on the recovered primary, got:", allowed)
+	}
+	if limiter.Health().Degraded {
+		t.Fatal("Expected recovered limiter")
+	}
+}
+
+func TestRateLimitFailureMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gcra, _ := NewGCRARateLimiter(RateLimitRate{Limit: 1, Period: time.Minute})
+	primary := &failingRateLimiter{IRateLimiter: gcra, down: true}
+	for _, failureMode := range []string{RateLimitFailClosed, RateLimitFailOpen} {
+		engine := gin.New()
+		engine.GET("/limited", RateLimitTool{FailureMode: failureMode}.MiddlewareWithLimiter(
+			primary, DefaultIdentifierBuilder,
+		), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/limited", nil))
+		expected := http.StatusServiceUnavailable
+		if failureMode == RateLimitFailOpen {
+			expected = http.StatusOK
+		}
+		if w.Code != expected {
+			t.Fatalf("Expected %d when failing %s, got: %d", expected, failureMode, w.Code)
+		}
+	}
+}
+
+func TestRateLimitShadow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	report := NewRateLimitShadowReport(nil)
+	engine := gin.New()
+	engine.Use(RateLimitTool{ShadowReport: report}.PolicyMiddleware([]RateLimitPolicy{
+		{
+			Name:   "shadow",
+			Path:   "/shadow",
+			Limits: []RateLimitRule{{Limit: 2, Period: "1m", Algorithm: RateLimitAlgorithmSlidingLog}},
+			Shadow: true,
+		},
+		{
+			Name:   "enforced",
+			Path:   "/enforced",
+			Limits: []RateLimitRule{{Limit: 2, Period: "1m", Algorithm: RateLimitAlgorithmSlidingLog}},
+		},
+		{
+			Name:       "key",
+			Path:       "/key",
+			Identifier: RateLimitIdentifierHeader + APIKeyHeaderKey,
+			Limits:     []RateLimitRule{{Limit: 1, Period: "1m", Algorithm: RateLimitAlgorithmSlidingLog}},
+			Shadow:     true,
+		},
+	}))
+	engine.GET("/report", report.Handler())
+	engine.POST("/report/reset", report.ResetHandler())
+	for _, route := range []string{"/shadow", "/enforced", "/key"} {
+		engine.GET(route, func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+	}
+
+	// Shadow requests over the limit are let through and flagged
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/shadow", nil))
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected 200 in shadow mode, got:", w.Code)
+		}
+		if throttled := w.Header().Get(RateLimitShadowHeaderKey) == "throttled"; throttled != (i >= 2) {
+			t.Fatalf("Unexpected %s header on request %d: %v", RateLimitShadowHeaderKey, i, throttled)
+		}
+		if limit := w.Header().Get("X-RateLimit-Limit"); limit != "2" {
+			t.Fatal("Expected rate limit headers in shadow mode, got:", limit)
+		}
+	}
+
+	// Other policies are still enforced
+	w := httptest.NewRecorder()
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/enforced", nil))
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatal("Expected 429 for the enforced policy, got:", w.Code)
+	}
+
+	// header values such as API keys are hashed in the report
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/key", nil)
+		req.Header.Set(APIKeyHeaderKey, "secret-key")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	snapshot := report.Snapshot()
+	if len(snapshot) != 2 || snapshot[0].Policy != "key" || snapshot[1].Policy != "shadow" {
+		t.Fatal("Expected only the shadow policies in the report, got:", snapshot)
+	}
+	stats := snapshot[1]
+	if stats.Requests != 5 || stats.Throttled != 3 || stats.Identifiers["shadow:ip:192.0.2.1"] != 3 {
+		t.Fatal("Unexpected shadow stats:", stats)
+	}
+	hashed := "key:header:X-API-Key:sha256:" + HashAPIKey("secret-key")[:16]
+	if snapshot[0].Identifiers[hashed] != 1 || len(snapshot[0].Identifiers) != 1 {
+		t.Fatal("Expected the API key hashed in the report, got:", snapshot[0].Identifiers)
+	}
+
+	// the report is only dropped by a POST
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/report?reset=true", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret-key") || len(report.Snapshot()) != 2 {
+		t.Fatal("Expected the report kept by a GET, got:", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/report/reset", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), hashed) || len(report.Snapshot()) != 0 {
+		t.Fatal("Expected the report returned and dropped by a POST, got:", w.Code, w.Body.String())
+	}
+}
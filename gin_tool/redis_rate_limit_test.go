@@ -0,0 +1,202 @@
+package gin_tool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/ulule/limiter"
+	memory_store "github.com/ulule/limiter/drivers/store/memory"
+)
+
+// newTestRedis starts an in-process Redis stand-in, closed when the test ends.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: 0})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestRedisRateLimit(t *testing.T) {
+	t.Run("Shared Limit", TestRedisSharedLimit)
+	t.Run("Shared Policies", TestRedisSharedPolicies)
+	t.Run("Redis Down", TestRedisRateLimitDown)
+	t.Run("Redis Unavailable At Startup", TestRedisStoreUnavailableAtStartup)
+	t.Run("Store Creation Backoff", TestLazyStoreBackoff)
+	t.Run("Unsupported Algorithms", TestRedisUnsupportedAlgorithms)
+}
+
+// newRedisStoreMiddleware limits requests to rateLimit per minute on client.
+func newRedisStoreMiddleware(t *testing.T, client *redis.Client, rateLimit int64) gin.HandlerFunc {
+	middleware, err := RateLimitTool{}.NewMiddlewareWithRedisStore(client, rateLimit, 60, DefaultIdentifierBuilder)
+	if err != nil {
+		t.Fatal("NewMiddlewareWithRedisStore failed:", err)
+	}
+	return middleware
+}
+
+// TestRedisSharedLimit runs two engines, like two instances of the service, on one Redis.
+func TestRedisSharedLimit(t *testing.T) {
+	_, client := newTestRedis(t)
+	first := newRateLimitedEngine(newRedisStoreMiddleware(t, client, 10))
+	second := newRateLimitedEngine(newRedisStoreMiddleware(t, client, 10))
+
+	allowed := serveN(first, "/limited", 6) + serveN(second, "/limited", 6)
+	if allowed != 10 {
+		t.Fatal("Expected 10 requests allowed by both engines, got:", allowed)
+	}
+}
+
+func TestRedisSharedPolicies(t *testing.T) {
+	_, client := newTestRedis(t)
+	policies := []RateLimitPolicy{{
+		Name:       "limited",
+		Path:       "/limited",
+		Identifier: RateLimitIdentifierGlobal,
+		Limits:     []RateLimitRule{{Limit: 5, Period: "1m"}},
+	}}
+	created := 0
+	factory := RedisRateLimiterFactory(client, func(*FallbackRateLimiter) { created++ })
+	first := newRateLimitedEngine(RateLimitTool{}.PolicyMiddlewareWithFactory(policies, factory))
+	second := newRateLimitedEngine(RateLimitTool{}.PolicyMiddlewareWithFactory(policies, factory))
+
+	if created != 2 {
+		t.Fatal("Expected 2 Redis limiters, got:", created)
+	}
+	allowed := serveN(first, "/limited", 4) + serveN(second, "/limited", 4)
+	if allowed != 5 {
+		t.Fatal("Expected 5 requests allowed by both engines, got:", allowed)
+	}
+}
+
+func TestRedisRateLimitDown(t *testing.T) {
+	server, client := newTestRedis(t)
+	rateLimiter, err := NewRedisRateLimiter("redis", client, RateLimitRate{Limit: 3, Period: time.Minute})
+	if err != nil {
+		t.Fatal("NewRedisRateLimiter failed:", err)
+	}
+	// Redis is tried again on every request
+	rateLimiter.RetryInterval = 0
+	engine := newRateLimitedEngine(RateLimitTool{}.MiddlewareWithLimiter(rateLimiter, DefaultIdentifierBuilder))
+
+	if allowed := serveN(engine, "/limited", 2); allowed != 2 {
+		t.Fatal("Expected 2 requests on Redis, got:", allowed)
+	}
+
+	// Requests are limited in memory while Redis is down
+	server.Close()
+	if allowed := serveN(engine, "/limited", 5); allowed != 3 {
+		t.Fatal("Expected 3 requests on the fallback, got:", allowed)
+	}
+	if !rateLimiter.Health().Degraded {
+		t.Fatal("Expected degraded limiter")
+	}
+
+	// Redis is used again once it is back, the count kept in Redis still applies
+	if err := server.Restart(); err != nil {
+		t.Fatal("Restart failed:", err)
+	}
+	if allowed := serveN(engine, "/limited", 5); allowed != 1 {
+		t.Fatal("Expected 1 request on the recovered Redis, got:", allowed)
+	}
+	if rateLimiter.Health().Degraded {
+		t.Fatal("Expected recovered limiter")
+	}
+}
+
+// TestRedisStoreUnavailableAtStartup checks that setup does not fail without Redis.
+func TestRedisStoreUnavailableAtStartup(t *testing.T) {
+	server, client := newTestRedis(t)
+	server.Close()
+
+	engine := newRateLimitedEngine(newRedisStoreMiddleware(t, client, 2))
+	if allowed := serveN(engine, "/limited", 3); allowed != 2 {
+		t.Fatal("Expected 2 requests on the fallback, got:", allowed)
+	}
+}
+
+func TestLazyStoreBackoff(t *testing.T) {
+	clock := newFakeClock()
+	calls := 0
+	dialing, dialed := make(chan struct{}), make(chan struct{})
+	var storeErr error
+	l := &lazyStoreRateLimiter{
+		rate: RateLimitRate{Limit: 10, Period: time.Minute},
+		newStore: func() (limiter.Store, error) {
+			calls++
+			if calls == 1 {
+				close(dialing)
+				<-dialed
+			}
+			if storeErr != nil {
+				return nil, storeErr
+			}
+			return memory_store.NewStore(), nil
+		},
+		now: clock.Now,
+	}
+
+	// callers do not wait for a store being created
+	storeErr = errors.New("connection refused")
+	first := make(chan error)
+	go func() {
+		_, err := l.Allow(context.Background(), "key")
+		first <- err
+	}()
+	<-dialing
+	if _, err := l.Allow(context.Background(), "key"); !errors.Is(err, ErrRateLimitStoreUnavailable) {
+		t.Fatal("Expected ErrRateLimitStoreUnavailable while the store is created, got:", err)
+	}
+	close(dialed)
+	if err := <-first; !errors.Is(err, storeErr) {
+		t.Fatal("Expected the error of the store, got:", err)
+	}
+
+	// after a failure the store is created again once the backoff ends, doubled on each failure
+	for _, backoff := range []time.Duration{time.Second, time.Second * 2} {
+		before := calls
+		clock.Advance(backoff - time.Millisecond)
+		if _, err := l.Allow(context.Background(), "key"); !errors.Is(err, storeErr) || calls != before {
+			t.Fatal("Expected the last error until the backoff ends, got:", err, calls-before, "calls")
+		}
+		clock.Advance(time.Millisecond)
+		if _, err := l.Allow(context.Background(), "key"); err == nil || calls != before+1 {
+			t.Fatal("Expected one more failed attempt after the backoff, got:", err, calls-before, "calls")
+		}
+	}
+
+	storeErr = nil
+	clock.Advance(time.Second * 4)
+	if result, err := l.Allow(context.Background(), "key"); err != nil || result.Reached {
+		t.Fatal("Expected the store created after the backoff, got:", result, err)
+	}
+}
+
+func TestRedisUnsupportedAlgorithms(t *testing.T) {
+	_, client := newTestRedis(t)
+	factory := RedisRateLimiterFactory(client, nil)
+	rate := RateLimitRate{Limit: 5, Period: time.Minute}
+	for _, algorithm := range []string{
+		RateLimitAlgorithmTokenBucket,
+		RateLimitAlgorithmSlidingWindow,
+		RateLimitAlgorithmSlidingLog,
+		RateLimitAlgorithmGCRA,
+	} {
+		if rateLimiter, err := factory(algorithm, rate); err == nil || rateLimiter != nil {
+			t.Fatal("Expected", algorithm, "rejected on Redis, got:", rateLimiter, err)
+		}
+	}
+	if _, err := factory("", rate); err != nil {
+		t.Fatal("Expected fixed_window supported on Redis, got:", err)
+	}
+	if _, err := (RateLimitTool{}).NewPolicyMiddleware([]RateLimitPolicy{{
+		Name:   "gcra",
+		Limits: []RateLimitRule{{Limit: 5, Period: "1m", Algorithm: RateLimitAlgorithmGCRA}},
+	}}, factory); err == nil {
+		t.Fatal("Expected the policies rejected")
+	}
+}
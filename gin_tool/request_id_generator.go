@@ -0,0 +1,300 @@
+package gin_tool
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RequestIDFormatDefault   = "default"
+	RequestIDFormatULID      = "ulid"
+	RequestIDFormatUUIDv7    = "uuidv7"
+	RequestIDFormatSnowflake = "snowflake"
+)
+
+const (
+	// SnowflakeEpoch is the custom epoch (2024-01-01 UTC) in milliseconds.
+	SnowflakeEpoch = int64(1704067200000)
+
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	SnowflakeMaxNodeID    = int64(1)<<snowflakeNodeBits - 1
+	snowflakeMaxSequence  = int64(1)<<snowflakeSequenceBits - 1
+	// snowflakeMaxClockSkew is how far in the future the timestamp of a parsed snowflake ID may be.
+	snowflakeMaxClockSkew = time.Hour
+)
+
+// crockfordAlphabet is the base32 alphabet used by ULID.
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ErrInvalidRequestID = errors.New("invalid request id")
+
+// Check if generators implement IRequestIDGenerator
+var (
+	_ IRequestIDGenerator = DefaultRequestIDGenerator{}
+	_ IRequestIDGenerator = ULIDRequestIDGenerator{}
+	_ IRequestIDGenerator = UUIDv7RequestIDGenerator{}
+	_ IRequestIDGenerator = &SnowflakeRequestIDGenerator{}
+)
+
+/*
+IRequestIDGenerator:
+    1. IRequestIDGenerator is the strategy used by RequestIDTool to build new request IDs.
+    2. Every built-in generator prefixes the ID with the service name,
+        so ParseRequestID can recover the service name and timestamp from any of them:
+        - default:   {service}:{unix_second}:{micro}:{uuid_prefix}
+        - ulid:      {service}:{ULID}
+        - uuidv7:    {service}:{UUIDv7}
+        - snowflake: {service}:{snowflake_id}
+*/
+// IRequestIDGenerator generates request IDs.
+type IRequestIDGenerator interface {
+	Generate() string
+}
+
+// RequestIDInfo is the information extracted from a request ID.
+type RequestIDInfo struct {
+	ServiceName string    `json:"service_name"`
+	Format      string    `json:"format"`
+	Timestamp   time.Time `json:"timestamp"`
+	// NodeID is only set for snowflake IDs.
+	NodeID int64 `json:"node_id,omitempty"`
+}
+
+// DefaultRequestIDGenerator keeps the format of GenerateRequestID.
+type DefaultRequestIDGenerator struct {
+	ServiceName string
+}
+
+func (g DefaultRequestIDGenerator) Generate() string {
+	return GenerateRequestID(g.ServiceName)
+}
+
+// ULIDRequestIDGenerator generates time-sortable ULIDs.
+type ULIDRequestIDGenerator struct {
+	ServiceName string
+}
+
+func (g ULIDRequestIDGenerator) Generate() string {
+	return g.ServiceName + ":" + NewULID(time.Now())
+}
+
+// UUIDv7RequestIDGenerator generates time-sortable UUIDv7s.
+type UUIDv7RequestIDGenerator struct {
+	ServiceName string
+}
+
+func (g UUIDv7RequestIDGenerator) Generate() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		// fallback to the default format, a request ID must always be generated
+		return GenerateRequestID(g.ServiceName)
+	}
+	return g.ServiceName + ":" + id.String()
+}
+
+// SnowflakeRequestIDGenerator generates 64-bit snowflake IDs:
+// 41 bits milliseconds since SnowflakeEpoch, 10 bits node ID, 12 bits sequence.
+type SnowflakeRequestIDGenerator struct {
+	serviceName string
+	nodeID      int64
+	now         func() time.Time
+
+	mu        sync.Mutex
+	lastMilli int64
+	sequence  int64
+}
+
+func NewSnowflakeRequestIDGenerator(
+	serviceName string, nodeID int64,
+) (*SnowflakeRequestIDGenerator, error) {
+	if nodeID < 0 || nodeID > SnowflakeMaxNodeID {
+		return nil, fmt.Errorf("snowflake node id must be in [0, %d], got %d", SnowflakeMaxNodeID, nodeID)
+	}
+	return &SnowflakeRequestIDGenerator{
+		serviceName: serviceName,
+		nodeID:      nodeID,
+		now:         time.Now,
+	}, nil
+}
+
+func (g *SnowflakeRequestIDGenerator) Generate() string {
+	return g.serviceName + ":" + strconv.FormatInt(g.NextID(), 10)
+}
+
+// NextID returns the next raw snowflake ID.
+func (g *SnowflakeRequestIDGenerator) NextID() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now().UnixMilli()
+	// clock moved backwards: keep using the last millisecond
+	if now < g.lastMilli {
+		now = g.lastMilli
+	}
+	if now == g.lastMilli {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSequence
+		// sequence exhausted: wait for the next millisecond
+		if g.sequence == 0 {
+			for now <= g.lastMilli {
+				time.Sleep(time.Microsecond * 100)
+				now = g.now().UnixMilli()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMilli = now
+
+	return (now-SnowflakeEpoch)<<(snowflakeNodeBits+snowflakeSequenceBits) |
+		g.nodeID<<snowflakeSequenceBits |
+		g.sequence
+}
+
+// NewULID returns a ULID string for the given time.
+func NewULID(t time.Time) string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(t.UnixMilli())<<16)
+	_, _ = rand.Read(id[6:])
+	return encodeULID(id)
+}
+
+// encodeULID encodes 128 bits into 26 crockford base32 characters.
+func encodeULID(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// decodeULID decodes 26 crockford base32 characters into 128 bits.
+func decodeULID(s string) ([16]byte, bool) {
+	var id [16]byte
+	// the first character only holds 3 bits
+	if len(s) != 26 || s[0] > '7' {
+		return id, false
+	}
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(crockfordAlphabet, s[i])
+		if v < 0 {
+			return id, false
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	binary.BigEndian.PutUint64(id[:8], hi)
+	binary.BigEndian.PutUint64(id[8:], lo)
+	return id, true
+}
+
+// decodeULIDTime decodes the 48-bit millisecond timestamp of a ULID.
+func decodeULIDTime(s string) (time.Time, bool) {
+	id, ok := decodeULID(s)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(id[:8]) >> 16)), true
+}
+
+// ParseRequestID extracts the service name and timestamp from a request ID
+// generated by any of the built-in generators.
+func ParseRequestID(requestID string) (*RequestIDInfo, error) {
+	// default: {service}:{unix_second}:{micro}:{uuid_prefix}
+	if parts := strings.Split(requestID, ":"); len(parts) >= 4 {
+		n := len(parts)
+		second, errS := strconv.ParseInt(parts[n-3], 10, 64)
+		micro, errM := strconv.ParseInt(parts[n-2], 10, 64)
+		if errS == nil && errM == nil && second >= 0 && micro >= 0 && micro < 1000000 && isLowerHex(parts[n-1], 8) {
+			return &RequestIDInfo{
+				ServiceName: strings.Join(parts[:n-3], ":"),
+				Format:      RequestIDFormatDefault,
+				Timestamp:   time.UnixMicro(second*1000000 + micro),
+			}, nil
+		}
+	}
+
+	idx := strings.LastIndex(requestID, ":")
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRequestID, requestID)
+	}
+	serviceName, body := requestID[:idx], requestID[idx+1:]
+
+	// ulid
+	if t, ok := decodeULIDTime(body); ok {
+		return &RequestIDInfo{
+			ServiceName: serviceName,
+			Format:      RequestIDFormatULID,
+			Timestamp:   t,
+		}, nil
+	}
+
+	// uuidv7
+	if id, err := uuid.Parse(body); err == nil && id.Version() == 7 {
+		milli := int64(binary.BigEndian.Uint64(id[:8]) >> 16)
+		return &RequestIDInfo{
+			ServiceName: serviceName,
+			Format:      RequestIDFormatUUIDv7,
+			Timestamp:   time.UnixMilli(milli),
+		}, nil
+	}
+
+	// snowflake
+	if id, ok := parseSnowflakeID(body); ok {
+		milli := id>>(snowflakeNodeBits+snowflakeSequenceBits) + SnowflakeEpoch
+		return &RequestIDInfo{
+			ServiceName: serviceName,
+			Format:      RequestIDFormatSnowflake,
+			Timestamp:   time.UnixMilli(milli),
+			NodeID:      id >> snowflakeSequenceBits & SnowflakeMaxNodeID,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrInvalidRequestID, requestID)
+}
+
+// parseSnowflakeID parses body as a snowflake ID. Any number fits in 64 bits,
+// so only a canonical decimal whose timestamp is after SnowflakeEpoch
+// and at most snowflakeMaxClockSkew in the future is accepted.
+func parseSnowflakeID(body string) (int64, bool) {
+	id, err := strconv.ParseInt(body, 10, 64)
+	if err != nil || strconv.FormatInt(id, 10) != body {
+		return 0, false
+	}
+	elapsed := id >> (snowflakeNodeBits + snowflakeSequenceBits)
+	if elapsed <= 0 || elapsed+SnowflakeEpoch > time.Now().Add(snowflakeMaxClockSkew).UnixMilli() {
+		return 0, false
+	}
+	return id, true
+}
+
+// NewRequestIDGenerator builds a generator by format name,
+// nodeID is only used by the snowflake format.
+func NewRequestIDGenerator(format string, serviceName string, nodeID int64) (IRequestIDGenerator, error) {
+	switch format {
+	case "", RequestIDFormatDefault:
+		return DefaultRequestIDGenerator{ServiceName: serviceName}, nil
+	case RequestIDFormatULID:
+		return ULIDRequestIDGenerator{ServiceName: serviceName}, nil
+	case RequestIDFormatUUIDv7:
+		return UUIDv7RequestIDGenerator{ServiceName: serviceName}, nil
+	case RequestIDFormatSnowflake:
+		return NewSnowflakeRequestIDGenerator(serviceName, nodeID)
+	default:
+		return nil, fmt.Errorf("unknown request id format: %q", format)
+	}
+}
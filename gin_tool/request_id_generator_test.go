@@ -0,0 +1,187 @@
+package gin_tool
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestIDGenerator(t *testing.T) {
+	t.Run("Parse", TestParseRequestID)
+	t.Run("ULID Round Trip", TestULIDRoundTrip)
+	t.Run("UUIDv7 Bits", TestUUIDv7RequestID)
+	t.Run("Snowflake Sequence Rollover", TestSnowflakeSequenceRollover)
+	t.Run("Factory", TestNewRequestIDGenerator)
+}
+
+func TestParseRequestID(t *testing.T) {
+	snowflake, _ := NewSnowflakeRequestIDGenerator("svc", 42)
+	for _, tc := range []struct {
+		generator IRequestIDGenerator
+		format    string
+		precision time.Duration
+	}{
+		{DefaultRequestIDGenerator{ServiceName: "svc"}, RequestIDFormatDefault, time.Microsecond},
+		{ULIDRequestIDGenerator{ServiceName: "svc"}, RequestIDFormatULID, time.Millisecond},
+		{UUIDv7RequestIDGenerator{ServiceName: "svc"}, RequestIDFormatUUIDv7, time.Millisecond},
+		{snowflake, RequestIDFormatSnowflake, time.Millisecond},
+	} {
+		before := time.Now().Truncate(tc.precision)
+		requestID := tc.generator.Generate()
+		after := time.Now()
+
+		info, err := ParseRequestID(requestID)
+		if err != nil {
+			t.Fatal("ParseRequestID failed for", requestID, err)
+		}
+		if info.ServiceName != "svc" || info.Format != tc.format {
+			t.Fatal("Unexpected info of", requestID, "got:", info)
+		}
+		if info.Timestamp.Before(before) || info.Timestamp.After(after) {
+			t.Fatal("Expected timestamp of", requestID, "between", before, "and", after, "got:", info.Timestamp)
+		}
+	}
+
+	info, _ := ParseRequestID(snowflake.Generate())
+	if info.NodeID != 42 {
+		t.Fatal("Expected snowflake node id 42, got:", info.NodeID)
+	}
+	// the service name may contain the separator
+	info, err := ParseRequestID(GenerateRequestID("a:b"))
+	if err != nil || info.ServiceName != "a:b" {
+		t.Fatal("Expected service name a:b, got:", info, err)
+	}
+
+	farFuture := (time.Now().Add(time.Hour*24).UnixMilli() - SnowflakeEpoch) << (snowflakeNodeBits + snowflakeSequenceBits)
+	for _, requestID := range []string{
+		"", "no-separator", "svc:not-an-id", "svc:-1", "svc:" + uuid.NewString(),
+		// all-digit bodies which are not snowflake IDs
+		"svc:42", "svc:0" + strconv.FormatInt(snowflake.NextID(), 10), "svc:" + strconv.FormatInt(farFuture, 10),
+		// the default format needs a hex suffix and microseconds below 1s
+		"svc:1700000000:123456:not-hex!", "svc:1700000000:123456:ABCDEF12", "svc:1700000000:1000000:abcdef12",
+	} {
+		if _, err := ParseRequestID(requestID); !errors.Is(err, ErrInvalidRequestID) {
+			t.Fatal("Expected ErrInvalidRequestID for", requestID, "got:", err)
+		}
+	}
+}
+
+func TestULIDRoundTrip(t *testing.T) {
+	var id [16]byte
+	for i := range id {
+		id[i] = byte(i*37 + 11)
+	}
+	id[0] = 0x7f
+	encoded := encodeULID(id)
+	if len(encoded) != 26 {
+		t.Fatal("Expected 26 characters, got:", encoded)
+	}
+	decoded, ok := decodeULID(encoded)
+	if !ok || decoded != id {
+		t.Fatal("Expected round trip of", id, "got:", decoded, ok)
+	}
+
+	// the maximum ULID, and an overflowing one
+	if decoded, ok := decodeULID("7ZZZZZZZZZZZZZZZZZZZZZZZZZ"); !ok || decoded != [16]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	} {
+		t.Fatal("Expected the maximum ULID decoded, got:", decoded, ok)
+	}
+	for _, invalid := range []string{"8ZZZZZZZZZZZZZZZZZZZZZZZZZ", "0000000000000000000000000U", "00000"} {
+		if _, ok := decodeULID(invalid); ok {
+			t.Fatal("Expected invalid ULID:", invalid)
+		}
+	}
+
+	// ULIDs of later milliseconds sort after earlier ones
+	now := time.UnixMilli(time.Now().UnixMilli())
+	first, second := NewULID(now), NewULID(now.Add(time.Millisecond))
+	if first >= second {
+		t.Fatal("Expected ULIDs sorted by time, got:", first, second)
+	}
+	if decodedTime, ok := decodeULIDTime(first); !ok || !decodedTime.Equal(now) {
+		t.Fatal("Expected ULID time", now, "got:", decodedTime)
+	}
+}
+
+func TestUUIDv7RequestID(t *testing.T) {
+	requestID := UUIDv7RequestIDGenerator{ServiceName: "svc"}.Generate()
+	body, ok := strings.CutPrefix(requestID, "svc:")
+	if !ok {
+		t.Fatal("Expected service name prefix, got:", requestID)
+	}
+	id, err := uuid.Parse(body)
+	if err != nil {
+		t.Fatal("Expected a UUID, got:", body, err)
+	}
+	// version nibble 0111 and variant bits 10
+	if id[6]>>4 != 7 || id.Version() != 7 {
+		t.Fatal("Expected version 7, got:", id.Version())
+	}
+	if id[8]>>6 != 0b10 || id.Variant() != uuid.RFC4122 {
+		t.Fatal("Expected the RFC 4122 variant, got:", id.Variant())
+	}
+}
+
+func TestSnowflakeSequenceRollover(t *testing.T) {
+	generator, _ := NewSnowflakeRequestIDGenerator("svc", 1)
+	base := time.UnixMilli(SnowflakeEpoch + 1000)
+	calls := 0
+	// the clock stays in the same millisecond until the sequence is exhausted
+	generator.now = func() time.Time {
+		calls++
+		if calls <= int(snowflakeMaxSequence)+2 {
+			return base
+		}
+		return base.Add(time.Millisecond)
+	}
+
+	var last int64 = -1
+	for i := int64(0); i <= snowflakeMaxSequence; i++ {
+		id := generator.NextID()
+		if id <= last {
+			t.Fatal("Expected increasing IDs, got:", id, "after", last)
+		}
+		if sequence := id & snowflakeMaxSequence; sequence != i {
+			t.Fatal("Expected sequence", i, "got:", sequence)
+		}
+		last = id
+	}
+
+	// the next ID waits for the next millisecond and restarts the sequence
+	id := generator.NextID()
+	if sequence := id & snowflakeMaxSequence; sequence != 0 {
+		t.Fatal("Expected the sequence restarted, got:", sequence)
+	}
+	if milli := id>>(snowflakeNodeBits+snowflakeSequenceBits) + SnowflakeEpoch; milli != base.UnixMilli()+1 {
+		t.Fatal("Expected the next millisecond, got:", milli)
+	}
+	if id <= last {
+		t.Fatal("Expected increasing IDs across the rollover, got:", id, "after", last)
+	}
+
+	// a clock moving backwards keeps the last millisecond
+	generator.now = func() time.Time { return base }
+	if next := generator.NextID(); next <= id {
+		t.Fatal("Expected increasing IDs when the clock moves backwards, got:", next, "after", id)
+	}
+}
+
+func TestNewRequestIDGenerator(t *testing.T) {
+	for _, format := range []string{"", RequestIDFormatDefault, RequestIDFormatULID, RequestIDFormatUUIDv7, RequestIDFormatSnowflake} {
+		generator, err := NewRequestIDGenerator(format, "svc", 1)
+		if err != nil || generator == nil {
+			t.Fatal("NewRequestIDGenerator failed for", format, err)
+		}
+	}
+	if _, err := NewRequestIDGenerator("xid", "svc", 1); err == nil {
+		t.Fatal("Expected error for an unknown format")
+	}
+	if _, err := NewRequestIDGenerator(RequestIDFormatSnowflake, "svc", SnowflakeMaxNodeID+1); err == nil {
+		t.Fatal("Expected error for an out of range node id")
+	}
+}
@@ -0,0 +1,151 @@
+package gin_tool
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// UntrustedRequestIDReplace replaces untrusted request IDs with a generated one.
+	UntrustedRequestIDReplace = "replace"
+	// UntrustedRequestIDPrefix keeps untrusted request IDs but marks them with a prefix.
+	UntrustedRequestIDPrefix = "prefix"
+)
+
+const (
+	OriginalRequestIDKey = "_original_request_id"
+	// maxOriginalRequestIDLength limits how much of a rejected ID is recorded.
+	maxOriginalRequestIDLength = 256
+)
+
+// DefaultRequestIDPattern accepts the formats of all built-in generators.
+var DefaultRequestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]+$`)
+
+/*
+RequestIDPolicy:
+    1. RequestIDPolicy decides whether an inbound X-Request-ID can be used as is.
+    2. An inbound ID is invalid if it is longer than MaxLength or does not match Pattern,
+        invalid IDs are always replaced with a generated one.
+    3. An inbound ID is untrusted unless the remote IP (the direct peer, not X-Forwarded-For)
+        is in TrustedProxies, an empty TrustedProxies trusts no client.
+        Untrusted IDs are handled by UntrustedAction,
+        a prefixed ID longer than MaxLength is replaced with a generated one.
+    4. Whenever the inbound ID is not used as is, it is recorded in HttpRequest.OriginalRequestID.
+    5. Compile returns a compiled copy, the policy itself is left unchanged and can be shared.
+*/
+// RequestIDPolicy is the validation and trust policy for inbound request IDs.
+type RequestIDPolicy struct {
+	// MaxLength is the maximum length of an inbound ID, 0 means unlimited.
+	MaxLength int
+	// Pattern is the allowed format of an inbound ID, nil means any.
+	Pattern *regexp.Regexp
+	// TrustedProxies is a list of IPs or CIDRs, empty means no client is trusted,
+	// "0.0.0.0/0" and "::/0" trust every client.
+	TrustedProxies []string
+	// UntrustedAction is UntrustedRequestIDReplace or UntrustedRequestIDPrefix.
+	UntrustedAction string
+	// UntrustedPrefix is used by UntrustedRequestIDPrefix.
+	UntrustedPrefix string
+
+	trustedNets []*net.IPNet
+}
+
+func DefaultRequestIDPolicy() *RequestIDPolicy {
+	return &RequestIDPolicy{
+		MaxLength:       128,
+		Pattern:         DefaultRequestIDPattern,
+		UntrustedAction: UntrustedRequestIDReplace,
+		UntrustedPrefix: "untrusted-",
+	}
+}
+
+// Compile returns a copy of the policy with TrustedProxies parsed, only the copy can be used.
+func (p *RequestIDPolicy) Compile() (*RequestIDPolicy, error) {
+	compiled := *p
+	compiled.TrustedProxies = append([]string(nil), p.TrustedProxies...)
+	compiled.trustedNets = make([]*net.IPNet, 0, len(p.TrustedProxies))
+	for _, proxy := range compiled.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		compiled.trustedNets = append(compiled.trustedNets, ipNet)
+	}
+	switch p.UntrustedAction {
+	case "", UntrustedRequestIDReplace, UntrustedRequestIDPrefix:
+	default:
+		return nil, fmt.Errorf("unknown untrusted request id action: %q", p.UntrustedAction)
+	}
+	return &compiled, nil
+}
+
+// IsValid checks the length and format of an inbound ID.
+func (p *RequestIDPolicy) IsValid(requestID string) bool {
+	if requestID == "" {
+		return false
+	}
+	if p.MaxLength > 0 && len(requestID) > p.MaxLength {
+		return false
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(requestID) {
+		return false
+	}
+	return true
+}
+
+// IsTrusted checks whether the remote IP is allowed to set the request ID,
+// a policy which is not compiled trusts no client.
+func (p *RequestIDPolicy) IsTrusted(remoteIP string) bool {
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p.trustedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the request ID to use for an inbound ID,
+// and whether the inbound ID was accepted as is.
+func (p *RequestIDPolicy) Resolve(
+	requestID string, remoteIP string, generator IRequestIDGenerator,
+) (string, bool) {
+	if !p.IsValid(requestID) {
+		return generator.Generate(), false
+	}
+	if p.IsTrusted(remoteIP) {
+		return requestID, true
+	}
+	if p.UntrustedAction == UntrustedRequestIDPrefix {
+		if prefixed := p.UntrustedPrefix + requestID; p.MaxLength <= 0 || len(prefixed) <= p.MaxLength {
+			return prefixed, false
+		}
+	}
+	return generator.Generate(), false
+}
+
+func SetOriginalRequestID(c *gin.Context, requestID string) {
+	if len(requestID) > maxOriginalRequestIDLength {
+		requestID = requestID[:maxOriginalRequestIDLength]
+	}
+	c.Set(OriginalRequestIDKey, requestID)
+}
+
+func GetOriginalRequestID(c *gin.Context) (string, bool) {
+	requestID := c.GetString(OriginalRequestIDKey)
+	return requestID, requestID != ""
+}
@@ -0,0 +1,185 @@
+package gin_tool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fixedRequestIDGenerator always generates the same ID.
+type fixedRequestIDGenerator string
+
+func (g fixedRequestIDGenerator) Generate() string {
+	return string(g)
+}
+
+func TestRequestIDPolicy(t *testing.T) {
+	t.Run("Is Valid", TestRequestIDPolicyIsValid)
+	t.Run("Is Trusted", TestRequestIDPolicyIsTrusted)
+	t.Run("Untrusted Actions", TestRequestIDPolicyResolve)
+	t.Run("Middleware", TestRequestIDPolicyMiddleware)
+}
+
+func TestRequestIDPolicyIsValid(t *testing.T) {
+	policy := DefaultRequestIDPolicy()
+	for requestID, valid := range map[string]bool{
+		"svc:1700000000:123456:abcdef01": true,
+		"svc:01HF8ZQ7Y8M4S9K2W3X5V6N7P0": true,
+		"a.b_c-d":                        true,
+		"":                               false,
+		"with space":                     false,
+		"line\nbreak":                    false,
+		"<script>":                       false,
+		strings.Repeat("a", 128):         true,
+		strings.Repeat("a", 129):         false,
+	} {
+		if policy.IsValid(requestID) != valid {
+			t.Fatal("Expected IsValid", valid, "for", requestID)
+		}
+	}
+
+	// no limits but the empty ID
+	unlimited := &RequestIDPolicy{}
+	if !unlimited.IsValid(strings.Repeat("é", 1000)) || unlimited.IsValid("") {
+		t.Fatal("Expected only the empty ID invalid without MaxLength and Pattern")
+	}
+	custom := &RequestIDPolicy{Pattern: regexp.MustCompile(`^[0-9]+$`)}
+	if !custom.IsValid("123") || custom.IsValid("abc") {
+		t.Fatal("Expected the custom pattern used")
+	}
+}
+
+func TestRequestIDPolicyIsTrusted(t *testing.T) {
+	proxies := []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8", "::1"}
+	policy, err := (&RequestIDPolicy{TrustedProxies: proxies}).Compile()
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+	// the compiled copy does not share TrustedProxies with the policy
+	proxies[0] = "11.0.0.0/8"
+	for ip, trusted := range map[string]bool{
+		"10.1.2.3":    true,
+		"11.0.0.1":    false,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"fd00::1":     true,
+		"fe80::1":     false,
+		"::1":         true,
+		"":            false,
+		"not-an-ip":   false,
+	} {
+		if policy.IsTrusted(ip) != trusted {
+			t.Fatal("Expected IsTrusted", trusted, "for", ip)
+		}
+	}
+
+	empty, err := (&RequestIDPolicy{}).Compile()
+	if err != nil || empty.IsTrusted("11.0.0.1") || empty.IsTrusted("127.0.0.1") {
+		t.Fatal("Expected every client distrusted without TrustedProxies:", err)
+	}
+	if (&RequestIDPolicy{TrustedProxies: []string{"10.0.0.0/8"}}).IsTrusted("10.1.2.3") {
+		t.Fatal("Expected every client distrusted by a policy which is not compiled")
+	}
+	all, err := (&RequestIDPolicy{TrustedProxies: []string{"0.0.0.0/0", "::/0"}}).Compile()
+	if err != nil || !all.IsTrusted("11.0.0.1") || !all.IsTrusted("fe80::1") {
+		t.Fatal("Expected every client trusted with 0.0.0.0/0 and ::/0:", err)
+	}
+	for _, invalid := range []*RequestIDPolicy{
+		{TrustedProxies: []string{"10.0.0.0/33"}},
+		{TrustedProxies: []string{"proxy.local"}},
+		{UntrustedAction: "drop"},
+	} {
+		if _, err := invalid.Compile(); err == nil {
+			t.Fatal("Expected Compile error for", invalid.TrustedProxies, invalid.UntrustedAction)
+		}
+	}
+}
+
+func TestRequestIDPolicyResolve(t *testing.T) {
+	generator := fixedRequestIDGenerator("svc:generated")
+	configured := DefaultRequestIDPolicy()
+	configured.TrustedProxies = []string{"10.0.0.1"}
+	policy, err := configured.Compile()
+	if err != nil {
+		t.Fatal("Compile failed:", err)
+	}
+
+	if requestID, accepted := policy.Resolve("inbound", "10.0.0.1", generator); !accepted || requestID != "inbound" {
+		t.Fatal("Expected the ID of a trusted proxy accepted, got:", requestID, accepted)
+	}
+	if requestID, accepted := policy.Resolve("in bound", "10.0.0.1", generator); accepted || requestID != "svc:generated" {
+		t.Fatal("Expected an invalid ID replaced, got:", requestID, accepted)
+	}
+	if requestID, accepted := policy.Resolve("inbound", "10.0.0.2", generator); accepted || requestID != "svc:generated" {
+		t.Fatal("Expected an untrusted ID replaced, got:", requestID, accepted)
+	}
+
+	policy.UntrustedAction = UntrustedRequestIDPrefix
+	if requestID, accepted := policy.Resolve("inbound", "10.0.0.2", generator); accepted || requestID != "untrusted-inbound" {
+		t.Fatal("Expected an untrusted ID prefixed, got:", requestID, accepted)
+	}
+	// the prefixed ID must still fit MaxLength
+	long := strings.Repeat("a", policy.MaxLength-1)
+	if requestID, accepted := policy.Resolve(long, "10.0.0.2", generator); accepted || requestID != "svc:generated" {
+		t.Fatal("Expected an ID too long once prefixed replaced, got:", requestID, accepted)
+	}
+	fits := strings.Repeat("a", policy.MaxLength-len(policy.UntrustedPrefix))
+	if requestID, _ := policy.Resolve(fits, "10.0.0.2", generator); requestID != policy.UntrustedPrefix+fits {
+		t.Fatal("Expected a prefixed ID of MaxLength kept, got:", requestID)
+	}
+}
+
+func TestRequestIDPolicyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy := DefaultRequestIDPolicy()
+	policy.TrustedProxies = []string{"10.0.0.1"}
+	policy.UntrustedAction = UntrustedRequestIDPrefix
+
+	middleware, err := RequestIDTool{}.MiddlewareWithPolicy(fixedRequestIDGenerator("svc:generated"), policy)
+	if err != nil {
+		t.Fatal("MiddlewareWithPolicy failed:", err)
+	}
+	// the middleware uses a compiled copy of the policy
+	policy.TrustedProxies[0] = "10.0.0.2"
+
+	engine := gin.New()
+	engine.GET("/", middleware, func(c *gin.Context) {
+		original, _ := GetOriginalRequestID(c)
+		c.String(http.StatusOK, original)
+	})
+
+	for _, tc := range []struct {
+		remoteAddr string
+		inbound    string
+		requestID  string
+		original   string
+	}{
+		{"10.0.0.1:1234", "inbound", "inbound", ""},
+		{"10.0.0.2:1234", "inbound", "untrusted-inbound", "inbound"},
+		{"10.0.0.1:1234", "in bound", "svc:generated", "in bound"},
+		{"10.0.0.2:1234", "", "svc:generated", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.inbound != "" {
+			req.Header.Set(RequestIDHeaderKey, tc.inbound)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if requestID := w.Header().Get(RequestIDHeaderKey); requestID != tc.requestID {
+			t.Fatal("Expected request id", tc.requestID, "for", tc.inbound, "from", tc.remoteAddr, "got:", requestID)
+		}
+		if w.Body.String() != tc.original {
+			t.Fatal("Expected original request id", tc.original, "got:", w.Body.String())
+		}
+	}
+
+	policy.UntrustedAction = "drop"
+	if _, err := (RequestIDTool{}).MiddlewareWithPolicy(fixedRequestIDGenerator("svc:generated"), policy); err == nil {
+		t.Fatal("Expected an error for an invalid policy")
+	}
+}
@@ -1,6 +1,7 @@
 package gin_tool
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,12 +14,13 @@ const RequestIDHeaderKey = "X-Request-ID"
 
 type RequestIDTool struct{}
 
-func (t RequestIDTool) Middleware(serviceName string) gin.HandlerFunc {
+// Middleware trusts any inbound request ID, use MiddlewareWithPolicy to validate it.
+func (t RequestIDTool) Middleware(generator IRequestIDGenerator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Ensure the request ID is set in the context
 		requestID, exists := GetRequestID(c)
 		if !exists || requestID == "" {
-			requestID = GenerateRequestID(serviceName)
+			requestID = generator.Generate()
 			c.Request.Header.Set(RequestIDHeaderKey, requestID)
 		}
 		// Set the request ID in the response header
@@ -26,9 +28,37 @@ func (t RequestIDTool) Middleware(serviceName string) gin.HandlerFunc {
 	}
 }
 
-func (t RequestIDTool) Handler(serviceName string) gin.HandlerFunc {
+// MiddlewareWithPolicy validates the inbound request ID with the policy,
+// a nil policy means DefaultRequestIDPolicy. It returns an error for an invalid policy.
+func (t RequestIDTool) MiddlewareWithPolicy(
+	generator IRequestIDGenerator, policy *RequestIDPolicy,
+) (gin.HandlerFunc, error) {
+	if policy == nil {
+		policy = DefaultRequestIDPolicy()
+	}
+	policy, err := policy.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid request id policy: %w", err)
+	}
+
+	return func(c *gin.Context) {
+		requestID, exists := GetRequestID(c)
+		if !exists || requestID == "" {
+			requestID = generator.Generate()
+		} else if resolved, accepted := policy.Resolve(requestID, c.RemoteIP(), generator); !accepted {
+			// record the original one before replacing it
+			SetOriginalRequestID(c, requestID)
+			requestID = resolved
+		}
+		c.Request.Header.Set(RequestIDHeaderKey, requestID)
+		// Set the request ID in the response header
+		c.Writer.Header().Set(RequestIDHeaderKey, requestID)
+	}, nil
+}
+
+func (t RequestIDTool) Handler(generator IRequestIDGenerator) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		requestID := GenerateRequestID(serviceName)
+		requestID := generator.Generate()
 		c.JSON(http.StatusOK, SuccessResponse(&requestID))
 	}
 }
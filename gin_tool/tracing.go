@@ -0,0 +1,377 @@
+package gin_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+
+	SpanStatusUnset = 0
+	SpanStatusOk    = 1
+	SpanStatusError = 2
+)
+
+const spanContextKey contextKey = "span"
+
+/*
+Tracing:
+    1. Tracer creates spans and hands ended spans to an ISpanExporter in batches.
+    2. TracingTool.Middleware creates a root span per request, the parent is taken
+        from the inbound traceparent header, and stores it in c.Request.Context().
+    3. A span is a child of the span in ctx, or of the remote trace context in ctx.
+        A trace context started locally (e.g. by PropagationTool without an inbound traceparent)
+        only gives the trace ID, its span is never exported.
+    4. StartSpan creates a child span of the span in ctx, with the same tracer.
+        If ctx has no span, a non-recording span is returned, so StartSpan is always safe to call.
+    5. TraceContextFromContext returns the current span, so PropagationTransport
+        links outbound requests to it.
+    6. Shutdown stops the tracer once every queued span is exported,
+        spans ended after Shutdown are dropped.
+*/
+// SpanEvent is a timestamped annotation of a span.
+type SpanEvent struct {
+	Name       string         `json:"name"`
+	Time       time.Time      `json:"time"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// Span is a named, timed operation of a trace.
+type Span struct {
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	Name          string         `json:"name"`
+	Kind          int            `json:"kind"`
+	StartTime     time.Time      `json:"start_time"`
+	EndTime       time.Time      `json:"end_time"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	Events        []SpanEvent    `json:"events,omitempty"`
+	Status        int            `json:"status"`
+	StatusMessage string         `json:"status_message,omitempty"`
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+func (s *Span) IsRecording() bool {
+	return s != nil && s.tracer != nil
+}
+
+func (s *Span) TraceContext() TraceContext {
+	return TraceContext{TraceID: s.TraceID, SpanID: s.SpanID, Sampled: s.IsRecording()}
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = map[string]any{}
+	}
+	s.Attributes[key] = value
+}
+
+func (s *Span) AddEvent(name string, attributes map[string]any) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.Events = append(s.Events, SpanEvent{Name: name, Time: time.Now(), Attributes: attributes})
+}
+
+// SetError marks the span as failed, a nil error is ignored.
+func (s *Span) SetError(err error) {
+	if !s.IsRecording() || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.Status = SpanStatusError
+	s.StatusMessage = err.Error()
+}
+
+func (s *Span) SetStatus(status int, message string) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.Status = status
+	s.StatusMessage = message
+}
+
+// End finishes the span and hands it to the exporter, only the first call counts.
+func (s *Span) End() {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// ISpanExporter sends ended spans to a backend.
+type ISpanExporter interface {
+	Export(ctx context.Context, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+// tracerLogger logs the export errors of tracers to gin.DefaultErrorWriter.
+var tracerLogger = log.New(gin.DefaultErrorWriter, "[GIN-tool] ", log.LstdFlags)
+
+// Tracer batches ended spans and exports them in background.
+type Tracer struct {
+	serviceName string
+	exporter    ISpanExporter
+
+	queue     chan *Span
+	flushChan chan chan struct{}
+	done      chan struct{}
+	// stopped is closed by run once the queue is drained after done
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTracer starts a tracer, spans are exported every flushInterval or every batchSize spans.
+// Spans are dropped when more than queueSize spans are waiting to be exported.
+func NewTracer(
+	serviceName string, exporter ISpanExporter,
+	queueSize int, batchSize int, flushInterval time.Duration,
+) *Tracer {
+	t := &Tracer{
+		serviceName: serviceName,
+		exporter:    exporter,
+		queue:       make(chan *Span, queueSize),
+		flushChan:   make(chan chan struct{}),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go t.run(batchSize, flushInterval)
+	return t
+}
+
+func (t *Tracer) ServiceName() string {
+	return t.serviceName
+}
+
+// Start creates a span, its parent is the span or trace context in ctx.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	span := &Span{
+		SpanID:    NewSpanID(),
+		Name:      name,
+		Kind:      kind,
+		StartTime: time.Now(),
+		tracer:    t,
+	}
+	if parent, ok := SpanFromContext(ctx); ok {
+		span.TraceID, span.ParentSpanID = parent.TraceID, parent.SpanID
+	} else if traceContext, ok := TraceContextFromContext(ctx); ok {
+		span.TraceID = traceContext.TraceID
+		if traceContext.Remote {
+			span.ParentSpanID = traceContext.SpanID
+		}
+	} else {
+		span.TraceID = NewTraceID()
+	}
+
+	ctx = context.WithValue(ctx, spanContextKey, span)
+	ctx = WithTraceContext(ctx, span.TraceContext())
+	return ctx, span
+}
+
+// Flush exports all queued spans.
+func (t *Tracer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case t.flushChan <- flushed:
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops the tracer, exports the queued spans and shuts down the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.closeOnce.Do(func() { close(t.done) })
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	select {
+	case t.queue <- span:
+	default:
+		// queue is full, drop the span
+	}
+}
+
+func (t *Tracer) run(batchSize int, flushInterval time.Duration) {
+	defer close(t.stopped)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushInterval)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			tracerLogger.Printf("Tracer: export %d spans failed: %v", len(batch), err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case span := <-t.queue:
+				batch = append(batch, span)
+				if len(batch) >= batchSize {
+					export()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flushChan:
+			drain()
+			export()
+			close(flushed)
+		case <-t.done:
+			drain()
+			export()
+			return
+		}
+	}
+}
+
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanContextKey).(*Span)
+	return span, ok && span != nil
+}
+
+// StartSpan creates a child span of the span in ctx.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent, ok := SpanFromContext(ctx)
+	if !ok || !parent.IsRecording() {
+		return ctx, &Span{Name: name}
+	}
+	return parent.tracer.Start(ctx, name, SpanKindInternal)
+}
+
+// TracedCall runs f in a child span of the span in ctx, such as a BlockCallWithTimeout call,
+// a deadline exceeded is recorded as a timeout event.
+func TracedCall[RT any](
+	ctx context.Context, name string, f func(ctx context.Context) (*RT, error),
+) (*RT, error) {
+	ctx, span := StartSpan(ctx, name)
+	defer span.End()
+
+	result, err := f(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		span.AddEvent("timeout", nil)
+	}
+	span.SetError(err)
+	return result, err
+}
+
+// StartSpanFromGin creates a child span of the request span.
+func StartSpanFromGin(c *gin.Context, name string) (context.Context, *Span) {
+	return StartSpan(c.Request.Context(), name)
+}
+
+type TracingTool struct{}
+
+// Middleware creates a root span per request, it must be registered after RequestIDTool.Middleware.
+func (t TracingTool) Middleware(tracer *Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if traceContext, ok := ParseTraceparent(c.GetHeader(TraceparentHeaderKey)); ok {
+			ctx = WithTraceContext(ctx, traceContext)
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+c.FullPath(), SpanKindServer)
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.route", c.FullPath())
+		span.SetAttribute("http.target", c.Request.URL.String())
+		span.SetAttribute("http.client_ip", c.ClientIP())
+		if requestID, exists := GetRequestID(c); exists {
+			span.SetAttribute("http.request_id", requestID)
+			ctx = WithRequestID(ctx, requestID)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Writer.Header().Set(TraceparentHeaderKey, span.TraceContext().Traceparent())
+
+		// Proceed with the request
+		c.Next()
+
+		span.SetAttribute("http.status_code", c.Writer.Status())
+		if len(c.Errors) > 0 {
+			span.SetStatus(SpanStatusError, c.Errors.String())
+		} else if c.Writer.Status() >= 500 {
+			span.SetStatus(SpanStatusError, fmt.Sprintf("HTTP %d", c.Writer.Status()))
+		} else {
+			span.SetStatus(SpanStatusOk, "")
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,308 @@
+package gin_tool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Check if exporters implement ISpanExporter
+var (
+	_ ISpanExporter = NoopSpanExporter{}
+	_ ISpanExporter = &JSONFileSpanExporter{}
+	_ ISpanExporter = &OTLPHttpSpanExporter{}
+)
+
+// NoopSpanExporter drops all spans.
+type NoopSpanExporter struct{}
+
+func (e NoopSpanExporter) Export(_ context.Context, _ []*Span) error {
+	return nil
+}
+
+func (e NoopSpanExporter) Shutdown(_ context.Context) error {
+	return nil
+}
+
+// JSONFileSpanExporter appends spans to a file, one JSON object per line.
+type JSONFileSpanExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJSONFileSpanExporter(path string) (*JSONFileSpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileSpanExporter{file: file}, nil
+}
+
+func (e *JSONFileSpanExporter) Export(_ context.Context, spans []*Span) error {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.file.Write(buf.Bytes())
+	return err
+}
+
+func (e *JSONFileSpanExporter) Shutdown(_ context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+// OTLPHttpSpanExporter posts spans as OTLP/HTTP JSON (ExportTraceServiceRequest).
+type OTLPHttpSpanExporter struct {
+	// Header is added to every export request, e.g. Authorization.
+	// It must be set before the exporter is used.
+	Header http.Header
+
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+// NewOTLPHttpSpanExporter creates an exporter for an endpoint like http://127.0.0.1:4318/v1/traces.
+func NewOTLPHttpSpanExporter(endpoint string, serviceName string) *OTLPHttpSpanExporter {
+	return &OTLPHttpSpanExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{},
+	}
+}
+
+func (e *OTLPHttpSpanExporter) Export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(EncodeOTLPSpans(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range e.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp export failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *OTLPHttpSpanExporter) Shutdown(_ context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+/*
+OTLP JSON:
+    1. The types below are the subset of the OTLP/HTTP JSON trace format used by the exporter.
+    2. Ids are lower hex strings and times are unix nanoseconds encoded as strings.
+*/
+// OTLPTraceRequest is the OTLP/HTTP JSON export request.
+type OTLPTraceRequest struct {
+	ResourceSpans []OTLPResourceSpans `json:"resourceSpans"`
+}
+
+type OTLPResourceSpans struct {
+	Resource   OTLPResource     `json:"resource"`
+	ScopeSpans []OTLPScopeSpans `json:"scopeSpans"`
+}
+
+type OTLPResource struct {
+	Attributes []OTLPKeyValue `json:"attributes"`
+}
+
+type OTLPScopeSpans struct {
+	Scope OTLPScope  `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+type OTLPScope struct {
+	Name string `json:"name"`
+}
+
+type OTLPSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []OTLPKeyValue `json:"attributes,omitempty"`
+	Events            []OTLPEvent    `json:"events,omitempty"`
+	Status            OTLPStatus     `json:"status"`
+}
+
+type OTLPEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []OTLPKeyValue `json:"attributes,omitempty"`
+}
+
+type OTLPStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type OTLPKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func EncodeOTLPSpans(serviceName string, spans []*Span) *OTLPTraceRequest {
+	otlpSpans := make([]OTLPSpan, 0, len(spans))
+	for _, span := range spans {
+		otlpSpan := OTLPSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes:        encodeOTLPAttributes(span.Attributes),
+			Status:            OTLPStatus{Code: span.Status, Message: span.StatusMessage},
+		}
+		for _, event := range span.Events {
+			otlpSpan.Events = append(otlpSpan.Events, OTLPEvent{
+				TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+				Name:         event.Name,
+				Attributes:   encodeOTLPAttributes(event.Attributes),
+			})
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	return &OTLPTraceRequest{
+		ResourceSpans: []OTLPResourceSpans{{
+			Resource: OTLPResource{
+				Attributes: encodeOTLPAttributes(map[string]any{"service.name": serviceName}),
+			},
+			ScopeSpans: []OTLPScopeSpans{{
+				Scope: OTLPScope{Name: "gin_tool"},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+}
+
+func encodeOTLPAttributes(attributes map[string]any) []OTLPKeyValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+	keyValues := make([]OTLPKeyValue, 0, len(attributes))
+	for key, value := range attributes {
+		var otlpValue map[string]any
+		switch v := value.(type) {
+		case string:
+			otlpValue = map[string]any{"stringValue": v}
+		case bool:
+			otlpValue = map[string]any{"boolValue": v}
+		case int:
+			otlpValue = map[string]any{"intValue": strconv.FormatInt(int64(v), 10)}
+		case int64:
+			otlpValue = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			otlpValue = map[string]any{"doubleValue": v}
+		default:
+			otlpValue = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		keyValues = append(keyValues, OTLPKeyValue{Key: key, Value: otlpValue})
+	}
+	return keyValues
+}
+
+/*
+SpanCollector:
+    1. SpanCollector is a local stand-in of an OTLP collector,
+        it keeps the latest received spans in memory.
+    2. ReceiveHandler accepts OTLP/HTTP JSON, ListHandler returns the received spans.
+*/
+// SpanCollector collects spans posted by OTLPHttpSpanExporter.
+type SpanCollector struct {
+	mu       sync.Mutex
+	capacity int
+	spans    []OTLPSpan
+}
+
+func NewSpanCollector(capacity int) *SpanCollector {
+	return &SpanCollector{capacity: capacity}
+}
+
+func (s *SpanCollector) Collect(req *OTLPTraceRequest) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, resourceSpans := range req.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			s.spans = append(s.spans, scopeSpans.Spans...)
+			count += len(scopeSpans.Spans)
+		}
+	}
+	if len(s.spans) > s.capacity {
+		s.spans = append([]OTLPSpan(nil), s.spans[len(s.spans)-s.capacity:]...)
+	}
+	return count
+}
+
+// Spans returns the received spans, filtered by trace ID if traceID is not empty.
+func (s *SpanCollector) Spans(traceID string) []OTLPSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spans := make([]OTLPSpan, 0, len(s.spans))
+	for _, span := range s.spans {
+		if traceID == "" || span.TraceID == traceID {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func (s *SpanCollector) ReceiveHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := OTLPTraceRequest{}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse[struct{}](
+				http.StatusBadRequest, fmt.Sprintf("Invalid OTLP request: %v", err), nil,
+			))
+			return
+		}
+		count := s.Collect(&req)
+		c.JSON(http.StatusOK, SuccessResponse(&count))
+	}
+}
+
+func (s *SpanCollector) ListHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		spans := s.Spans(c.Query("trace_id"))
+		c.JSON(http.StatusOK, SuccessResponse(&spans))
+	}
+}
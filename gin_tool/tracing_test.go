@@ -0,0 +1,372 @@
+package gin_tool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingSpanExporter keeps the exported spans.
+type recordingSpanExporter struct {
+	mu      sync.Mutex
+	spans   []*Span
+	batches int
+	err     error
+}
+
+func (e *recordingSpanExporter) Export(_ context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	e.batches++
+	return e.err
+}
+
+func (e *recordingSpanExporter) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func (e *recordingSpanExporter) exported() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// newTestTracer creates a tracer which only exports on Flush, shut down when the test ends.
+func newTestTracer(t *testing.T, exporter ISpanExporter) *Tracer {
+	tracer := NewTracer("svc", exporter, 64, 64, time.Hour)
+	t.Cleanup(func() { _ = tracer.Shutdown(context.Background()) })
+	return tracer
+}
+
+func flushTracer(t *testing.T, tracer *Tracer) {
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal("Flush failed:", err)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	t.Run("Span Parent", TestSpanParent)
+	t.Run("Middleware", TestTracingMiddleware)
+	t.Run("Traced Call", TestTracedCall)
+	t.Run("Batching", TestTracerBatching)
+	t.Run("Shutdown", TestTracerShutdown)
+	t.Run("File Exporter", TestJSONFileSpanExporter)
+	t.Run("OTLP Exporter", TestOTLPHttpSpanExporter)
+}
+
+func TestSpanParent(t *testing.T) {
+	tracer := newTestTracer(t, NoopSpanExporter{})
+
+	_, root := tracer.Start(context.Background(), "root", SpanKindServer)
+	if root.TraceID == "" || root.ParentSpanID != "" {
+		t.Fatal("Expected a new trace without parent, got:", root.TraceID, root.ParentSpanID)
+	}
+
+	// a remote trace context is the parent
+	remote, _ := ParseTraceparent(NewTraceContext().Traceparent())
+	_, span := tracer.Start(WithTraceContext(context.Background(), remote), "server", SpanKindServer)
+	if span.TraceID != remote.TraceID || span.ParentSpanID != remote.SpanID {
+		t.Fatal("Expected the remote span as parent, got:", span.TraceID, span.ParentSpanID)
+	}
+
+	// a trace context started locally only gives the trace ID
+	local := NewTraceContext()
+	ctx, span := tracer.Start(WithTraceContext(context.Background(), local), "server", SpanKindServer)
+	if span.TraceID != local.TraceID || span.ParentSpanID != "" {
+		t.Fatal("Expected the local trace without parent, got:", span.TraceID, span.ParentSpanID)
+	}
+
+	// the span in ctx is the parent, and the trace context of ctx
+	_, child := StartSpan(ctx, "child")
+	if child.TraceID != span.TraceID || child.ParentSpanID != span.SpanID || child.Kind != SpanKindInternal {
+		t.Fatal("Expected a child of the span in ctx, got:", child)
+	}
+	if traceContext, _ := TraceContextFromContext(ctx); traceContext.SpanID != span.SpanID || traceContext.Remote {
+		t.Fatal("Expected the trace context of the span in ctx, got:", traceContext)
+	}
+
+	// without a span StartSpan does not record
+	_, noop := StartSpan(context.Background(), "noop")
+	noop.SetAttribute("key", "value")
+	noop.End()
+	if noop.IsRecording() || noop.Attributes != nil {
+		t.Fatal("Expected a non-recording span, got:", noop)
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	exporter := &recordingSpanExporter{}
+	tracer := newTestTracer(t, exporter)
+
+	engine := gin.New()
+	engine.Use(
+		RequestIDTool{}.Middleware(fixedRequestIDGenerator("svc:request")),
+		PropagationTool{}.Middleware(),
+		TracingTool{}.Middleware(tracer),
+	)
+	engine.GET("/items/:id", func(c *gin.Context) {
+		_, span := StartSpanFromGin(c, "load")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+	engine.GET("/fail", func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+
+	serve := func(path string, traceparent string) (*httptest.ResponseRecorder, map[string]*Span) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if traceparent != "" {
+			req.Header.Set(TraceparentHeaderKey, traceparent)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		flushTracer(t, tracer)
+		spans := map[string]*Span{}
+		for _, span := range exporter.exported() {
+			spans[span.Name] = span
+		}
+		exporter.mu.Lock()
+		exporter.spans = nil
+		exporter.mu.Unlock()
+		return w, spans
+	}
+
+	// without an inbound traceparent, the server span is the root of the trace
+	w, spans := serve("/items/1", "")
+	server, load := spans["GET /items/:id"], spans["load"]
+	if server == nil || load == nil {
+		t.Fatal("Expected the server and handler spans, got:", spans)
+	}
+	if server.ParentSpanID != "" || server.Kind != SpanKindServer {
+		t.Fatal("Expected a server span without parent, got:", server.ParentSpanID)
+	}
+	if load.ParentSpanID != server.SpanID || load.TraceID != server.TraceID {
+		t.Fatal("Expected the handler span under the server span, got:", load)
+	}
+	if w.Header().Get(TraceparentHeaderKey) != server.TraceContext().Traceparent() {
+		t.Fatal("Expected the traceparent of the server span in the response, got:", w.Header().Get(TraceparentHeaderKey))
+	}
+	if server.Attributes["http.request_id"] != "svc:request" || server.Attributes["http.status_code"] != http.StatusOK ||
+		server.Status != SpanStatusOk {
+		t.Fatal("Unexpected server span:", server.Attributes, server.Status)
+	}
+
+	// with an inbound traceparent, the server span is a child of the remote span
+	inbound := NewTraceContext()
+	_, spans = serve("/items/1", inbound.Traceparent())
+	server = spans["GET /items/:id"]
+	if server.TraceID != inbound.TraceID || server.ParentSpanID != inbound.SpanID {
+		t.Fatal("Expected the server span under the inbound span, got:", server.TraceID, server.ParentSpanID)
+	}
+
+	_, spans = serve("/fail", "")
+	if server := spans["GET /fail"]; server.Status != SpanStatusError {
+		t.Fatal("Expected a 5xx marked as error, got:", server.Status)
+	}
+}
+
+func TestTracedCall(t *testing.T) {
+	exporter := &recordingSpanExporter{}
+	tracer := newTestTracer(t, exporter)
+	ctx, root := tracer.Start(context.Background(), "root", SpanKindServer)
+
+	value, err := TracedCall(ctx, "ok", func(ctx context.Context) (*int, error) {
+		if span, _ := SpanFromContext(ctx); span.Name != "ok" {
+			t.Error("Expected the call span in the ctx of f, got:", span.Name)
+		}
+		result := 1
+		return &result, nil
+	})
+	if err != nil || *value != 1 {
+		t.Fatal("Unexpected TracedCall result:", value, err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if _, err := TracedCall(timeoutCtx, "slow", func(ctx context.Context) (*int, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected DeadlineExceeded, got:", err)
+	}
+	root.End()
+	flushTracer(t, tracer)
+
+	spans := map[string]*Span{}
+	for _, span := range exporter.exported() {
+		spans[span.Name] = span
+	}
+	if spans["ok"].ParentSpanID != root.SpanID || spans["ok"].Status != SpanStatusUnset {
+		t.Fatal("Unexpected span of a successful call:", spans["ok"])
+	}
+	slow := spans["slow"]
+	if slow.Status != SpanStatusError || len(slow.Events) != 1 || slow.Events[0].Name != "timeout" {
+		t.Fatal("Expected a timeout event and an error on the slow call, got:", slow.Status, slow.Events)
+	}
+}
+
+func TestTracerBatching(t *testing.T) {
+	exporter := &recordingSpanExporter{}
+	tracer := NewTracer("svc", exporter, 64, 4, time.Hour)
+	defer tracer.Shutdown(context.Background())
+
+	for i := 0; i < 10; i++ {
+		_, span := tracer.Start(context.Background(), "span", SpanKindInternal)
+		span.End()
+		// only the first End counts
+		span.End()
+	}
+	flushTracer(t, tracer)
+	exporter.mu.Lock()
+	spans, batches := len(exporter.spans), exporter.batches
+	exporter.mu.Unlock()
+	if spans != 10 || batches != 3 {
+		t.Fatal("Expected 10 spans in batches of 4, 4 and 2, got:", spans, "spans in", batches, "batches")
+	}
+
+	// export errors are logged
+	var logged bytes.Buffer
+	tracerLogger.SetOutput(&logged)
+	defer tracerLogger.SetOutput(gin.DefaultErrorWriter)
+	exporter.mu.Lock()
+	exporter.err = errors.New("backend down")
+	exporter.mu.Unlock()
+	_, span := tracer.Start(context.Background(), "span", SpanKindInternal)
+	span.End()
+	flushTracer(t, tracer)
+	if !bytes.Contains(logged.Bytes(), []byte("backend down")) {
+		t.Fatal("Expected the export error logged, got:", logged.String())
+	}
+}
+
+func TestTracerShutdown(t *testing.T) {
+	exporter := &recordingSpanExporter{}
+	tracer := NewTracer("svc", exporter, 64, 4, time.Hour)
+	for i := 0; i < 10; i++ {
+		_, span := tracer.Start(context.Background(), "span", SpanKindInternal)
+		span.End()
+	}
+	// the queued spans are exported without Flush
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal("Shutdown failed:", err)
+	}
+	if exported := len(exporter.exported()); exported != 10 {
+		t.Fatal("Expected 10 spans exported by Shutdown, got:", exported)
+	}
+
+	// spans ended after Shutdown are dropped
+	_, span := tracer.Start(context.Background(), "late", SpanKindInternal)
+	span.End()
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal("Expected Flush after Shutdown to return, got:", err)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal("Expected a second Shutdown to return, got:", err)
+	}
+	if exported := len(exporter.exported()); exported != 10 {
+		t.Fatal("Expected the span ended after Shutdown dropped, got:", exported)
+	}
+}
+
+func TestJSONFileSpanExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := NewJSONFileSpanExporter(path)
+	if err != nil {
+		t.Fatal("NewJSONFileSpanExporter failed:", err)
+	}
+	tracer := NewTracer("svc", exporter, 64, 64, time.Hour)
+	ctx, root := tracer.Start(context.Background(), "root", SpanKindServer)
+	_, child := StartSpan(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.End()
+	root.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal("Shutdown failed:", err)
+	}
+
+	file, _ := os.Open(path)
+	defer file.Close()
+	var spans []*Span
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		span := &Span{}
+		if err := json.Unmarshal(scanner.Bytes(), span); err != nil {
+			t.Fatal("Expected one JSON span per line, got:", scanner.Text(), err)
+		}
+		spans = append(spans, span)
+	}
+	if len(spans) != 2 || spans[0].Name != "child" || spans[0].ParentSpanID != spans[1].SpanID ||
+		spans[0].Attributes["key"] != "value" {
+		t.Fatal("Unexpected exported spans:", spans)
+	}
+}
+
+func TestOTLPHttpSpanExporter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	collector := NewSpanCollector(2)
+	engine := gin.New()
+	auth := BearerTokenAuthTool{}.Middleware("secret")
+	engine.POST("/v1/traces", auth, collector.ReceiveHandler())
+	engine.GET("/traces", auth, collector.ListHandler())
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	spans := make([]*Span, 3)
+	for i := range spans {
+		spans[i] = &Span{
+			TraceID: NewTraceID(), SpanID: NewSpanID(), Name: "span", Kind: SpanKindServer,
+			StartTime: time.Unix(1700000000, 0), EndTime: time.Unix(1700000001, 0),
+			Attributes: map[string]any{"count": i, "ok": true},
+			Status:     SpanStatusOk,
+		}
+	}
+
+	// the collector is behind auth
+	exporter := NewOTLPHttpSpanExporter(server.URL+"/v1/traces", "svc")
+	if err := exporter.Export(context.Background(), spans); err == nil {
+		t.Fatal("Expected the export rejected without a token")
+	}
+	exporter.Header = http.Header{"Authorization": {"Bearer secret"}}
+	if err := exporter.Export(context.Background(), spans); err != nil {
+		t.Fatal("Export failed:", err)
+	}
+
+	// the collector keeps the latest spans up to its capacity
+	received := collector.Spans("")
+	if len(received) != 2 || received[1].SpanID != spans[2].SpanID {
+		t.Fatal("Expected the 2 latest spans collected, got:", received)
+	}
+	otlpSpan := received[1]
+	if otlpSpan.StartTimeUnixNano != "1700000000000000000" || otlpSpan.Kind != SpanKindServer ||
+		otlpSpan.Status.Code != SpanStatusOk || len(otlpSpan.Attributes) != 2 {
+		t.Fatal("Unexpected OTLP span:", otlpSpan)
+	}
+	if filtered := collector.Spans(spans[1].TraceID); len(filtered) != 1 {
+		t.Fatal("Expected the spans filtered by trace id, got:", filtered)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/traces", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal("Expected the span list behind auth, got:", w.Code)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected the span list with the token, got:", w.Code)
+	}
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/Steve-Lee-CST/go-gin-student-tool/config"
+	"github.com/Steve-Lee-CST/go-gin-student-tool/gin_tool"
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaManager enforces the plans of the quota config, set by InitQuota.
+var QuotaManager *gin_tool.QuotaManager
+
+// InitQuota creates QuotaManager from configFile, usually config.Quota.ConfigFile,
+// it must be called before the routes are registered.
+func InitQuota(configFile string) error {
+	quotaConfig, err := gin_tool.LoadQuotaConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load quota config: %w", err)
+	}
+	resolvers := []gin_tool.QuotaIdentityResolver{
+		gin_tool.APIKeyIdentityResolver(gin_tool.APIKeyHeaderKey, gin_tool.APIKeyHashStore(quotaConfig.APIKeys)),
+	}
+	if config.Quota.JWTSecret != "" {
+		resolvers = append(resolvers, gin_tool.JWTSubjectIdentityResolver([]byte(config.Quota.JWTSecret)))
+	}
+	if config.Quota.IdentityHeader != "" {
+		resolvers = append(resolvers, gin_tool.HeaderIdentityResolver(config.Quota.IdentityHeader))
+	}
+	manager, err := gin_tool.NewQuotaManager(quotaConfig, resolvers...)
+	if err != nil {
+		return fmt.Errorf("failed to create quota manager: %w", err)
+	}
+	manager.HeaderStyle = config.RateLimit.HeaderStyle
+	QuotaManager = manager
+	return nil
+}
+
+func QuotaUsageHandler(c *gin.Context) {
+	QuotaManager.UsageHandler()(c)
+}
+
+func QuotaResetHandler(c *gin.Context) {
+	QuotaManager.ResetHandler()(c)
+}
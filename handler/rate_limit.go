@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/Steve-Lee-CST/go-gin-student-tool/config"
+	"github.com/Steve-Lee-CST/go-gin-student-tool/gin_tool"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+var (
+	// RateLimitPolicies are the rate limit policies loaded from config.RateLimit.PolicyFile.
+	RateLimitPolicies []gin_tool.RateLimitPolicy
+	// RateLimitMiddleware applies RateLimitPolicies, set by InitRateLimit.
+	RateLimitMiddleware gin.HandlerFunc
+)
+
+var (
+	rateLimitFallbacksMutex sync.Mutex
+	// rateLimitFallbacks are the Redis limiters created by rateLimiterFactory.
+	rateLimitFallbacks []*gin_tool.FallbackRateLimiter
+)
+
+// RateLimitShadowReport collects the decisions of shadow rate limits.
+var RateLimitShadowReport = gin_tool.NewRateLimitShadowReport(gin_tool.DefaultRateLimitShadowLogger)
+
+// InitRateLimit loads the policies of policyFile, usually config.RateLimit.PolicyFile,
+// and creates RateLimitMiddleware. It must be called before the routes are registered.
+func InitRateLimit(policyFile string) error {
+	RateLimitPolicies = nil
+	if policyFile != "" {
+		policies, err := gin_tool.LoadRateLimitPolicies(policyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load rate limit policies: %w", err)
+		}
+		RateLimitPolicies = policies
+	}
+
+	middleware, err := gin_tool.RateLimitTool{
+		HeaderStyle:  config.RateLimit.HeaderStyle,
+		FailureMode:  config.RateLimit.FailureMode,
+		Shadow:       config.RateLimit.Shadow,
+		ShadowReport: RateLimitShadowReport,
+	}.NewPolicyMiddleware(RateLimitPolicies, rateLimiterFactory())
+	if err != nil {
+		return fmt.Errorf("invalid rate limit policies in %s: %w", policyFile, err)
+	}
+	RateLimitMiddleware = middleware
+	return nil
+}
+
+// rateLimiterFactory keeps the limits in config.RateLimit.RedisAddr if set,
+// with a local fallback while Redis is unavailable. Only fixed_window limits are kept on Redis,
+// the limits of other algorithms stay in memory, per instance, with a warning.
+func rateLimiterFactory() gin_tool.RateLimiterFactory {
+	if config.RateLimit.RedisAddr == "" {
+		return gin_tool.NewRateLimiter
+	}
+	redisClient := redis.NewClient(&redis.Options{Addr: config.RateLimit.RedisAddr})
+	redisFactory := gin_tool.RedisRateLimiterFactory(redisClient, func(l *gin_tool.FallbackRateLimiter) {
+		rateLimitFallbacksMutex.Lock()
+		defer rateLimitFallbacksMutex.Unlock()
+		rateLimitFallbacks = append(rateLimitFallbacks, l)
+	})
+	return func(algorithm string, rate gin_tool.RateLimitRate) (gin_tool.IRateLimiter, error) {
+		if algorithm == "" || algorithm == gin_tool.RateLimitAlgorithmFixedWindow {
+			return redisFactory(algorithm, rate)
+		}
+		log.Printf("Rate limit %s with algorithm %q is not supported on Redis, it is limited in memory per instance",
+			gin_tool.FormatRateLimitPolicy(rate), algorithm)
+		return gin_tool.NewRateLimiter(algorithm, rate)
+	}
+}
+
+// RateLimitReportAuth protects the shadow report, which holds client identifiers.
+func RateLimitReportAuth(c *gin.Context) {
+	gin_tool.BearerTokenAuthTool{}.Middleware(config.RateLimit.ReportToken)(c)
+}
+
+func RateLimitShadowHandler(c *gin.Context) {
+	RateLimitShadowReport.Handler()(c)
+}
+
+func RateLimitShadowResetHandler(c *gin.Context) {
+	RateLimitShadowReport.ResetHandler()(c)
+}
+
+func RateLimitHealthHandler(c *gin.Context) {
+	rateLimitFallbacksMutex.Lock()
+	limiters := append([]*gin_tool.FallbackRateLimiter{}, rateLimitFallbacks...)
+	rateLimitFallbacksMutex.Unlock()
+	gin_tool.RateLimitHealthHandler(limiters...)(c)
+}
@@ -1,11 +1,41 @@
 package handler
 
 import (
+	"fmt"
+
 	"github.com/Steve-Lee-CST/go-gin-student-tool/config"
 	"github.com/Steve-Lee-CST/go-gin-student-tool/gin_tool"
 	"github.com/gin-gonic/gin"
 )
 
+// RequestIDGenerator is the request ID generator shared by the service, set by InitRequestID.
+var RequestIDGenerator gin_tool.IRequestIDGenerator
+
+// RequestIDMiddleware validates the inbound request ID with the policy of config.RequestID,
+// set by InitRequestID.
+var RequestIDMiddleware gin.HandlerFunc
+
+// InitRequestID creates RequestIDGenerator and RequestIDMiddleware from config.RequestID,
+// it must be called before the routes are registered.
+func InitRequestID() error {
+	generator, err := gin_tool.NewRequestIDGenerator(
+		config.RequestID.Format, config.Base.ServiceName, config.RequestID.SnowflakeNodeID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create request id generator: %w", err)
+	}
+	policy := gin_tool.DefaultRequestIDPolicy()
+	policy.TrustedProxies = config.RequestID.TrustedProxies
+	policy.UntrustedAction = config.RequestID.UntrustedAction
+	middleware, err := gin_tool.RequestIDTool{}.MiddlewareWithPolicy(generator, policy)
+	if err != nil {
+		return fmt.Errorf("failed to create request id middleware: %w", err)
+	}
+	RequestIDGenerator = generator
+	RequestIDMiddleware = middleware
+	return nil
+}
+
 func RequestIDHandler(c *gin.Context) {
-	gin_tool.RequestIDTool{}.Handler(config.Base.ServiceName)(c)
+	gin_tool.RequestIDTool{}.Handler(RequestIDGenerator)(c)
 }
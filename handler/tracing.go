@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Steve-Lee-CST/go-gin-student-tool/config"
+	"github.com/Steve-Lee-CST/go-gin-student-tool/gin_tool"
+	"github.com/Steve-Lee-CST/go-gin-student-tool/micro_service_tool/block_call_with_timeout"
+	"github.com/Steve-Lee-CST/go-gin-student-tool/micro_service_tool/coherency_cache"
+	"github.com/gin-gonic/gin"
+)
+
+// Tracer is the tracer shared by the service, set by InitTracing.
+var Tracer *gin_tool.Tracer
+
+// SpanCollector is the local stand-in of an OTLP collector, nil unless config.Tracing.Collector is set.
+var SpanCollector *gin_tool.SpanCollector
+
+// InitTracing creates Tracer and SpanCollector from config.Tracing,
+// it must be called before the routes are registered.
+func InitTracing() error {
+	var exporter gin_tool.ISpanExporter
+	switch config.Tracing.Exporter {
+	case "", "none":
+		exporter = gin_tool.NoopSpanExporter{}
+	case "file":
+		fileExporter, err := gin_tool.NewJSONFileSpanExporter(config.Tracing.FilePath)
+		if err != nil {
+			return fmt.Errorf("failed to create span file exporter: %w", err)
+		}
+		exporter = fileExporter
+	case "otlp":
+		otlpExporter := gin_tool.NewOTLPHttpSpanExporter(config.Tracing.OTLPEndpoint, config.Base.ServiceName)
+		if config.Tracing.CollectorToken != "" {
+			otlpExporter.Header = http.Header{"Authorization": {"Bearer " + config.Tracing.CollectorToken}}
+		}
+		exporter = otlpExporter
+	default:
+		return fmt.Errorf("unknown span exporter: %q", config.Tracing.Exporter)
+	}
+
+	if config.Tracing.Collector {
+		if config.Tracing.CollectorToken == "" {
+			return errors.New("the span collector requires a collector token")
+		}
+		SpanCollector = gin_tool.NewSpanCollector(1024)
+	}
+	Tracer = gin_tool.NewTracer(
+		config.Base.ServiceName, exporter,
+		config.Tracing.QueueSize, config.Tracing.BatchSize,
+		time.Duration(config.Tracing.FlushInterval)*time.Second,
+	)
+	return nil
+}
+
+// StorageTracer traces the operations of a coherency_cache.TracedStorage
+// as child spans of the span in ctx.
+var StorageTracer = coherency_cache.StorageTracerFunc(func(
+	ctx context.Context, operation coherency_cache.StorageOperation,
+) (context.Context, func(err error)) {
+	ctx, span := gin_tool.StartSpan(ctx, operation.Storage+"."+operation.Operation)
+	span.SetAttribute("storage.name", operation.Storage)
+	span.SetAttribute("storage.operation", operation.Operation)
+	if operation.Key != nil {
+		span.SetAttribute("storage.key", fmt.Sprint(operation.Key))
+	}
+	if operation.TTL > 0 {
+		span.SetAttribute("storage.ttl_ms", operation.TTL.Milliseconds())
+	}
+	return ctx, func(err error) {
+		if operation.Operation == "Get" {
+			span.SetAttribute("storage.hit", err == nil)
+			span.SetAttribute("storage.tombstone", errors.Is(err, coherency_cache.ErrTombstone))
+		}
+		if !coherency_cache.IsNotFound(err) {
+			span.SetError(err)
+		}
+		span.End()
+	}
+})
+
+// CallTracer traces a block_call_with_timeout.TracedBlockCallWithTimeout call
+// as a child span of the span in ctx, a deadline exceeded is recorded as a timeout event.
+var CallTracer block_call_with_timeout.CallTracer = func(
+	ctx context.Context, name string,
+) (context.Context, func(err error)) {
+	ctx, span := gin_tool.StartSpan(ctx, name)
+	return ctx, func(err error) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			span.AddEvent("timeout", nil)
+		}
+		span.SetError(err)
+		span.End()
+	}
+}
+
+// CollectorAuth protects the routes of SpanCollector.
+func CollectorAuth(c *gin.Context) {
+	gin_tool.BearerTokenAuthTool{}.Middleware(config.Tracing.CollectorToken)(c)
+}
+
+func TraceReceiveHandler(c *gin.Context) {
+	SpanCollector.ReceiveHandler()(c)
+}
+
+func TraceListHandler(c *gin.Context) {
+	SpanCollector.ListHandler()(c)
+}
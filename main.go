@@ -1,21 +1,64 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/Steve-Lee-CST/go-gin-student-tool/config"
+	"github.com/Steve-Lee-CST/go-gin-student-tool/handler"
 	"github.com/Steve-Lee-CST/go-gin-student-tool/router"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	if err := handler.InitRequestID(); err != nil {
+		log.Fatal(err)
+	}
+	if err := handler.InitTracing(); err != nil {
+		log.Fatal(err)
+	}
+	if err := handler.InitRateLimit(config.RateLimit.PolicyFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := handler.InitQuota(config.Quota.ConfigFile); err != nil {
+		log.Fatal(err)
+	}
+
 	engine := gin.Default()
 
 	router.RegisterRouter(engine)
 
 	// engine.SetTrustedProxies([]string{"127.0.0.1"})
-	engine.Run(fmt.Sprintf(
-		"%s://%s:%s",
-		config.Base.Protocol, config.Base.Domain, config.Base.Port,
-	))
+	server := &http.Server{
+		Addr: fmt.Sprintf(
+			"%s://%s:%s",
+			config.Base.Protocol, config.Base.Domain, config.Base.Port,
+		),
+		Handler: engine,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Shut down on SIGINT / SIGTERM, the queued spans are exported before exit
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Server shutdown failed:", err)
+	}
+	if err := handler.Tracer.Shutdown(ctx); err != nil {
+		log.Println("Tracer shutdown failed:", err)
+	}
 }
@@ -38,3 +38,27 @@ func BlockCallWithTimeout[RT any](
 		return nil, timeoutCtx.Err()
 	}
 }
+
+// CallTracer traces a call of TracedBlockCallWithTimeout, the returned ctx is passed to the call
+// and end is called with the error returned to the caller, so this package does not depend on a tracing library.
+type CallTracer func(ctx context.Context, name string) (_ context.Context, end func(err error))
+
+// TracedBlockCallWithTimeout is BlockCallWithTimeout traced by tracer, a nil tracer does not trace.
+// f gets the ctx of the call, which is done once the timeout is reached.
+func TracedBlockCallWithTimeout[RT any](
+	ctx context.Context, tracer CallTracer, name string,
+	timeout time.Duration, f func(ctx context.Context) (*RT, error),
+) (*RT, error) {
+	end := func(err error) {}
+	if tracer != nil {
+		ctx, end = tracer(ctx, name)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := BlockCallWithTimeout(callCtx, timeout, func() (*RT, error) {
+		return f(callCtx)
+	})
+	end(err)
+	return res, err
+}
@@ -0,0 +1,112 @@
+package coherency_cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+BaseStorage:
+    1. BaseStorage implements the locks of IStorage with one lock per key, in the current process.
+    2. A key lock is counted by its holder and its waiters, and removed once none is left,
+        so the locks of keys no longer used do not stay in memory.
+    3. Lock gives up when ctx is done, a caller never waits past its deadline.
+    4. The zero value is ready to use.
+*/
+// BaseStorage is embedded by storages for per-key locking.
+type BaseStorage[KT any] struct {
+	mu    sync.Mutex
+	locks map[any]*keyLock
+}
+
+type keyLock struct {
+	// sem holds a value while the lock is held
+	sem chan struct{}
+	// refs is the number of holders and waiters
+	refs int
+}
+
+// acquire returns the lock of mapKey and counts the caller in it.
+func (b *BaseStorage[KT]) acquire(mapKey any) *keyLock {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.locks == nil {
+		b.locks = map[any]*keyLock{}
+	}
+	lock, ok := b.locks[mapKey]
+	if !ok {
+		lock = &keyLock{sem: make(chan struct{}, 1)}
+		b.locks[mapKey] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// release uncounts the caller from lock, it must be called with b.mu held.
+func (b *BaseStorage[KT]) release(mapKey any, lock *keyLock) {
+	lock.refs--
+	if lock.refs == 0 {
+		delete(b.locks, mapKey)
+	}
+}
+
+func (b *BaseStorage[KT]) Lock(ctx context.Context, key *KT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	mapKey := any(*key)
+	lock := b.acquire(mapKey)
+	select {
+	case lock.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.release(mapKey, lock)
+		return ctx.Err()
+	}
+}
+
+func (b *BaseStorage[KT]) LockWithTimeout(ctx context.Context, key *KT, timeout time.Duration) error {
+	subCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return b.Lock(subCtx, key)
+}
+
+func (b *BaseStorage[KT]) TryLock(ctx context.Context, key *KT) bool {
+	mapKey := any(*key)
+	lock := b.acquire(mapKey)
+	select {
+	case lock.sem <- struct{}{}:
+		return true
+	default:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.release(mapKey, lock)
+		return false
+	}
+}
+
+func (b *BaseStorage[KT]) Unlock(ctx context.Context, key *KT) {
+	mapKey := any(*key)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lock, ok := b.locks[mapKey]
+	if !ok {
+		return
+	}
+	select {
+	case <-lock.sem:
+		b.release(mapKey, lock)
+	default:
+		// not locked
+	}
+}
+
+// lockCount returns the number of keys with a holder or a waiter.
+func (b *BaseStorage[KT]) lockCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.locks)
+}
@@ -0,0 +1,124 @@
+package coherency_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBaseStorage(t *testing.T) {
+	t.Run("Lock Context", TestLockContext)
+	t.Run("Try Lock", TestTryLock)
+	t.Run("Lock With Timeout", TestLockWithTimeout)
+	t.Run("Lock Cleanup", TestLockCleanup)
+}
+
+func TestLockContext(t *testing.T) {
+	storage := NewMemoryStorage[string, string]()
+	key := "key"
+	if err := storage.Lock(context.Background(), &key); err != nil {
+		t.Fatal("Lock failed:", err)
+	}
+
+	// A waiter gives up when its ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	start := time.Now()
+	if err := storage.Lock(ctx, &key); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected DeadlineExceeded, got:", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*200 {
+		t.Fatal("Lock waited past the deadline:", elapsed)
+	}
+
+	// A cancelled waiter does not take the lock later
+	storage.Unlock(context.Background(), &key)
+	if !storage.TryLock(context.Background(), &key) {
+		t.Fatal("Expected lock free after Unlock")
+	}
+	storage.Unlock(context.Background(), &key)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := storage.Lock(cancelled, &key); !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected Canceled for a done ctx, got:", err)
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	storage := NewMemoryStorage[string, string]()
+	key, otherKey := "key", "other"
+	if !storage.TryLock(context.Background(), &key) {
+		t.Fatal("Expected TryLock of a free key to succeed")
+	}
+	if storage.TryLock(context.Background(), &key) {
+		t.Fatal("Expected TryLock of a held key to fail")
+	}
+	if !storage.TryLock(context.Background(), &otherKey) {
+		t.Fatal("Expected TryLock of another key to succeed")
+	}
+	storage.Unlock(context.Background(), &key)
+	storage.Unlock(context.Background(), &otherKey)
+	if !storage.TryLock(context.Background(), &key) {
+		t.Fatal("Expected TryLock after Unlock to succeed")
+	}
+	storage.Unlock(context.Background(), &key)
+}
+
+func TestLockWithTimeout(t *testing.T) {
+	storage := NewCoherencyStorage[string, string](
+		NewMemoryStorage[string, string](), NewMemoryStorage[string, string](),
+	)
+	key := "key"
+	_ = storage.Lock(context.Background(), &key)
+
+	if err := storage.LockWithTimeout(context.Background(), &key, time.Millisecond*20); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected DeadlineExceeded, got:", err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		storage.Unlock(context.Background(), &key)
+	}()
+	if err := storage.LockWithTimeout(context.Background(), &key, time.Second); err != nil {
+		t.Fatal("Expected lock after Unlock, got:", err)
+	}
+	storage.Unlock(context.Background(), &key)
+}
+
+func TestLockCleanup(t *testing.T) {
+	cache := NewMemoryStorage[string, string]()
+	source := NewMemoryStorage[string, string]()
+	storage := NewCoherencyStorage[string, string](cache, source)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key, value := fmt.Sprintf("key_%d", i%10), "value"
+			_ = storage.Set(context.Background(), &key, &value, time.Second)
+			_, _ = storage.Get(context.Background(), &key, time.Second)
+			_ = storage.Del(context.Background(), &key, time.Second)
+			_, _ = storage.Get(context.Background(), &key, time.Second)
+		}(i)
+	}
+	wg.Wait()
+
+	// Locks of keys no longer used are removed
+	if count := cache.lockCount() + source.lockCount(); count != 0 {
+		t.Fatal("Expected no lock left, got:", count)
+	}
+
+	// Including those of timed out waiters
+	key := "key"
+	_ = cache.Lock(context.Background(), &key)
+	_ = cache.LockWithTimeout(context.Background(), &key, time.Millisecond)
+	cache.Unlock(context.Background(), &key)
+	if count := cache.lockCount(); count != 0 {
+		t.Fatal("Expected no lock left after a timed out waiter, got:", count)
+	}
+}
@@ -0,0 +1,367 @@
+package coherency_cache
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// EvictionReasonCapacity is an entry evicted to stay within MaxEntries or MaxBytes.
+	EvictionReasonCapacity = "capacity"
+	// EvictionReasonExpired is an entry dropped after its TTL.
+	EvictionReasonExpired = "expired"
+)
+
+var (
+	_ IStorage[any, any]          = (*BoundedMemoryStorage[any, any])(nil)
+	_ ITTLStorage[any, any]       = (*BoundedMemoryStorage[any, any])(nil)
+	_ ITombstoneStorage[any, any] = (*BoundedMemoryStorage[any, any])(nil)
+)
+
+/*
+BoundedMemoryStorage:
+    1. BoundedMemoryStorage is a MemoryStorage with capacity limits,
+        by entry count (MaxEntries) and by approximate size in bytes (MaxBytes), 0 means no limit.
+    2. When a Set goes over a limit, entries are evicted in the order of the IEvictionPolicy,
+        a value larger than MaxBytes is not stored at all.
+    3. Sizer computes the size of a value, nil means ApproximateSize.
+    4. OnEvict is called outside the lock for every entry evicted for capacity or expiry,
+        not for Del or for an overwritten value.
+        An overwrite updates the entry in place and counts as an access of the policy,
+        so lfu frequencies and arc history are kept.
+    5. TTLs and tombstones work like MemoryStorage, expired entries are dropped on Get and on Set.
+        Tombstones count for capacity, but OnEvict is not called for them.
+*/
+// BoundedMemoryConfig configures a BoundedMemoryStorage.
+type BoundedMemoryConfig[KT any, VT any] struct {
+	MaxEntries int
+	MaxBytes   int64
+	// Policy is one of EvictionPolicy*, empty means lru.
+	Policy string
+	// EvictionPolicy is a custom policy, it takes precedence over Policy
+	// and must not be shared between storages.
+	EvictionPolicy IEvictionPolicy
+	// PolicyCapacity is the expected number of entries of arc, 0 means MaxEntries,
+	// or the largest number of entries seen when MaxEntries is 0.
+	PolicyCapacity int
+	DefaultTTL     time.Duration
+	Sizer          func(key *KT, value *VT) int64
+	OnEvict        func(key KT, value *VT, reason string)
+}
+
+// BoundedMemoryStorage is an in-memory IStorage with capacity limits and eviction.
+type BoundedMemoryStorage[KT any, VT any] struct {
+	BaseStorage[KT]
+
+	config BoundedMemoryConfig[KT, VT]
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[any]*boundedEntry[KT, VT]
+	policy  IEvictionPolicy
+	bytes   int64
+}
+
+type boundedEntry[KT any, VT any] struct {
+	key       KT
+	value     *VT
+	size      int64
+	expireAt  time.Time
+	tombstone bool
+}
+
+func (e *boundedEntry[KT, VT]) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+type boundedEviction[KT any, VT any] struct {
+	entry  *boundedEntry[KT, VT]
+	reason string
+}
+
+func NewBoundedMemoryStorage[KT any, VT any](
+	config BoundedMemoryConfig[KT, VT],
+) (*BoundedMemoryStorage[KT, VT], error) {
+	policy := config.EvictionPolicy
+	if policy == nil {
+		capacity := config.PolicyCapacity
+		if capacity <= 0 {
+			capacity = config.MaxEntries
+		}
+		var err error
+		if policy, err = NewEvictionPolicy(config.Policy, capacity); err != nil {
+			return nil, err
+		}
+	}
+	if config.Sizer == nil {
+		config.Sizer = func(key *KT, value *VT) int64 {
+			return ApproximateSize(key) + ApproximateSize(value)
+		}
+	}
+	return &BoundedMemoryStorage[KT, VT]{
+		config:  config,
+		now:     time.Now,
+		entries: map[any]*boundedEntry[KT, VT]{},
+		policy:  policy,
+	}, nil
+}
+
+// Len returns the number of entries, including expired entries not dropped yet.
+func (b *BoundedMemoryStorage[KT, VT]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// Bytes returns the approximate size of the entries.
+func (b *BoundedMemoryStorage[KT, VT]) Bytes() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bytes
+}
+
+func (b *BoundedMemoryStorage[KT, VT]) Get(
+	ctx context.Context, key *KT, timeout time.Duration,
+) (*VT, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var evicted []boundedEviction[KT, VT]
+	defer func() { b.notify(evicted) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.entries[any(*key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if entry.expired(b.now()) {
+		b.remove(any(*key), entry)
+		evicted = append(evicted, boundedEviction[KT, VT]{entry: entry, reason: EvictionReasonExpired})
+		return nil, ErrNotFound
+	}
+	b.policy.Access(any(*key))
+	if entry.tombstone {
+		return nil, ErrTombstone
+	}
+	return entry.value, nil
+}
+
+func (b *BoundedMemoryStorage[KT, VT]) Set(
+	ctx context.Context, key *KT, value *VT, timeout time.Duration,
+) error {
+	return b.SetWithTTL(ctx, key, value, b.config.DefaultTTL, timeout)
+}
+
+func (b *BoundedMemoryStorage[KT, VT]) SetWithTTL(
+	ctx context.Context, key *KT, value *VT, ttl time.Duration, timeout time.Duration,
+) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	return b.set(key, &boundedEntry[KT, VT]{
+		key:   *key,
+		value: value,
+		size:  b.config.Sizer(key, value),
+	}, ttl)
+}
+
+func (b *BoundedMemoryStorage[KT, VT]) SetTombstone(
+	ctx context.Context, key *KT, ttl time.Duration, timeout time.Duration,
+) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	return b.set(key, &boundedEntry[KT, VT]{
+		key:       *key,
+		size:      ApproximateSize(key),
+		tombstone: true,
+	}, ttl)
+}
+
+func (b *BoundedMemoryStorage[KT, VT]) set(key *KT, entry *boundedEntry[KT, VT], ttl time.Duration) error {
+	if ttl > 0 {
+		entry.expireAt = b.now().Add(ttl)
+	}
+
+	var evicted []boundedEviction[KT, VT]
+	defer func() { b.notify(evicted) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	mapKey := any(*key)
+	old, overwrite := b.entries[mapKey]
+	if overwrite && old.expired(b.now()) {
+		b.remove(mapKey, old)
+		overwrite = false
+	}
+	if b.config.MaxBytes > 0 && entry.size > b.config.MaxBytes {
+		if overwrite {
+			b.remove(mapKey, old)
+		}
+		evicted = append(evicted, boundedEviction[KT, VT]{entry: entry, reason: EvictionReasonCapacity})
+		return nil
+	}
+	// an overwrite keeps the key in the policy, only the size changes
+	added, size := 1, entry.size
+	if overwrite {
+		added, size = 0, entry.size-old.size
+		b.policy.Access(mapKey)
+	}
+	// room is made before adding, so a new entry is never the victim;
+	// expired entries go first, then the victims of the policy
+	if b.overCapacity(added, size) {
+		now := b.now()
+		for k, e := range b.entries {
+			if e.expired(now) {
+				b.remove(k, e)
+				evicted = append(evicted, boundedEviction[KT, VT]{entry: e, reason: EvictionReasonExpired})
+			}
+		}
+	}
+	for b.overCapacity(added, size) {
+		victim, ok := b.policy.Victim()
+		if !ok {
+			break
+		}
+		victimEntry := b.entries[victim]
+		delete(b.entries, victim)
+		b.bytes -= victimEntry.size
+		if victim == mapKey {
+			// the policy chose the overwritten entry, the new value is added back as a new key
+			overwrite = false
+			added, size = 1, entry.size
+			continue
+		}
+		evicted = append(evicted, boundedEviction[KT, VT]{entry: victimEntry, reason: EvictionReasonCapacity})
+	}
+
+	b.entries[mapKey] = entry
+	b.bytes += size
+	if !overwrite {
+		b.policy.Add(mapKey)
+	}
+	return nil
+}
+
+func (b *BoundedMemoryStorage[KT, VT]) Del(
+	ctx context.Context, key *KT, timeout time.Duration,
+) error {
+	b.mu.Lock()
+	if entry, ok := b.entries[any(*key)]; ok {
+		b.remove(any(*key), entry)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	return nil
+}
+
+func (b *BoundedMemoryStorage[KT, VT]) Timeout() time.Duration {
+	return time.Millisecond * 100
+}
+
+// remove must be called with b.mu held.
+func (b *BoundedMemoryStorage[KT, VT]) remove(mapKey any, entry *boundedEntry[KT, VT]) {
+	delete(b.entries, mapKey)
+	b.bytes -= entry.size
+	b.policy.Remove(mapKey)
+}
+
+// overCapacity returns whether added entries growing the storage by size do not fit,
+// it must be called with b.mu held.
+func (b *BoundedMemoryStorage[KT, VT]) overCapacity(added int, size int64) bool {
+	return (b.config.MaxEntries > 0 && len(b.entries)+added > b.config.MaxEntries) ||
+		(b.config.MaxBytes > 0 && b.bytes+size > b.config.MaxBytes)
+}
+
+func (b *BoundedMemoryStorage[KT, VT]) notify(evicted []boundedEviction[KT, VT]) {
+	if b.config.OnEvict == nil {
+		return
+	}
+	for _, e := range evicted {
+		if !e.entry.tombstone {
+			b.config.OnEvict(e.entry.key, e.entry.value, e.reason)
+		}
+	}
+}
+
+// ApproximateSize estimates the memory of v in bytes, following pointers, slices, maps and strings.
+// Shared and cyclic references are counted once.
+func ApproximateSize(v any) int64 {
+	return approximateSize(reflect.ValueOf(v), map[uintptr]bool{})
+}
+
+func approximateSize(v reflect.Value, seen map[uintptr]bool) int64 {
+	if !v.IsValid() {
+		return 0
+	}
+	size := int64(v.Type().Size())
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() || seen[v.Pointer()] {
+			return size
+		}
+		seen[v.Pointer()] = true
+		return size + approximateSize(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return size
+		}
+		return size + approximateSize(v.Elem(), seen)
+	case reflect.String:
+		return size + int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || seen[v.Pointer()] {
+			return size
+		}
+		seen[v.Pointer()] = true
+		size += int64(v.Cap()-v.Len()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += approximateSize(v.Index(i), seen)
+		}
+		return size
+	case reflect.Array:
+		size = 0
+		for i := 0; i < v.Len(); i++ {
+			size += approximateSize(v.Index(i), seen)
+		}
+		return size
+	case reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return size
+		}
+		seen[v.Pointer()] = true
+		iter := v.MapRange()
+		for iter.Next() {
+			size += approximateSize(iter.Key(), seen) + approximateSize(iter.Value(), seen)
+		}
+		return size
+	case reflect.Struct:
+		size = 0
+		for i := 0; i < v.NumField(); i++ {
+			size += approximateSize(v.Field(i), seen)
+		}
+		// padding between fields
+		return max(size, int64(v.Type().Size()))
+	default:
+		return size
+	}
+}
@@ -0,0 +1,292 @@
+package coherency_cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBoundedMemoryStorage(t *testing.T) {
+	t.Run("LRU", TestLRUEviction)
+	t.Run("LFU", TestLFUEviction)
+	t.Run("ARC", TestARCEviction)
+	t.Run("ARC Capacity", TestARCEvictionCapacity)
+	t.Run("Overwrite", TestBoundedMemoryStorageOverwrite)
+	t.Run("Custom Policy", TestBoundedMemoryStorageCustomPolicy)
+	t.Run("Max Bytes", TestBoundedMemoryStorageMaxBytes)
+	t.Run("Expired", TestBoundedMemoryStorageExpired)
+	t.Run("L1 Layer", TestBoundedMemoryStorageAsCache)
+}
+
+func newBoundedStorage(t *testing.T, config BoundedMemoryConfig[string, int]) *BoundedMemoryStorage[string, int] {
+	storage, err := NewBoundedMemoryStorage(config)
+	if err != nil {
+		t.Fatal("NewBoundedMemoryStorage failed:", err)
+	}
+	return storage
+}
+
+func setKeys(t *testing.T, storage IStorage[string, int], keys ...string) {
+	for i, key := range keys {
+		value := i
+		if err := storage.Set(context.Background(), &key, &value, storage.Timeout()); err != nil {
+			t.Fatal("Set failed:", err)
+		}
+	}
+}
+
+func getKeys(storage IStorage[string, int], keys ...string) {
+	for _, key := range keys {
+		_, _ = storage.Get(context.Background(), &key, storage.Timeout())
+	}
+}
+
+func hasKey(storage IStorage[string, int], key string) bool {
+	value, _ := storage.Get(context.Background(), &key, storage.Timeout())
+	return value != nil
+}
+
+func TestLRUEviction(t *testing.T) {
+	evicted := []string{}
+	storage := newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxEntries: 3,
+		Policy:     EvictionPolicyLRU,
+		OnEvict: func(key string, value *int, reason string) {
+			evicted = append(evicted, key+":"+reason)
+		},
+	})
+
+	setKeys(t, storage, "a", "b", "c")
+	getKeys(storage, "a")
+	setKeys(t, storage, "d")
+
+	if storage.Len() != 3 {
+		t.Fatal("Expected 3 entries, got:", storage.Len())
+	}
+	if hasKey(storage, "b") || !hasKey(storage, "a") {
+		t.Fatal("Expected b to be evicted instead of a")
+	}
+	if len(evicted) != 1 || evicted[0] != "b:"+EvictionReasonCapacity {
+		t.Fatal("Unexpected evictions:", evicted)
+	}
+}
+
+func TestLFUEviction(t *testing.T) {
+	storage := newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxEntries: 3,
+		Policy:     EvictionPolicyLFU,
+	})
+
+	setKeys(t, storage, "a", "b", "c")
+	getKeys(storage, "a", "a", "b", "c", "c")
+	// b is the least frequently used
+	setKeys(t, storage, "d")
+	if hasKey(storage, "b") {
+		t.Fatal("Expected b to be evicted")
+	}
+	// d is used once, the least recently among a, c, d
+	setKeys(t, storage, "e")
+	if hasKey(storage, "d") || !hasKey(storage, "a") || !hasKey(storage, "c") {
+		t.Fatal("Expected d to be evicted")
+	}
+}
+
+func TestARCEviction(t *testing.T) {
+	storage := newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxEntries: 4,
+		Policy:     EvictionPolicyARC,
+	})
+
+	// hot keys are used twice, then a scan of keys used once
+	setKeys(t, storage, "hot1", "hot2")
+	getKeys(storage, "hot1", "hot2")
+	for i := 0; i < 20; i++ {
+		setKeys(t, storage, fmt.Sprintf("scan%d", i))
+	}
+
+	if !hasKey(storage, "hot1") || !hasKey(storage, "hot2") {
+		t.Fatal("Expected hot keys to survive the scan")
+	}
+	if storage.Len() != 4 {
+		t.Fatal("Expected 4 entries, got:", storage.Len())
+	}
+}
+
+func TestARCEvictionCapacity(t *testing.T) {
+	if policy := NewARCEvictionPolicy(0); !policy.derived || policy.capacity != 1 {
+		t.Fatal("Expected a derived capacity, got:", policy.capacity)
+	}
+	// bounded only by bytes, the capacity follows the resident keys
+	storage := newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxBytes: 120,
+		Policy:   EvictionPolicyARC,
+		Sizer: func(key *string, value *int) int64 {
+			return 30
+		},
+	})
+	for i := 0; i < 10; i++ {
+		setKeys(t, storage, fmt.Sprintf("key%d", i))
+	}
+	if capacity := storage.policy.(*ARCEvictionPolicy).capacity; capacity != 4 {
+		t.Fatal("Expected a capacity of 4 entries, got:", capacity)
+	}
+
+	storage = newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxBytes:       120,
+		Policy:         EvictionPolicyARC,
+		PolicyCapacity: 16,
+	})
+	if policy := storage.policy.(*ARCEvictionPolicy); policy.derived || policy.capacity != 16 {
+		t.Fatal("Expected the configured capacity, got:", policy.capacity)
+	}
+}
+
+func TestBoundedMemoryStorageOverwrite(t *testing.T) {
+	storage := newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxEntries: 2,
+		Policy:     EvictionPolicyLFU,
+	})
+
+	// the overwrites of hot count as uses, cold is the least frequently used
+	setKeys(t, storage, "hot", "hot", "hot", "cold")
+	setKeys(t, storage, "new")
+	if !hasKey(storage, "hot") || hasKey(storage, "cold") {
+		t.Fatal("Expected the overwritten key to keep its frequency")
+	}
+
+	sizes := map[int]int64{0: 10, 1: 50}
+	storage = newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxBytes: 100,
+		Sizer: func(key *string, value *int) int64 {
+			return sizes[*value]
+		},
+	})
+	setKeys(t, storage, "a", "b")
+	if storage.Bytes() != 60 {
+		t.Fatal("Expected 60 bytes, got:", storage.Bytes())
+	}
+	// a grows from 10 to 50 bytes, which evicts b and not the overwritten key
+	setKeys(t, storage, "x", "a")
+	if storage.Len() != 2 || storage.Bytes() != 60 || !hasKey(storage, "a") || hasKey(storage, "b") {
+		t.Fatal("Expected a of 50 bytes next to x, got:", storage.Len(), storage.Bytes())
+	}
+}
+
+// recordingEvictionPolicy is an lru policy recording the calls of the storage.
+type recordingEvictionPolicy struct {
+	*LRUEvictionPolicy
+	calls []string
+}
+
+func (p *recordingEvictionPolicy) Add(key any) {
+	p.calls = append(p.calls, fmt.Sprint("add:", key))
+	p.LRUEvictionPolicy.Add(key)
+}
+
+func (p *recordingEvictionPolicy) Access(key any) {
+	p.calls = append(p.calls, fmt.Sprint("access:", key))
+	p.LRUEvictionPolicy.Access(key)
+}
+
+func TestBoundedMemoryStorageCustomPolicy(t *testing.T) {
+	policy := &recordingEvictionPolicy{LRUEvictionPolicy: NewLRUEvictionPolicy()}
+	storage := newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxEntries:     1,
+		Policy:         "unknown",
+		EvictionPolicy: policy,
+	})
+
+	setKeys(t, storage, "a", "a", "b")
+	if fmt.Sprint(policy.calls) != "[add:a access:a add:b]" {
+		t.Fatal("Expected the custom policy to be used, got:", policy.calls)
+	}
+	if hasKey(storage, "a") || !hasKey(storage, "b") {
+		t.Fatal("Expected a evicted by the custom policy")
+	}
+}
+
+func TestBoundedMemoryStorageMaxBytes(t *testing.T) {
+	evicted := 0
+	storage := newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxBytes: 100,
+		Sizer: func(key *string, value *int) int64 {
+			return 30
+		},
+		OnEvict: func(string, *int, string) {
+			evicted++
+		},
+	})
+
+	setKeys(t, storage, "a", "b", "c", "d")
+	if storage.Len() != 3 || storage.Bytes() != 90 || evicted != 1 {
+		t.Fatal("Expected 3 entries of 90 bytes, got:", storage.Len(), storage.Bytes(), evicted)
+	}
+
+	// Overwriting a key does not count twice
+	setKeys(t, storage, "d")
+	if storage.Bytes() != 90 {
+		t.Fatal("Expected 90 bytes after overwrite, got:", storage.Bytes())
+	}
+
+	// Del frees the bytes
+	key := "d"
+	_ = storage.Del(context.Background(), &key, storage.Timeout())
+	if storage.Bytes() != 60 {
+		t.Fatal("Expected 60 bytes after Del, got:", storage.Bytes())
+	}
+
+	if size := ApproximateSize(&struct {
+		Name  string
+		Items []int
+	}{Name: "0123456789", Items: []int{1, 2, 3}}); size < 10+3*8 {
+		t.Fatal("ApproximateSize too small:", size)
+	}
+}
+
+func TestBoundedMemoryStorageExpired(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	reasons := map[string]string{}
+	storage := newBoundedStorage(t, BoundedMemoryConfig[string, int]{
+		MaxEntries: 2,
+		DefaultTTL: time.Second,
+		OnEvict: func(key string, value *int, reason string) {
+			reasons[key] = reason
+		},
+	})
+	storage.now = clock.Now
+
+	setKeys(t, storage, "a")
+	clock.Advance(time.Second)
+	setKeys(t, storage, "b")
+	getKeys(storage, "b")
+
+	// a is expired, it is dropped before evicting b
+	setKeys(t, storage, "c")
+	if !hasKey(storage, "b") || reasons["a"] != EvictionReasonExpired {
+		t.Fatal("Expected a to expire, got:", reasons)
+	}
+}
+
+func TestBoundedMemoryStorageAsCache(t *testing.T) {
+	cache := newBoundedStorage(t, BoundedMemoryConfig[string, int]{MaxEntries: 10})
+	source := NewMemoryStorage[string, int]()
+	storage := NewCoherencyStorage[string, int](cache, source)
+
+	for i := 0; i < 100; i++ {
+		key, value := fmt.Sprintf("key%d", i), i
+		if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+			t.Fatal("Set failed:", err)
+		}
+	}
+	if cache.Len() != 10 {
+		t.Fatal("Expected the cache to stay at 10 entries, got:", cache.Len())
+	}
+
+	// Evicted keys are still served by the source
+	key := "key0"
+	value, err := storage.Get(context.Background(), &key, time.Second)
+	if err != nil || value == nil || *value != 0 {
+		t.Fatal("Expected key0 from the source, got:", value, err)
+	}
+}
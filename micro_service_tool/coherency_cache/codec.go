@@ -0,0 +1,90 @@
+package coherency_cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	CodecJSON    = "json"
+	CodecGob     = "gob"
+	CodecMsgpack = "msgpack"
+)
+
+// Check if codecs implement ICodec
+var (
+	_ ICodec = JSONCodec{}
+	_ ICodec = GobCodec{}
+	_ ICodec = MsgpackCodec{}
+)
+
+/*
+ICodec:
+    1. ICodec encodes the values of storages keeping bytes, e.g. RedisStorage.
+    2. Codecs:
+        - json: readable, only exported fields, numbers in interfaces decode as float64.
+        - gob: Go only, keeps the types of registered interface values.
+        - msgpack: compact and fast, readable from other languages.
+    3. An encoded value never starts with a NUL byte followed by more bytes,
+        so storages can use such values as markers, e.g. tombstones.
+*/
+// ICodec encodes and decodes values.
+type ICodec interface {
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte, value any) error
+}
+
+// NewCodec creates a codec by name, empty means json.
+func NewCodec(name string) (ICodec, error) {
+	switch name {
+	case "", CodecJSON:
+		return JSONCodec{}, nil
+	case CodecGob:
+		return GobCodec{}, nil
+	case CodecMsgpack:
+		return MsgpackCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown codec: %q", name)
+	}
+}
+
+// JSONCodec encodes values in JSON.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec) Unmarshal(data []byte, value any) error {
+	return json.Unmarshal(data, value)
+}
+
+// GobCodec encodes values with encoding/gob, each value is a stream with its own type descriptors.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(value any) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, value any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// MsgpackCodec encodes values in MessagePack.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(value any) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, value any) error {
+	return msgpack.Unmarshal(data, value)
+}
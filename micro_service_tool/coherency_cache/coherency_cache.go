@@ -2,59 +2,211 @@ package coherency_cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrNotFound is returned by IStorage.Get when the key has no value.
+	ErrNotFound = errors.New("coherency_cache: not found")
+	// ErrTombstone is returned by ITombstoneStorage.Get for a key known to be missing in the source,
+	// it is an ErrNotFound.
+	ErrTombstone = fmt.Errorf("%w: tombstone", ErrNotFound)
+)
+
 var (
 	_ IStorage[any, any] = (*CoherencyStorage[any, any])(nil)
 )
 
+/*
+IStorage:
+    1. Get returns (nil, ErrNotFound) when the key has no value, check it with IsNotFound.
+        Any other error means the storage failed, not that the key is missing.
+    2. A found value is returned with a nil error, it may be a nil pointer if a nil pointer was set.
+*/
+// IStorage is a layer of a CoherencyStorage.
 type IStorage[KT any, VT any] interface {
 	Get(ctx context.Context, key *KT, timeout time.Duration) (*VT, error)
 	Set(ctx context.Context, key *KT, value *VT, timeout time.Duration) error
 	Del(ctx context.Context, key *KT, timeout time.Duration) error
 
-	Lock(ctx context.Context, key *KT)
+	// Lock waits for the lock of key, it returns the error of ctx if ctx is done first.
+	Lock(ctx context.Context, key *KT) error
+	// LockWithTimeout is Lock waiting at most timeout.
+	LockWithTimeout(ctx context.Context, key *KT, timeout time.Duration) error
+	// TryLock takes the lock of key if it is free, without waiting.
+	TryLock(ctx context.Context, key *KT) bool
 	Unlock(ctx context.Context, key *KT)
 
 	Timeout() time.Duration
 }
 
-type BaseStorage[KT any] struct {
-	lockMap sync.Map
+// IsNotFound reports whether err means the key has no value.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// ITTLStorage is an IStorage whose entries can expire, ttl <= 0 means no expiration.
+type ITTLStorage[KT any, VT any] interface {
+	IStorage[KT, VT]
+
+	SetWithTTL(ctx context.Context, key *KT, value *VT, ttl time.Duration, timeout time.Duration) error
 }
 
-func (b *BaseStorage[KT]) Lock(ctx context.Context, key *KT) {
-	lock, _ := b.lockMap.LoadOrStore(*key, &sync.Mutex{})
-	lock.(*sync.Mutex).Lock()
+/*
+ITombstoneStorage:
+    1. A tombstone records that a key is missing in the source, for negative caching.
+        Get of a tombstone returns ErrTombstone until ttl, Set of the key replaces it.
+    2. CoherencyStorage keeps tombstones in a side MemoryStorage for caches
+        which are not ITombstoneStorage.
+*/
+// ITombstoneStorage is an IStorage which can store tombstones.
+type ITombstoneStorage[KT any, VT any] interface {
+	IStorage[KT, VT]
+
+	SetTombstone(ctx context.Context, key *KT, ttl time.Duration, timeout time.Duration) error
 }
 
-func (b *BaseStorage[KT]) Unlock(ctx context.Context, key *KT) {
-	lock, ok := b.lockMap.Load(*key)
-	if !ok {
-		return
+// setWithTTL sets with ttl if storage is an ITTLStorage and ttl > 0, otherwise with Set.
+func setWithTTL[KT any, VT any](
+	ctx context.Context, storage IStorage[KT, VT], key *KT, value *VT, ttl time.Duration, timeout time.Duration,
+) error {
+	if ttlStorage, ok := storage.(ITTLStorage[KT, VT]); ok && ttl > 0 {
+		return ttlStorage.SetWithTTL(ctx, key, value, ttl, timeout)
 	}
-	lock.(*sync.Mutex).Unlock()
+	return storage.Set(ctx, key, value, timeout)
 }
 
+// CoherencyConfig configures a CoherencyStorage.
+type CoherencyConfig struct {
+	// CacheTTL is the TTL of values set in the cache layer, 0 means the default of the cache.
+	CacheTTL time.Duration
+	// SourceTTL is the TTL of values set in the source layer, 0 means the default of the source.
+	SourceTTL time.Duration
+	// SoftTTL is the age after which a cached value is stale, 0 disables stale-while-revalidate.
+	// A stale value is returned at once and refreshed in the background, CacheTTL is the hard TTL.
+	SoftTTL time.Duration
+	// RefreshAhead refreshes values read within RefreshAhead before SoftTTL in the background,
+	// so hot keys never become stale. Only used with SoftTTL.
+	RefreshAhead time.Duration
+	// WriteMode is one of WriteMode*, empty means WriteModeThrough.
+	WriteMode string
+	// WriteBehind configures the queue of WriteModeBehind.
+	WriteBehind WriteBehindConfig
+	// Invalidation publishes the keys of Set and Del to other instances
+	// and drops the keys they publish from the cache, nil disables it.
+	Invalidation IInvalidationBus
+	// InstanceID identifies the instance on Invalidation, empty means a random id.
+	InstanceID string
+	// InvalidationMaxVersions bounds the number of keys whose last received version is kept,
+	// 0 means DefaultInvalidationMaxVersions.
+	InvalidationMaxVersions int
+	// LockProvider locks keys across instances instead of the locks of the layers, nil disables it.
+	LockProvider ILockProvider
+	// LockTTL is the lease TTL of LockProvider, 0 means DefaultLockTTL, at least MinLockTTL.
+	LockTTL time.Duration
+	// LockPrefix is prepended to the lock names of keys.
+	LockPrefix string
+	// NegativeTTL is the TTL of tombstones of keys missing in the source, 0 disables negative caching.
+	NegativeTTL time.Duration
+	// NegativeMaxEntries bounds the side tombstone storage of caches which are not ITombstoneStorage,
+	// 0 means DefaultNegativeMaxEntries.
+	NegativeMaxEntries int
+}
+
+// DefaultNegativeMaxEntries is the default CoherencyConfig.NegativeMaxEntries.
+const DefaultNegativeMaxEntries = 10000
+
 type CoherencyStorage[KT any, VT any] struct {
 	BaseStorage[KT]
 
 	cache  IStorage[KT, VT]
 	source IStorage[KT, VT]
+	config CoherencyConfig
+	flight *flightGroup[VT]
+	// negative holds tombstones if negative caching is enabled and cache is not an ITombstoneStorage
+	negative *BoundedMemoryStorage[KT, struct{}]
+	// behind queues the writes of the source in WriteModeBehind
+	behind *writeBehindQueue[KT, VT]
+	// invalidator publishes and receives invalidations if Invalidation is set
+	invalidator *invalidator[KT]
+	// meta holds a *cacheMeta per cached key if SoftTTL > 0, see sweepMeta
+	meta      sync.Map
+	lastSweep atomic.Int64
+	now       func() time.Time
 }
 
 func NewCoherencyStorage[KT any, VT any](
 	cache IStorage[KT, VT], source IStorage[KT, VT],
 ) *CoherencyStorage[KT, VT] {
-	return &CoherencyStorage[KT, VT]{
+	return NewCoherencyStorageWithConfig(cache, source, CoherencyConfig{})
+}
+
+// NewCoherencyStorageWithConfig creates a CoherencyStorage,
+// TTLs are only applied to layers implementing ITTLStorage.
+func NewCoherencyStorageWithConfig[KT any, VT any](
+	cache IStorage[KT, VT], source IStorage[KT, VT], config CoherencyConfig,
+) *CoherencyStorage[KT, VT] {
+	if config.LockTTL == 0 {
+		config.LockTTL = DefaultLockTTL
+	}
+	if config.LockProvider != nil && config.LockTTL < MinLockTTL {
+		panic(fmt.Sprintf("Failed to create CoherencyStorage: LockTTL %v below %v", config.LockTTL, MinLockTTL))
+	}
+	c := &CoherencyStorage[KT, VT]{
 		cache:  cache,
 		source: source,
-		BaseStorage: BaseStorage[KT]{
-			lockMap: sync.Map{},
-		},
+		config: config,
+		flight: newFlightGroup[VT](),
+		now:    time.Now,
+	}
+	switch config.WriteMode {
+	case "", WriteModeThrough, WriteModeAround:
+	case WriteModeBehind:
+		var err error
+		c.behind, err = newWriteBehindQueue(source, config.SourceTTL, config.WriteBehind,
+			func(ctx context.Context, key *KT) (context.Context, func(), error) {
+				return c.lockKey(ctx, source, key)
+			})
+		if err != nil {
+			panic(fmt.Sprintf("Failed to create CoherencyStorage: failed to replay the write-behind journal: %v", err))
+		}
+	default:
+		panic(fmt.Sprintf("Failed to create CoherencyStorage: unknown write mode %q", config.WriteMode))
+	}
+	if config.Invalidation != nil {
+		if config.InstanceID == "" {
+			config.InstanceID = newInstanceID()
+		}
+		if config.InvalidationMaxVersions <= 0 {
+			config.InvalidationMaxVersions = DefaultInvalidationMaxVersions
+		}
+		// the default policy never fails
+		versions, _ := NewBoundedMemoryStorage(BoundedMemoryConfig[KT, invalidationVersion]{
+			MaxEntries: config.InvalidationMaxVersions,
+		})
+		c.invalidator = &invalidator[KT]{
+			bus:      config.Invalidation,
+			instance: config.InstanceID,
+			now:      time.Now,
+			versions: versions,
+		}
+		c.invalidator.unsubscribe = config.Invalidation.Subscribe(c.invalidate)
 	}
+	if _, ok := cache.(ITombstoneStorage[KT, VT]); !ok && config.NegativeTTL > 0 {
+		if config.NegativeMaxEntries <= 0 {
+			config.NegativeMaxEntries = DefaultNegativeMaxEntries
+		}
+		// the default policy never fails
+		c.negative, _ = NewBoundedMemoryStorage(BoundedMemoryConfig[KT, struct{}]{
+			MaxEntries: config.NegativeMaxEntries,
+			DefaultTTL: config.NegativeTTL,
+		})
+	}
+	return c
 }
 
 func (c *CoherencyStorage[KT, VT]) Get(
@@ -63,62 +215,172 @@ func (c *CoherencyStorage[KT, VT]) Get(
 	subCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	meta, _ := c.meta.Load(any(*key))
 	cacheValue, err := c.cache.Get(subCtx, key, c.cache.Timeout())
-	if err != nil || cacheValue != nil {
+	if err == nil {
+		c.refreshIfStale(ctx, key, timeout)
+	}
+	if !IsNotFound(err) {
 		return cacheValue, err
 	}
+	// the value was evicted or expired by the cache
+	c.dropMeta(key, meta)
+	if c.isTombstone(subCtx, key, err) {
+		return nil, ErrNotFound
+	}
 
-	c.cache.Lock(subCtx, key)
-	defer c.cache.Unlock(subCtx, key)
+	// concurrent misses of a key share one load
+	value, _, err := c.flight.Do(subCtx, any(*key), timeout, func(loadCtx context.Context) (*VT, error) {
+		return c.load(loadCtx, key)
+	})
+	return value, err
+}
 
-	cacheValue, err = c.source.Get(subCtx, key, c.cache.Timeout())
-	if err != nil || cacheValue != nil {
+// load reads key from the source under the cache lock and fills the cache,
+// the cache is checked again under the lock since another caller may have filled it.
+func (c *CoherencyStorage[KT, VT]) load(ctx context.Context, key *KT) (*VT, error) {
+	ctx, unlock, err := c.lockKey(ctx, c.cache, key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cacheValue, err := c.cache.Get(ctx, key, c.cache.Timeout())
+	if !IsNotFound(err) {
 		return cacheValue, err
 	}
+	if c.isTombstone(ctx, key, err) {
+		return nil, ErrNotFound
+	}
 
-	sourceValue, err := c.source.Get(subCtx, key, c.cache.Timeout())
+	return c.fill(ctx, key, false)
+}
+
+// fill reads key from the source and sets it in the cache, it must be called with the cache lock.
+// refresh is set when the cache holds a stale value, which is dropped if the source has no value.
+func (c *CoherencyStorage[KT, VT]) fill(ctx context.Context, key *KT, refresh bool) (*VT, error) {
+	sourceValue, err := c.readSource(ctx, key)
+	if IsNotFound(err) {
+		c.meta.Delete(any(*key))
+		if c.config.NegativeTTL > 0 {
+			if err := c.setTombstone(ctx, key); err != nil {
+				return nil, err
+			}
+		} else if refresh {
+			if err := c.cache.Del(ctx, key, c.cache.Timeout()); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if err = c.cache.Set(subCtx, key, sourceValue, c.cache.Timeout()); err != nil {
+	if err = setWithTTL(ctx, c.cache, key, sourceValue, c.config.CacheTTL, c.cache.Timeout()); err != nil {
 		return nil, err
 	}
+	c.touch(key)
 
 	return sourceValue, nil
 }
 
+// readSource reads key from the write-behind queue if it is pending, otherwise from the source.
+func (c *CoherencyStorage[KT, VT]) readSource(ctx context.Context, key *KT) (*VT, error) {
+	if c.behind != nil {
+		if op, ok := c.behind.lookup(key); ok {
+			if op.del {
+				return nil, ErrNotFound
+			}
+			return op.value, nil
+		}
+	}
+	return c.source.Get(ctx, key, c.source.Timeout())
+}
+
+// isTombstone reports whether key is known to be missing in the source,
+// err is the error of the cache Get.
+func (c *CoherencyStorage[KT, VT]) isTombstone(ctx context.Context, key *KT, err error) bool {
+	if errors.Is(err, ErrTombstone) {
+		return true
+	}
+	if c.negative == nil {
+		return false
+	}
+	_, err = c.negative.Get(ctx, key, c.negative.Timeout())
+	return err == nil
+}
+
+func (c *CoherencyStorage[KT, VT]) setTombstone(ctx context.Context, key *KT) error {
+	if tombstoneStorage, ok := c.cache.(ITombstoneStorage[KT, VT]); ok {
+		return tombstoneStorage.SetTombstone(ctx, key, c.config.NegativeTTL, c.cache.Timeout())
+	}
+	return c.negative.Set(ctx, key, &struct{}{}, c.negative.Timeout())
+}
+
+// delTombstone drops the tombstone of the side storage, tombstones in the cache are replaced by Set.
+func (c *CoherencyStorage[KT, VT]) delTombstone(ctx context.Context, key *KT) error {
+	if c.negative == nil {
+		return nil
+	}
+	return c.negative.Del(ctx, key, c.negative.Timeout())
+}
+
 func (c *CoherencyStorage[KT, VT]) Set(
 	ctx context.Context, key *KT, value *VT, timeout time.Duration,
 ) error {
 	subCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	if err := func() error {
-		c.source.Lock(subCtx, key)
-		defer c.source.Unlock(subCtx, key)
-
-		if err := c.source.Set(subCtx, key, value, c.source.Timeout()); err != nil {
+	if c.behind == nil {
+		if err := func() error {
+			subCtx, unlock, err := c.lockKey(subCtx, c.source, key)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
+			if err := setWithTTL(subCtx, c.source, key, value, c.config.SourceTTL, c.source.Timeout()); err != nil {
+				return err
+			}
+			return nil
+		}(); err != nil {
 			return err
 		}
-		return nil
-	}(); err != nil {
-		return err
 	}
 
+	var version uint64
 	if err := func() error {
-		c.cache.Lock(subCtx, key)
-		defer c.cache.Unlock(subCtx, key)
-
-		if err := c.cache.Set(subCtx, key, value, c.cache.Timeout()); err != nil {
+		subCtx, unlock, err := c.lockKey(subCtx, c.cache, key)
+		if err != nil {
 			return err
 		}
-		return nil
+		defer unlock()
+
+		version = c.newVersion()
+		switch {
+		case c.config.WriteMode == WriteModeAround:
+			if err := c.cache.Del(subCtx, key, c.cache.Timeout()); err != nil {
+				return err
+			}
+			c.meta.Delete(any(*key))
+			return c.delTombstone(subCtx, key)
+		case c.behind != nil:
+			// queued under the cache lock, so a concurrent load reads it instead of the source
+			if err := c.behind.enqueue(&writeOp[KT, VT]{key: *key, value: value}); err != nil {
+				return err
+			}
+		}
+		if err := setWithTTL(subCtx, c.cache, key, value, c.config.CacheTTL, c.cache.Timeout()); err != nil {
+			return err
+		}
+		c.touch(key)
+		return c.delTombstone(subCtx, key)
 	}(); err != nil {
 		return err
 	}
 
-	return nil
+	return c.publish(subCtx, key, version)
 }
 
 func (c *CoherencyStorage[KT, VT]) Del(
@@ -127,48 +389,75 @@ func (c *CoherencyStorage[KT, VT]) Del(
 	subCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	if err := func() error {
-		c.source.Lock(subCtx, key)
-		defer c.source.Unlock(subCtx, key)
-
-		if err := c.source.Del(subCtx, key, c.source.Timeout()); err != nil {
+	if c.behind == nil {
+		if err := func() error {
+			subCtx, unlock, err := c.lockKey(subCtx, c.source, key)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
+			if err := c.source.Del(subCtx, key, c.source.Timeout()); err != nil {
+				return err
+			}
+			return nil
+		}(); err != nil {
 			return err
 		}
-		return nil
-	}(); err != nil {
-		return err
 	}
 
+	var version uint64
 	if err := func() error {
-		c.cache.Lock(subCtx, key)
-		defer c.cache.Unlock(subCtx, key)
+		subCtx, unlock, err := c.lockKey(subCtx, c.cache, key)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 
+		version = c.newVersion()
+		if c.behind != nil {
+			if err := c.behind.enqueue(&writeOp[KT, VT]{key: *key, del: true}); err != nil {
+				return err
+			}
+		}
 		if err := c.cache.Del(subCtx, key, c.cache.Timeout()); err != nil {
 			return err
 		}
-		return nil
+		c.meta.Delete(any(*key))
+		return c.delTombstone(subCtx, key)
 	}(); err != nil {
 		return err
 	}
 
-	return nil
+	return c.publish(subCtx, key, version)
 }
 
-func (c *CoherencyStorage[KT, VT]) Lock(
-	_ context.Context, key *KT,
-) {
-	lock, _ := c.lockMap.LoadOrStore(*key, &sync.Mutex{})
-	lock.(*sync.Mutex).Lock()
+// Pending returns the number of keys waiting for the source in WriteModeBehind.
+func (c *CoherencyStorage[KT, VT]) Pending() int {
+	if c.behind == nil {
+		return 0
+	}
+	return c.behind.Len()
 }
 
-func (c *CoherencyStorage[KT, VT]) Unlock(
-	_ context.Context, key *KT,
-) {
-	lock, ok := c.lockMap.Load(*key)
-	if !ok {
-		return
+// Flush writes the keys waiting for the source in WriteModeBehind, until none is left or ctx is done.
+func (c *CoherencyStorage[KT, VT]) Flush(ctx context.Context) error {
+	if c.behind == nil {
+		return nil
+	}
+	return c.behind.Flush(ctx)
+}
+
+// Close unsubscribes from Invalidation, flushes the keys waiting for the source in WriteModeBehind
+// and stops its worker, Set and Del fail with ErrWriteBehindClosed after it.
+func (c *CoherencyStorage[KT, VT]) Close(ctx context.Context) error {
+	if c.invalidator != nil {
+		c.invalidator.unsubscribe()
+	}
+	if c.behind == nil {
+		return nil
 	}
-	lock.(*sync.Mutex).Unlock()
+	return c.behind.Close(ctx)
 }
 
 func (c *CoherencyStorage[KT, VT]) Timeout() time.Duration {
@@ -2,6 +2,7 @@ package coherency_cache
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -15,6 +16,10 @@ func TestCoherencyStorage(t *testing.T) {
 	t.Run("Cache Miss Scenario", TestCacheMissScenario)
 	t.Run("Nested CoherencyStorage", TestNestedCoherencyStorage)
 	t.Run("Stress Test", TestStressTest)
+	t.Run("Source Read Once Per Miss", TestSourceReadOncePerMiss)
+	t.Run("Recheck Cache Under Lock", TestRecheckCacheUnderLock)
+	t.Run("Negative Caching", TestNegativeCaching)
+	t.Run("Negative Caching Side Storage", TestNegativeCachingSideStorage)
 }
 
 func TestBasicOperations(t *testing.T) {
@@ -51,8 +56,8 @@ func TestBasicOperations(t *testing.T) {
 
 	// Test Get after Del
 	getValue, err = cache.Get(context.Background(), &key, time.Second*10)
-	if err != nil {
-		t.Fatal("Get after Del failed:", err)
+	if !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound after Del, got:", err)
 	}
 	if getValue != nil {
 		t.Fatal("value should be nil after deletion, got:", *getValue)
@@ -430,19 +435,19 @@ func TestNestedCoherencyStorage(t *testing.T) {
 
 		// Verify deleted from L1
 		getValue, err = L1Cache.Get(context.Background(), &deleteKey, time.Second*10)
-		if err != nil || getValue != nil {
+		if !IsNotFound(err) || getValue != nil {
 			t.Fatal("Value should be deleted from L1")
 		}
 
 		// Verify deleted from L2
 		getValue2, err := L2Cache.Get(context.Background(), &deleteKey, time.Second*10)
-		if err != nil || getValue2 != nil {
+		if !IsNotFound(err) || getValue2 != nil {
 			t.Fatal("Value should be deleted from L2")
 		}
 
 		// Verify deleted from L3
 		getValue3, err := L3Storage.Get(context.Background(), &deleteKey, time.Second*10)
-		if err != nil || getValue3 != nil {
+		if !IsNotFound(err) || getValue3 != nil {
 			t.Fatal("Value should be deleted from L3")
 		}
 	})
@@ -530,8 +535,8 @@ func TestMemoryStorage(t *testing.T) {
 	}
 
 	getValue, err = storage.Get(context.Background(), &key, time.Millisecond*100)
-	if err != nil {
-		t.Fatal("MemoryStorage Get after Del failed:", err)
+	if !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound after Del, got:", err)
 	}
 
 	if getValue != nil {
@@ -544,3 +549,129 @@ func TestMemoryStorage(t *testing.T) {
 		t.Fatal("Expected timeout 100ms, got:", timeout)
 	}
 }
+
+func TestSourceReadOncePerMiss(t *testing.T) {
+	source := newCountingStorage[string, string](0)
+	cache := NewCoherencyStorage[string, string](NewMemoryStorage[string, string](), source)
+
+	key, value := "key", "value"
+	_ = source.MemoryStorage.Set(context.Background(), &key, &value, source.Timeout())
+
+	// A miss reads the source once, then the cache serves the key
+	for i := 0; i < 3; i++ {
+		getValue, err := cache.Get(context.Background(), &key, time.Second)
+		if err != nil || getValue == nil || *getValue != value {
+			t.Fatal("Unexpected Get result:", getValue, err)
+		}
+	}
+	if gets := source.gets.Load(); gets != 1 {
+		t.Fatal("Expected 1 source Get, got:", gets)
+	}
+
+	// A key missing in the source is ErrNotFound after one source read
+	missingKey := "missing"
+	if _, err := cache.Get(context.Background(), &missingKey, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound, got:", err)
+	}
+	if gets := source.gets.Load(); gets != 2 {
+		t.Fatal("Expected 1 source Get for the missing key, got:", gets-1)
+	}
+}
+
+func TestRecheckCacheUnderLock(t *testing.T) {
+	source := newCountingStorage[string, string](0)
+	memoryCache := NewMemoryStorage[string, string]()
+	cache := NewCoherencyStorage[string, string](memoryCache, source)
+
+	key, value := "key", "value"
+
+	// Another writer holds the cache lock and fills the cache meanwhile
+	memoryCache.Lock(context.Background(), &key)
+	result := make(chan *string)
+	go func() {
+		getValue, _ := cache.Get(context.Background(), &key, time.Second)
+		result <- getValue
+	}()
+	time.Sleep(time.Millisecond * 20)
+	_ = memoryCache.Set(context.Background(), &key, &value, memoryCache.Timeout())
+	memoryCache.Unlock(context.Background(), &key)
+
+	if getValue := <-result; getValue == nil || *getValue != value {
+		t.Fatal("Expected the value filled by the other writer, got:", getValue)
+	}
+	if gets := source.gets.Load(); gets != 0 {
+		t.Fatal("Expected no source Get, got:", gets)
+	}
+}
+
+// plainStorage hides the optional interfaces of a storage, e.g. ITombstoneStorage.
+type plainStorage[KT any, VT any] struct {
+	IStorage[KT, VT]
+}
+
+func TestNegativeCaching(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	memoryCache := NewMemoryStorage[string, string]()
+	memoryCache.now = clock.Now
+	source := newCountingStorage[string, string](0)
+	cache := NewCoherencyStorageWithConfig[string, string](memoryCache, source, CoherencyConfig{
+		NegativeTTL: time.Second,
+	})
+
+	// Misses are served by the tombstone until NegativeTTL
+	key := "missing"
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+			t.Fatal("Expected ErrNotFound, got:", err)
+		}
+	}
+	if gets := source.gets.Load(); gets != 1 {
+		t.Fatal("Expected 1 source Get, got:", gets)
+	}
+	if _, err := memoryCache.Get(context.Background(), &key, memoryCache.Timeout()); !errors.Is(err, ErrTombstone) {
+		t.Fatal("Expected a tombstone in the cache, got:", err)
+	}
+	clock.Advance(time.Second)
+	_, _ = cache.Get(context.Background(), &key, time.Second)
+	if gets := source.gets.Load(); gets != 2 {
+		t.Fatal("Expected the source to be read after NegativeTTL, got:", gets)
+	}
+
+	// Set replaces the tombstone
+	value := "value"
+	if err := cache.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	getValue, err := cache.Get(context.Background(), &key, time.Second)
+	if err != nil || *getValue != value {
+		t.Fatal("Expected value after Set, got:", getValue, err)
+	}
+}
+
+func TestNegativeCachingSideStorage(t *testing.T) {
+	source := newCountingStorage[string, string](0)
+	cache := NewCoherencyStorageWithConfig[string, string](
+		plainStorage[string, string]{NewMemoryStorage[string, string]()}, source,
+		CoherencyConfig{NegativeTTL: time.Minute},
+	)
+
+	key := "missing"
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+			t.Fatal("Expected ErrNotFound, got:", err)
+		}
+	}
+	if gets := source.gets.Load(); gets != 1 {
+		t.Fatal("Expected 1 source Get, got:", gets)
+	}
+
+	// Set drops the side tombstone
+	value := "value"
+	if err := cache.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	getValue, err := cache.Get(context.Background(), &key, time.Second)
+	if err != nil || *getValue != value {
+		t.Fatal("Expected value after Set, got:", getValue, err)
+	}
+}
@@ -0,0 +1,279 @@
+package coherency_cache
+
+import (
+	"container/list"
+	"fmt"
+)
+
+const (
+	EvictionPolicyLRU = "lru"
+	EvictionPolicyLFU = "lfu"
+	EvictionPolicyARC = "arc"
+)
+
+// Check if policies implement IEvictionPolicy
+var (
+	_ IEvictionPolicy = (*LRUEvictionPolicy)(nil)
+	_ IEvictionPolicy = (*LFUEvictionPolicy)(nil)
+	_ IEvictionPolicy = (*ARCEvictionPolicy)(nil)
+)
+
+/*
+IEvictionPolicy:
+    1. IEvictionPolicy orders the resident keys of a bounded storage, it does not hold values.
+        The storage calls Add on insert, Access on hit, Remove on delete or expiry,
+        and Victim while it is over capacity.
+    2. Policies:
+        - lru: evicts the least recently used key.
+        - lfu: evicts the least frequently used key, the least recently used one on ties.
+        - arc: adaptive replacement cache, balances recency and frequency with ghost lists
+               of recently evicted keys, resists scans better than lru.
+    3. Policies are not safe for concurrent use, the storage serializes calls.
+*/
+// IEvictionPolicy chooses which key to evict.
+type IEvictionPolicy interface {
+	Add(key any)
+	Access(key any)
+	Remove(key any)
+	// Victim removes and returns the key to evict, false if there is no key.
+	Victim() (any, bool)
+}
+
+// NewEvictionPolicy creates a policy by name, capacity is the expected number of entries,
+// only used by arc to bound its ghost lists, 0 lets arc derive it from the resident keys.
+func NewEvictionPolicy(name string, capacity int) (IEvictionPolicy, error) {
+	switch name {
+	case "", EvictionPolicyLRU:
+		return NewLRUEvictionPolicy(), nil
+	case EvictionPolicyLFU:
+		return NewLFUEvictionPolicy(), nil
+	case EvictionPolicyARC:
+		return NewARCEvictionPolicy(capacity), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy: %q", name)
+	}
+}
+
+// LRUEvictionPolicy evicts the least recently used key.
+type LRUEvictionPolicy struct {
+	order    *list.List
+	elements map[any]*list.Element
+}
+
+func NewLRUEvictionPolicy() *LRUEvictionPolicy {
+	return &LRUEvictionPolicy{
+		order:    list.New(),
+		elements: map[any]*list.Element{},
+	}
+}
+
+func (p *LRUEvictionPolicy) Add(key any) {
+	if element, ok := p.elements[key]; ok {
+		p.order.MoveToFront(element)
+		return
+	}
+	p.elements[key] = p.order.PushFront(key)
+}
+
+func (p *LRUEvictionPolicy) Access(key any) {
+	if element, ok := p.elements[key]; ok {
+		p.order.MoveToFront(element)
+	}
+}
+
+func (p *LRUEvictionPolicy) Remove(key any) {
+	if element, ok := p.elements[key]; ok {
+		p.order.Remove(element)
+		delete(p.elements, key)
+	}
+}
+
+func (p *LRUEvictionPolicy) Victim() (any, bool) {
+	element := p.order.Back()
+	if element == nil {
+		return nil, false
+	}
+	p.order.Remove(element)
+	delete(p.elements, element.Value)
+	return element.Value, true
+}
+
+// LFUEvictionPolicy evicts the least frequently used key in O(1),
+// keys of the same frequency are kept in LRU order.
+type LFUEvictionPolicy struct {
+	// frequencies holds one *lfuBucket per frequency, in increasing order
+	frequencies *list.List
+	entries     map[any]*lfuEntry
+}
+
+type lfuBucket struct {
+	frequency int
+	keys      *list.List
+}
+
+type lfuEntry struct {
+	bucket  *list.Element
+	element *list.Element
+}
+
+func NewLFUEvictionPolicy() *LFUEvictionPolicy {
+	return &LFUEvictionPolicy{
+		frequencies: list.New(),
+		entries:     map[any]*lfuEntry{},
+	}
+}
+
+func (p *LFUEvictionPolicy) Add(key any) {
+	if _, ok := p.entries[key]; ok {
+		p.Access(key)
+		return
+	}
+	front := p.frequencies.Front()
+	if front == nil || front.Value.(*lfuBucket).frequency != 1 {
+		front = p.frequencies.PushFront(&lfuBucket{frequency: 1, keys: list.New()})
+	}
+	p.entries[key] = &lfuEntry{
+		bucket:  front,
+		element: front.Value.(*lfuBucket).keys.PushFront(key),
+	}
+}
+
+func (p *LFUEvictionPolicy) Access(key any) {
+	entry, ok := p.entries[key]
+	if !ok {
+		return
+	}
+	bucket := entry.bucket.Value.(*lfuBucket)
+	next := entry.bucket.Next()
+	if next == nil || next.Value.(*lfuBucket).frequency != bucket.frequency+1 {
+		next = p.frequencies.InsertAfter(&lfuBucket{frequency: bucket.frequency + 1, keys: list.New()}, entry.bucket)
+	}
+	p.unlink(entry)
+	entry.bucket = next
+	entry.element = next.Value.(*lfuBucket).keys.PushFront(key)
+}
+
+func (p *LFUEvictionPolicy) Remove(key any) {
+	if entry, ok := p.entries[key]; ok {
+		p.unlink(entry)
+		delete(p.entries, key)
+	}
+}
+
+func (p *LFUEvictionPolicy) Victim() (any, bool) {
+	front := p.frequencies.Front()
+	if front == nil {
+		return nil, false
+	}
+	key := front.Value.(*lfuBucket).keys.Back().Value
+	p.Remove(key)
+	return key, true
+}
+
+// unlink removes the key of entry from its bucket, and the bucket once empty.
+func (p *LFUEvictionPolicy) unlink(entry *lfuEntry) {
+	bucket := entry.bucket.Value.(*lfuBucket)
+	bucket.keys.Remove(entry.element)
+	if bucket.keys.Len() == 0 {
+		p.frequencies.Remove(entry.bucket)
+	}
+}
+
+// ARCEvictionPolicy is the adaptive replacement cache of Megiddo and Modha.
+// t1 holds keys seen once, t2 keys seen at least twice, b1 and b2 are their ghost lists.
+type ARCEvictionPolicy struct {
+	capacity int
+	// derived is set when capacity follows the largest number of resident keys
+	derived bool
+	// target is the adaptive target size of t1
+	target int
+
+	t1, t2, b1, b2 *LRUEvictionPolicy
+	// lastGhostHit is set when the last added key was found in b2
+	lastGhostHit bool
+}
+
+// NewARCEvictionPolicy creates an arc policy for capacity entries, 0 means the capacity
+// follows the largest number of resident keys, for storages bounded only by bytes.
+func NewARCEvictionPolicy(capacity int) *ARCEvictionPolicy {
+	return &ARCEvictionPolicy{
+		capacity: max(capacity, 1),
+		derived:  capacity <= 0,
+		t1:       NewLRUEvictionPolicy(),
+		t2:       NewLRUEvictionPolicy(),
+		b1:       NewLRUEvictionPolicy(),
+		b2:       NewLRUEvictionPolicy(),
+	}
+}
+
+func (p *ARCEvictionPolicy) Add(key any) {
+	p.lastGhostHit = false
+	switch {
+	case p.resident(key):
+		p.Access(key)
+	case p.contains(p.b1, key):
+		// a recently evicted key of t1 is back, t1 was too small
+		p.target = min(p.capacity, p.target+max(p.b2.order.Len()/p.b1.order.Len(), 1))
+		p.b1.Remove(key)
+		p.t2.Add(key)
+	case p.contains(p.b2, key):
+		// a recently evicted key of t2 is back, t2 was too small
+		p.target = max(0, p.target-max(p.b1.order.Len()/p.b2.order.Len(), 1))
+		p.b2.Remove(key)
+		p.t2.Add(key)
+		p.lastGhostHit = true
+	default:
+		p.t1.Add(key)
+	}
+	if p.derived {
+		p.capacity = max(p.capacity, p.t1.order.Len()+p.t2.order.Len())
+	}
+}
+
+func (p *ARCEvictionPolicy) Access(key any) {
+	if p.contains(p.t1, key) {
+		p.t1.Remove(key)
+		p.t2.Add(key)
+		return
+	}
+	p.t2.Access(key)
+}
+
+func (p *ARCEvictionPolicy) Remove(key any) {
+	p.t1.Remove(key)
+	p.t2.Remove(key)
+}
+
+func (p *ARCEvictionPolicy) Victim() (any, bool) {
+	t1Len := p.t1.order.Len()
+	var key any
+	var ok bool
+	if t1Len > 0 && (t1Len > p.target || (p.lastGhostHit && t1Len == p.target) || p.t2.order.Len() == 0) {
+		if key, ok = p.t1.Victim(); ok {
+			p.b1.Add(key)
+		}
+	} else if key, ok = p.t2.Victim(); ok {
+		p.b2.Add(key)
+	}
+	p.trimGhosts()
+	return key, ok
+}
+
+// trimGhosts keeps the ghost lists within capacity.
+func (p *ARCEvictionPolicy) trimGhosts() {
+	for p.b1.order.Len() > p.capacity {
+		p.b1.Victim()
+	}
+	for p.b2.order.Len() > p.capacity {
+		p.b2.Victim()
+	}
+}
+
+func (p *ARCEvictionPolicy) resident(key any) bool {
+	return p.contains(p.t1, key) || p.contains(p.t2, key)
+}
+
+func (p *ARCEvictionPolicy) contains(l *LRUEvictionPolicy, key any) bool {
+	_, ok := l.elements[key]
+	return ok
+}
@@ -0,0 +1,582 @@
+package coherency_cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// FsyncAlways syncs the file after every write, a returned write survives a crash of the machine.
+	FsyncAlways = "always"
+	// FsyncInterval syncs the file every FsyncInterval, a crash loses at most the writes of one interval.
+	FsyncInterval = "interval"
+	// FsyncNever leaves syncing to the OS, a crash of the process loses nothing, a crash of the machine may.
+	FsyncNever = "never"
+)
+
+const (
+	DefaultFsyncInterval   = time.Second
+	DefaultCompactRatio    = 0.5
+	DefaultCompactMinBytes = 1 << 20
+	// DefaultCompactRetryBackoff is how long a write waits to compact again after a failed compaction.
+	DefaultCompactRetryBackoff = time.Second * 10
+)
+
+const (
+	fileRecordSet byte = iota + 1
+	fileRecordDel
+	fileRecordTombstone
+)
+
+// fileRecordHeaderSize is the crc32 and the length of the body.
+const fileRecordHeaderSize = 8
+
+var (
+	_ IStorage[any, any]          = (*FileStorage[any, any])(nil)
+	_ ITTLStorage[any, any]       = (*FileStorage[any, any])(nil)
+	_ ITombstoneStorage[any, any] = (*FileStorage[any, any])(nil)
+)
+
+// ErrFileStorageClosed is returned by the operations of a closed FileStorage.
+var ErrFileStorageClosed = errors.New("coherency_cache: file storage closed")
+
+/*
+FileStorage:
+    1. FileStorage is an append-only log of records, with an in-memory index of the offset
+        of the last record of each key, values are read from disk on Get.
+    2. Record: crc32 (4 bytes) | body length (4 bytes) | body,
+        body: op (1 byte) | expiration in unix ns (8 bytes) | key length (4 bytes) | key | value.
+        Keys and values are encoded by Codec.
+    3. Opening replays the log to rebuild the index. A record cut by a crash, or failing its crc,
+        ends the log: it and the records after it are truncated, earlier records are kept.
+    4. A failed write is truncated, so the log never holds a partial record,
+        with FsyncAlways a write whose sync fails is truncated as well.
+        A record length past the end of the file ends the log like a torn record.
+    5. Compaction rewrites the live records to a new file, syncs it and renames it over the log,
+        so a crash during compaction leaves either the old or the new log.
+        If the new log cannot be opened after the rename, the storage is closed.
+        It runs after a write when the dead bytes are over CompactRatio of a log
+        of at least CompactMinBytes, and with Compact.
+        A failed compaction after a write does not fail the write, which is already in the log:
+        it is reported to OnCompactError and by CompactError, and retried by the writes
+        after CompactRetryBackoff.
+    6. TTLs and tombstones work like MemoryStorage, expired records are dropped by compaction.
+*/
+// FileStorageConfig configures a FileStorage.
+type FileStorageConfig struct {
+	Path string
+	// Codec encodes keys and values, nil means JSONCodec.
+	Codec ICodec
+	// Fsync is one of Fsync*, empty means FsyncInterval.
+	Fsync         string
+	FsyncInterval time.Duration
+	// CompactRatio is the ratio of dead bytes triggering compaction, < 0 disables it.
+	CompactRatio    float64
+	CompactMinBytes int64
+	// CompactRetryBackoff is the wait before compacting again after a failure, 0 means DefaultCompactRetryBackoff.
+	CompactRetryBackoff time.Duration
+	// OnCompactError is called with the error of a compaction run after a write, with the log locked.
+	OnCompactError func(err error)
+	DefaultTTL     time.Duration
+	// Timeout is the default timeout of operations, 0 means 100ms.
+	Timeout time.Duration
+}
+
+// FileStorage is an IStorage on an append-only file.
+type FileStorage[KT any, VT any] struct {
+	BaseStorage[KT]
+
+	config FileStorageConfig
+	now    func() time.Time
+	// openFile and syncFile are os.OpenFile and File.Sync, replaced in tests
+	openFile func(name string, flag int, perm os.FileMode) (*os.File, error)
+	syncFile func(file *os.File) error
+
+	mu    sync.RWMutex
+	file  *os.File
+	size  int64
+	live  int64
+	index map[any]*fileEntry
+	dirty bool
+	// compactErr is the error of the last compaction, compactRetryAt when writes compact again
+	compactErr     error
+	compactRetryAt time.Time
+
+	closed chan struct{}
+}
+
+type fileEntry struct {
+	offset    int64
+	size      int64
+	expireAt  time.Time
+	tombstone bool
+}
+
+func (e *fileEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// NewFileStorage opens the log at config.Path, creating it if it does not exist.
+func NewFileStorage[KT any, VT any](config FileStorageConfig) (*FileStorage[KT, VT], error) {
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+	switch config.Fsync {
+	case "":
+		config.Fsync = FsyncInterval
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("unknown fsync policy: %q", config.Fsync)
+	}
+	if config.FsyncInterval <= 0 {
+		config.FsyncInterval = DefaultFsyncInterval
+	}
+	if config.CompactRatio == 0 {
+		config.CompactRatio = DefaultCompactRatio
+	}
+	if config.CompactMinBytes <= 0 {
+		config.CompactMinBytes = DefaultCompactMinBytes
+	}
+	if config.CompactRetryBackoff <= 0 {
+		config.CompactRetryBackoff = DefaultCompactRetryBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Millisecond * 100
+	}
+
+	f := &FileStorage[KT, VT]{
+		config:   config,
+		now:      time.Now,
+		openFile: os.OpenFile,
+		syncFile: (*os.File).Sync,
+		index:    map[any]*fileEntry{},
+		closed:   make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if config.Fsync == FsyncInterval {
+		go f.syncer()
+	}
+	return f, nil
+}
+
+// open opens the log and replays it.
+func (f *FileStorage[KT, VT]) open() error {
+	file, err := os.OpenFile(f.config.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	end, err := f.replay(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > end {
+		// drop a torn tail
+		if err := file.Truncate(end); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	f.file = file
+	f.size = end
+	return nil
+}
+
+// replay reads the records of file into the index, it returns the end of the last valid record.
+func (f *FileStorage[KT, VT]) replay(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, 0, info.Size()))
+	var offset int64
+	for {
+		record, err := readFileRecord(reader, info.Size()-offset)
+		if err != nil {
+			// io.EOF at the end, otherwise a torn or corrupted record
+			return offset, nil
+		}
+		op, expireAt, keyData, _, err := decodeFileRecord(record)
+		if err != nil {
+			return offset, nil
+		}
+		key := new(KT)
+		if err := f.config.Codec.Unmarshal(keyData, key); err != nil {
+			return 0, fmt.Errorf("failed to decode key at offset %d: %w", offset, err)
+		}
+		size := int64(fileRecordHeaderSize + len(record))
+		f.apply(any(*key), op, &fileEntry{offset: offset, size: size, expireAt: expireAt})
+		offset += size
+	}
+}
+
+// apply updates the index with a record, it must be called with f.mu held.
+func (f *FileStorage[KT, VT]) apply(mapKey any, op byte, entry *fileEntry) {
+	if old, ok := f.index[mapKey]; ok {
+		f.live -= old.size
+		delete(f.index, mapKey)
+	}
+	if op == fileRecordDel {
+		return
+	}
+	entry.tombstone = op == fileRecordTombstone
+	f.index[mapKey] = entry
+	f.live += entry.size
+}
+
+// readFileRecord reads the next record, remaining is the size of the file from the record on,
+// so a corrupted length cannot allocate more than the file.
+func readFileRecord(reader io.Reader, remaining int64) ([]byte, error) {
+	header := make([]byte, fileRecordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[4:]))
+	if length > remaining-fileRecordHeaderSize {
+		return nil, errors.New("record length past the end of the file")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header) {
+		return nil, errors.New("record crc mismatch")
+	}
+	return body, nil
+}
+
+func encodeFileRecord(op byte, expireAt time.Time, key []byte, value []byte) []byte {
+	body := make([]byte, 0, 13+len(key)+len(value))
+	body = append(body, op)
+	var expireNano int64
+	if !expireAt.IsZero() {
+		expireNano = expireAt.UnixNano()
+	}
+	body = binary.BigEndian.AppendUint64(body, uint64(expireNano))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(key)))
+	body = append(body, key...)
+	body = append(body, value...)
+
+	record := make([]byte, fileRecordHeaderSize, fileRecordHeaderSize+len(body))
+	binary.BigEndian.PutUint32(record, crc32.ChecksumIEEE(body))
+	binary.BigEndian.PutUint32(record[4:], uint32(len(body)))
+	return append(record, body...)
+}
+
+func decodeFileRecord(body []byte) (op byte, expireAt time.Time, key []byte, value []byte, err error) {
+	if len(body) < 13 {
+		return 0, time.Time{}, nil, nil, errors.New("record too short")
+	}
+	op = body[0]
+	if expireNano := int64(binary.BigEndian.Uint64(body[1:9])); expireNano != 0 {
+		expireAt = time.Unix(0, expireNano)
+	}
+	keyLen := int(binary.BigEndian.Uint32(body[9:13]))
+	if len(body) < 13+keyLen {
+		return 0, time.Time{}, nil, nil, errors.New("record key out of range")
+	}
+	return op, expireAt, body[13 : 13+keyLen], body[13+keyLen:], nil
+}
+
+func (f *FileStorage[KT, VT]) syncer() {
+	ticker := time.NewTicker(f.config.FsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.closed:
+			return
+		case <-ticker.C:
+			_ = f.Sync()
+		}
+	}
+}
+
+// Sync syncs the writes not synced yet to disk.
+func (f *FileStorage[KT, VT]) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return ErrFileStorageClosed
+	}
+	if !f.dirty {
+		return nil
+	}
+	f.dirty = false
+	return f.file.Sync()
+}
+
+func (f *FileStorage[KT, VT]) Get(
+	ctx context.Context, key *KT, timeout time.Duration,
+) (*VT, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.file == nil {
+		return nil, ErrFileStorageClosed
+	}
+	entry, ok := f.index[any(*key)]
+	if !ok || entry.expired(f.now()) {
+		return nil, ErrNotFound
+	}
+	if entry.tombstone {
+		return nil, ErrTombstone
+	}
+
+	record := make([]byte, entry.size)
+	if _, err := f.file.ReadAt(record, entry.offset); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(record[fileRecordHeaderSize:]) != binary.BigEndian.Uint32(record) {
+		return nil, fmt.Errorf("record crc mismatch at offset %d", entry.offset)
+	}
+	_, _, _, valueData, err := decodeFileRecord(record[fileRecordHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	value := new(VT)
+	if err := f.config.Codec.Unmarshal(valueData, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (f *FileStorage[KT, VT]) Set(
+	ctx context.Context, key *KT, value *VT, timeout time.Duration,
+) error {
+	return f.SetWithTTL(ctx, key, value, f.config.DefaultTTL, timeout)
+}
+
+func (f *FileStorage[KT, VT]) SetWithTTL(
+	ctx context.Context, key *KT, value *VT, ttl time.Duration, timeout time.Duration,
+) error {
+	valueData, err := f.config.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return f.write(ctx, key, fileRecordSet, valueData, ttl)
+}
+
+func (f *FileStorage[KT, VT]) SetTombstone(
+	ctx context.Context, key *KT, ttl time.Duration, timeout time.Duration,
+) error {
+	return f.write(ctx, key, fileRecordTombstone, nil, ttl)
+}
+
+func (f *FileStorage[KT, VT]) Del(
+	ctx context.Context, key *KT, timeout time.Duration,
+) error {
+	f.mu.RLock()
+	_, ok := f.index[any(*key)]
+	f.mu.RUnlock()
+	if !ok {
+		return ctx.Err()
+	}
+	return f.write(ctx, key, fileRecordDel, nil, 0)
+}
+
+// write appends a record of key, and compacts the log if it has too many dead bytes.
+func (f *FileStorage[KT, VT]) write(ctx context.Context, key *KT, op byte, valueData []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	keyData, err := f.config.Codec.Marshal(key)
+	if err != nil {
+		return err
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = f.now().Add(ttl)
+	}
+	record := encodeFileRecord(op, expireAt, keyData, valueData)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return ErrFileStorageClosed
+	}
+	if _, err := f.file.Write(record); err != nil {
+		// never leave a partial record
+		_ = f.file.Truncate(f.size)
+		return err
+	}
+	if f.config.Fsync == FsyncAlways {
+		if err := f.syncFile(f.file); err != nil {
+			// the write may not be durable, it is not acknowledged
+			_ = f.file.Truncate(f.size)
+			return err
+		}
+	} else {
+		f.dirty = true
+	}
+	f.apply(any(*key), op, &fileEntry{offset: f.size, size: int64(len(record)), expireAt: expireAt})
+	f.size += int64(len(record))
+
+	if f.config.CompactRatio > 0 && f.size >= f.config.CompactMinBytes &&
+		float64(f.size-f.live) > f.config.CompactRatio*float64(f.size) &&
+		!f.now().Before(f.compactRetryAt) {
+		// the record is in the log, a failed compaction does not fail the write
+		if err := f.compact(); err != nil {
+			f.compactRetryAt = f.now().Add(f.config.CompactRetryBackoff)
+			if f.config.OnCompactError != nil {
+				f.config.OnCompactError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Compact rewrites the log with the live records only.
+func (f *FileStorage[KT, VT]) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return ErrFileStorageClosed
+	}
+	return f.compact()
+}
+
+// CompactError returns the error of the last compaction, nil if it succeeded.
+func (f *FileStorage[KT, VT]) CompactError() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.compactErr
+}
+
+// compact records the result of compactLog, it must be called with f.mu held.
+func (f *FileStorage[KT, VT]) compact() error {
+	err := f.compactLog()
+	f.compactErr = err
+	if err == nil {
+		f.compactRetryAt = time.Time{}
+	}
+	return err
+}
+
+// compactLog must be called with f.mu held.
+func (f *FileStorage[KT, VT]) compactLog() error {
+	tmpPath := f.config.Path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	now := f.now()
+	index := make(map[any]*fileEntry, len(f.index))
+	writer := bufio.NewWriter(tmp)
+	var offset int64
+	for mapKey, entry := range f.index {
+		if entry.expired(now) {
+			continue
+		}
+		record := make([]byte, entry.size)
+		if _, err := f.file.ReadAt(record, entry.offset); err != nil {
+			return fail(err)
+		}
+		if _, err := writer.Write(record); err != nil {
+			return fail(err)
+		}
+		index[mapKey] = &fileEntry{offset: offset, size: entry.size, expireAt: entry.expireAt, tombstone: entry.tombstone}
+		offset += entry.size
+	}
+	if err := writer.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, f.config.Path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(f.config.Path))
+
+	file, err := f.openFile(f.config.Path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		// the old file is no longer the log, writes to it would be lost
+		_ = f.file.Close()
+		f.file = nil
+		close(f.closed)
+		return fmt.Errorf("failed to reopen the log after compaction, storage closed: %w", err)
+	}
+	_ = f.file.Close()
+	f.file = file
+	f.index = index
+	f.size = offset
+	f.live = offset
+	f.dirty = false
+	return nil
+}
+
+// syncDir syncs a directory so a rename in it survives a crash, not supported on every OS.
+func syncDir(path string) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	_ = dir.Sync()
+	_ = dir.Close()
+}
+
+// Close syncs and closes the log.
+func (f *FileStorage[KT, VT]) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	close(f.closed)
+	err := errors.Join(f.file.Sync(), f.file.Close())
+	f.file = nil
+	return err
+}
+
+// Keys returns the keys of the live values and tombstones, in no order.
+func (f *FileStorage[KT, VT]) Keys() ([]KT, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.file == nil {
+		return nil, ErrFileStorageClosed
+	}
+	now := f.now()
+	keys := make([]KT, 0, len(f.index))
+	for mapKey, entry := range f.index {
+		if !entry.expired(now) {
+			keys = append(keys, mapKey.(KT))
+		}
+	}
+	return keys, nil
+}
+
+// Size returns the size of the log and the size of its live records.
+func (f *FileStorage[KT, VT]) Size() (size int64, live int64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.size, f.live
+}
+
+func (f *FileStorage[KT, VT]) Timeout() time.Duration {
+	return f.config.Timeout
+}
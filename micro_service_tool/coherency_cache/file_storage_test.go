@@ -0,0 +1,350 @@
+package coherency_cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorage(t *testing.T) {
+	t.Run("Reopen", TestFileStorageReopen)
+	t.Run("Torn Tail", TestFileStorageTornTail)
+	t.Run("Compaction", TestFileStorageCompaction)
+	t.Run("Fsync Policy", TestFileStorageFsyncPolicy)
+	t.Run("Coherency L2", TestFileStorageCoherencyL2)
+	t.Run("Record Length", TestFileStorageRecordLength)
+	t.Run("Sync Failure", TestFileStorageSyncFailure)
+	t.Run("Reopen Failure", TestFileStorageCompactReopenFailure)
+	t.Run("Compaction Failure", TestFileStorageCompactFailure)
+}
+
+func openTestFileStorage(t *testing.T, config FileStorageConfig) *FileStorage[string, redisTestValue] {
+	storage, err := NewFileStorage[string, redisTestValue](config)
+	if err != nil {
+		t.Fatal("Failed to open file storage:", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	config := FileStorageConfig{Path: filepath.Join(t.TempDir(), "storage.log")}
+	storage := openTestFileStorage(t, config)
+	ctx := context.Background()
+
+	keys := []string{"kept", "deleted", "expiring", "missing"}
+	for i := range keys {
+		value := redisTestValue{Name: keys[i], Count: i}
+		if err := storage.Set(ctx, &keys[i], &value, storage.Timeout()); err != nil {
+			t.Fatal("Set failed:", err)
+		}
+	}
+	_ = storage.Del(ctx, &keys[1], storage.Timeout())
+	_ = storage.SetWithTTL(ctx, &keys[2], &redisTestValue{Name: "expiring"}, time.Millisecond, storage.Timeout())
+	_ = storage.SetTombstone(ctx, &keys[3], time.Hour, storage.Timeout())
+	if err := storage.Close(); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+	if _, err := storage.Get(ctx, &keys[0], storage.Timeout()); !errors.Is(err, ErrFileStorageClosed) {
+		t.Fatal("Expected ErrFileStorageClosed after Close, got:", err)
+	}
+
+	// The log is replayed on open
+	time.Sleep(time.Millisecond * 5)
+	storage = openTestFileStorage(t, config)
+	if getValue, err := storage.Get(ctx, &keys[0], storage.Timeout()); err != nil || getValue.Name != keys[0] {
+		t.Fatal("Expected value kept across reopen, got:", getValue, err)
+	}
+	if _, err := storage.Get(ctx, &keys[1], storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected deleted key missing, got:", err)
+	}
+	if _, err := storage.Get(ctx, &keys[2], storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected expired key missing, got:", err)
+	}
+	if _, err := storage.Get(ctx, &keys[3], storage.Timeout()); !errors.Is(err, ErrTombstone) {
+		t.Fatal("Expected tombstone kept across reopen, got:", err)
+	}
+	// deleted and expired keys are not listed
+	if liveKeys, err := storage.Keys(); err != nil || len(liveKeys) != 2 {
+		t.Fatal("Expected the kept key and the tombstone, got:", liveKeys, err)
+	}
+}
+
+func TestFileStorageTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.log")
+	storage := openTestFileStorage(t, FileStorageConfig{Path: path, Fsync: FsyncAlways})
+	ctx := context.Background()
+
+	first, second := "first", "second"
+	_ = storage.Set(ctx, &first, &redisTestValue{Name: first}, storage.Timeout())
+	_ = storage.Set(ctx, &second, &redisTestValue{Name: second}, storage.Timeout())
+	size, _ := storage.Size()
+	_ = storage.Close()
+
+	// A crash cut the last record
+	if err := os.Truncate(path, size-3); err != nil {
+		t.Fatal("Truncate failed:", err)
+	}
+	storage = openTestFileStorage(t, FileStorageConfig{Path: path, Fsync: FsyncAlways})
+	if getValue, err := storage.Get(ctx, &first, storage.Timeout()); err != nil || getValue.Name != first {
+		t.Fatal("Expected records before the torn one kept, got:", getValue, err)
+	}
+	if _, err := storage.Get(ctx, &second, storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected torn record dropped, got:", err)
+	}
+
+	// The torn tail is truncated, so new records are readable after it
+	_ = storage.Set(ctx, &second, &redisTestValue{Name: "again"}, storage.Timeout())
+	_ = storage.Close()
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	_, _ = file.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 4, 1, 2, 3, 4})
+	_ = file.Close()
+
+	storage = openTestFileStorage(t, FileStorageConfig{Path: path})
+	if getValue, err := storage.Get(ctx, &second, storage.Timeout()); err != nil || getValue.Name != "again" {
+		t.Fatal("Expected record written after the truncated tail, got:", getValue, err)
+	}
+	size, _ = storage.Size()
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Fatal("Expected corrupted tail truncated, file size:", info.Size(), "log size:", size)
+	}
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.log")
+	storage := openTestFileStorage(t, FileStorageConfig{Path: path, CompactRatio: -1})
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%d", i%5)
+		_ = storage.Set(ctx, &key, &redisTestValue{Name: key, Count: i}, storage.Timeout())
+	}
+	size, live := storage.Size()
+	if live*10 > size {
+		t.Fatal("Expected mostly dead bytes before compaction, size:", size, "live:", live)
+	}
+	if err := storage.Compact(); err != nil {
+		t.Fatal("Compact failed:", err)
+	}
+	if size, live = storage.Size(); size != live {
+		t.Fatal("Expected only live bytes after compaction, size:", size, "live:", live)
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Fatal("Expected compacted file of size", size, "got:", info.Size())
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Fatal("Expected no compaction file left, got:", err)
+	}
+
+	// Values survive compaction and reopen
+	_ = storage.Close()
+	storage = openTestFileStorage(t, FileStorageConfig{Path: path})
+	for i := 95; i < 100; i++ {
+		key := fmt.Sprintf("key_%d", i%5)
+		if getValue, err := storage.Get(ctx, &key, storage.Timeout()); err != nil || getValue.Count != i {
+			t.Fatal("Expected last value after compaction, got:", getValue, err)
+		}
+	}
+
+	// Compaction runs by itself past CompactRatio
+	auto := openTestFileStorage(t, FileStorageConfig{
+		Path:            filepath.Join(t.TempDir(), "auto.log"),
+		CompactRatio:    0.5,
+		CompactMinBytes: 1024,
+	})
+	key := "key"
+	for i := 0; i < 200; i++ {
+		_ = auto.Set(ctx, &key, &redisTestValue{Name: key, Count: i}, auto.Timeout())
+	}
+	if size, _ := auto.Size(); size > 2048 {
+		t.Fatal("Expected automatic compaction, log size:", size)
+	}
+}
+
+func TestFileStorageFsyncPolicy(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewFileStorage[string, string](FileStorageConfig{
+		Path: filepath.Join(dir, "invalid.log"), Fsync: "sometimes",
+	}); err == nil {
+		t.Fatal("Expected error for an unknown fsync policy")
+	}
+
+	ctx := context.Background()
+	key, value := "key", redisTestValue{Name: "value"}
+	always := openTestFileStorage(t, FileStorageConfig{Path: filepath.Join(dir, "always.log"), Fsync: FsyncAlways})
+	_ = always.Set(ctx, &key, &value, always.Timeout())
+	if always.dirty {
+		t.Fatal("Expected write synced with FsyncAlways")
+	}
+
+	interval := openTestFileStorage(t, FileStorageConfig{
+		Path: filepath.Join(dir, "interval.log"), Fsync: FsyncInterval, FsyncInterval: time.Millisecond * 10,
+	})
+	_ = interval.Set(ctx, &key, &value, interval.Timeout())
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		interval.mu.RLock()
+		dirty := interval.dirty
+		interval.mu.RUnlock()
+		if !dirty {
+			return
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	t.Fatal("Expected write synced within FsyncInterval")
+}
+
+func TestFileStorageCoherencyL2(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	source := newCountingStorage[string, redisTestValue](0)
+	key, value := "key", redisTestValue{Name: "value", Count: 1}
+	_ = source.MemoryStorage.Set(context.Background(), &key, &value, source.Timeout())
+
+	// memory L1 -> file L2 -> slow source
+	newStack := func() (*CoherencyStorage[string, redisTestValue], *FileStorage[string, redisTestValue]) {
+		l2 := openTestFileStorage(t, FileStorageConfig{Path: path})
+		l2Storage := NewCoherencyStorage[string, redisTestValue](l2, source)
+		return NewCoherencyStorage[string, redisTestValue](NewMemoryStorage[string, redisTestValue](), l2Storage), l2
+	}
+
+	storage, l2 := newStack()
+	if getValue, err := storage.Get(context.Background(), &key, time.Second); err != nil || *getValue != value {
+		t.Fatal("Unexpected Get result:", getValue, err)
+	}
+	_ = l2.Close()
+
+	// After a restart the value is served from disk
+	storage, _ = newStack()
+	if getValue, err := storage.Get(context.Background(), &key, time.Second); err != nil || *getValue != value {
+		t.Fatal("Unexpected Get result after restart:", getValue, err)
+	}
+	if gets := source.gets.Load(); gets != 1 {
+		t.Fatal("Expected 1 source Get across the restart, got:", gets)
+	}
+}
+
+func TestFileStorageRecordLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.log")
+	storage := openTestFileStorage(t, FileStorageConfig{Path: path})
+	key := "key"
+	_ = storage.Set(context.Background(), &key, &redisTestValue{Name: key}, storage.Timeout())
+	size, _ := storage.Size()
+	_ = storage.Close()
+
+	// a corrupted length of 4GB is not allocated, it ends the log
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	_, _ = file.Write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 1, 2, 3})
+	_ = file.Close()
+	if _, err := readFileRecord(bytes.NewReader([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}), 8); err == nil {
+		t.Fatal("Expected an error for a record length past the end of the file")
+	}
+
+	storage = openTestFileStorage(t, FileStorageConfig{Path: path})
+	if getValue, err := storage.Get(context.Background(), &key, storage.Timeout()); err != nil || getValue.Name != key {
+		t.Fatal("Expected the records before the corrupted length kept, got:", getValue, err)
+	}
+	if logSize, _ := storage.Size(); logSize != size {
+		t.Fatal("Expected the corrupted record truncated, got size:", logSize)
+	}
+}
+
+func TestFileStorageSyncFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.log")
+	storage := openTestFileStorage(t, FileStorageConfig{Path: path, Fsync: FsyncAlways})
+	ctx := context.Background()
+	kept, failed := "kept", "failed"
+	_ = storage.Set(ctx, &kept, &redisTestValue{Name: kept}, storage.Timeout())
+	size, _ := storage.Size()
+
+	errSync := errors.New("sync failed")
+	storage.syncFile = func(*os.File) error { return errSync }
+	if err := storage.Set(ctx, &failed, &redisTestValue{Name: failed}, storage.Timeout()); !errors.Is(err, errSync) {
+		t.Fatal("Expected the sync error, got:", err)
+	}
+	if _, err := storage.Get(ctx, &failed, storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected the unsynced write not indexed, got:", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Fatal("Expected the unsynced record truncated, file size:", info.Size(), "log size:", size)
+	}
+}
+
+func TestFileStorageCompactReopenFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.log")
+	storage := openTestFileStorage(t, FileStorageConfig{Path: path})
+	ctx := context.Background()
+	key := "key"
+	_ = storage.Set(ctx, &key, &redisTestValue{Name: key}, storage.Timeout())
+
+	errOpen := errors.New("open failed")
+	storage.openFile = func(string, int, os.FileMode) (*os.File, error) { return nil, errOpen }
+	if err := storage.Compact(); !errors.Is(err, errOpen) {
+		t.Fatal("Expected the open error, got:", err)
+	}
+	// the old file was renamed over, the storage does not write to it
+	if err := storage.Set(ctx, &key, &redisTestValue{Name: key}, storage.Timeout()); !errors.Is(err, ErrFileStorageClosed) {
+		t.Fatal("Expected ErrFileStorageClosed after the failed reopen, got:", err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	// the compacted log is complete
+	storage = openTestFileStorage(t, FileStorageConfig{Path: path})
+	if getValue, err := storage.Get(ctx, &key, storage.Timeout()); err != nil || getValue.Name != key {
+		t.Fatal("Expected the compacted log readable, got:", getValue, err)
+	}
+}
+
+func TestFileStorageCompactFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.log")
+	// a directory in the way of the compaction file fails every compaction
+	if err := os.Mkdir(path+".compact", 0o755); err != nil {
+		t.Fatal("Mkdir failed:", err)
+	}
+	var reported []error
+	journal, err := NewFileWriteBehindJournal[string, redisTestValue](FileStorageConfig{
+		Path:            path,
+		CompactRatio:    0.5,
+		CompactMinBytes: 1024,
+		OnCompactError:  func(err error) { reported = append(reported, err) },
+	})
+	if err != nil {
+		t.Fatal("Failed to open journal:", err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	storage := journal.storage
+	now := time.Now()
+	storage.now = func() time.Time { return now }
+
+	// the records are in the log, Record succeeds though the compaction fails
+	key := "key"
+	for i := 0; i < 200; i++ {
+		if err := journal.Record(key, &redisTestValue{Name: key, Count: i}, false); err != nil {
+			t.Fatal("Expected Record to succeed while compaction fails, got:", err)
+		}
+	}
+	if len(reported) != 1 || storage.CompactError() == nil {
+		t.Fatal("Expected one reported compaction error within the backoff, got:", reported, storage.CompactError())
+	}
+	if getValue, err := storage.Get(context.Background(), &key, storage.Timeout()); err != nil || getValue.Count != 199 {
+		t.Fatal("Expected the last recorded value, got:", getValue, err)
+	}
+
+	// a write after the backoff compacts again
+	if err := os.Remove(path + ".compact"); err != nil {
+		t.Fatal("Remove failed:", err)
+	}
+	now = now.Add(DefaultCompactRetryBackoff)
+	if err := journal.Record(key, &redisTestValue{Name: key, Count: 200}, false); err != nil {
+		t.Fatal("Record failed:", err)
+	}
+	if size, _ := storage.Size(); size > 2048 || storage.CompactError() != nil {
+		t.Fatal("Expected the retried compaction to succeed, log size:", size, storage.CompactError())
+	}
+}
@@ -0,0 +1,200 @@
+package coherency_cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultInvalidationMaxVersions is the default CoherencyConfig.InvalidationMaxVersions.
+const DefaultInvalidationMaxVersions = 100000
+
+// Check if buses implement IInvalidationBus
+var (
+	_ IInvalidationBus = (*LocalInvalidationBus)(nil)
+	_ IInvalidationBus = (*UDPInvalidationBus)(nil)
+)
+
+/*
+IInvalidationBus:
+    1. IInvalidationBus carries invalidations between the CoherencyStorages of several instances,
+        each with its own cache in front of a shared source.
+    2. After a Set or Del, a CoherencyStorage publishes the key with a version,
+        the other instances drop the key from their cache on receipt.
+        Messages of the publishing instance are ignored by itself.
+        A Publish error is returned by Set and Del, after the source and the cache are written.
+    3. Versions are hybrid logical clocks: the nanosecond time of the write, kept above every version
+        seen by the instance. Clocks of instances are not comparable, so an instance only drops
+        a duplicate or a reordered message of the instance which sent the last message of the key,
+        every other message drops the key, at the cost of a miss.
+    4. Delivery is best effort, a lost invalidation leaves a stale value until CacheTTL.
+*/
+// IInvalidationBus publishes invalidations to the other instances.
+type IInvalidationBus interface {
+	Publish(ctx context.Context, msg InvalidationMessage) error
+	// Subscribe calls handler for every message received until unsubscribe is called.
+	Subscribe(handler func(msg InvalidationMessage)) (unsubscribe func())
+}
+
+// InvalidationMessage is the invalidation of a key, the key is encoded in JSON.
+type InvalidationMessage struct {
+	Instance string          `json:"instance"`
+	Key      json.RawMessage `json:"key"`
+	Version  uint64          `json:"version"`
+}
+
+// LocalInvalidationBus is an in-process IInvalidationBus, Publish calls the handlers synchronously.
+type LocalInvalidationBus struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[int]func(msg InvalidationMessage)
+}
+
+func NewLocalInvalidationBus() *LocalInvalidationBus {
+	return &LocalInvalidationBus{
+		handlers: map[int]func(msg InvalidationMessage){},
+	}
+}
+
+func (b *LocalInvalidationBus) Publish(ctx context.Context, msg InvalidationMessage) error {
+	b.mu.RLock()
+	handlers := make([]func(msg InvalidationMessage), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	return ctx.Err()
+}
+
+func (b *LocalInvalidationBus) Subscribe(handler func(msg InvalidationMessage)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+// newInstanceID returns a random id of a CoherencyStorage.
+func newInstanceID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// invalidator is the invalidation state of a CoherencyStorage.
+type invalidator[KT any] struct {
+	bus         IInvalidationBus
+	instance    string
+	unsubscribe func()
+	now         func() time.Time
+
+	// clock is the last version issued or seen
+	clock atomic.Uint64
+	// versions is the last message received for each key, written under the cache lock of the key
+	versions *BoundedMemoryStorage[KT, invalidationVersion]
+}
+
+// invalidationVersion is the origin and the version of a received invalidation.
+type invalidationVersion struct {
+	Instance string
+	Version  uint64
+}
+
+// next returns a version above every version issued or seen.
+func (i *invalidator[KT]) next() uint64 {
+	for {
+		last := i.clock.Load()
+		version := max(uint64(i.now().UnixNano()), last+1)
+		if i.clock.CompareAndSwap(last, version) {
+			return version
+		}
+	}
+}
+
+// observe keeps the clock above version.
+func (i *invalidator[KT]) observe(version uint64) {
+	for {
+		last := i.clock.Load()
+		if version <= last || i.clock.CompareAndSwap(last, version) {
+			return
+		}
+	}
+}
+
+// receive records msg as the last message of key, it reports false if msg is a duplicate
+// or a reordered message of the origin of the last message, it must be called with the cache lock of key.
+func (i *invalidator[KT]) receive(ctx context.Context, key *KT, msg InvalidationMessage) bool {
+	last, err := i.versions.Get(ctx, key, i.versions.Timeout())
+	if err == nil && last.Instance == msg.Instance && last.Version >= msg.Version {
+		return false
+	}
+	_ = i.versions.Set(ctx, key, &invalidationVersion{Instance: msg.Instance, Version: msg.Version}, i.versions.Timeout())
+	return true
+}
+
+// newVersion issues the version of a local write.
+func (c *CoherencyStorage[KT, VT]) newVersion() uint64 {
+	if c.invalidator == nil {
+		return 0
+	}
+	return c.invalidator.next()
+}
+
+// publish sends the invalidation of a local write of key.
+func (c *CoherencyStorage[KT, VT]) publish(ctx context.Context, key *KT, version uint64) error {
+	if c.invalidator == nil {
+		return nil
+	}
+	encodedKey, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return c.invalidator.bus.Publish(ctx, InvalidationMessage{
+		Instance: c.invalidator.instance,
+		Key:      encodedKey,
+		Version:  version,
+	})
+}
+
+// invalidate drops the key of msg from the cache unless it is a duplicate or reordered by its origin.
+func (c *CoherencyStorage[KT, VT]) invalidate(msg InvalidationMessage) {
+	if msg.Instance == c.invalidator.instance {
+		return
+	}
+	var key KT
+	if err := json.Unmarshal(msg.Key, &key); err != nil {
+		return
+	}
+	c.invalidator.observe(msg.Version)
+
+	lockCtx, cancel := context.WithTimeout(context.Background(), c.cache.Timeout())
+	defer cancel()
+	ctx, unlock, err := c.lockKey(lockCtx, c.cache, &key)
+	if err != nil {
+		// dropping the key without the lock is safe, its version is not updated
+		_ = c.cache.Del(context.Background(), &key, c.cache.Timeout())
+		c.meta.Delete(any(key))
+		return
+	}
+	defer unlock()
+
+	if !c.invalidator.receive(ctx, &key, msg) {
+		return
+	}
+	_ = c.cache.Del(ctx, &key, c.cache.Timeout())
+	c.meta.Delete(any(key))
+	_ = c.delTombstone(ctx, &key)
+}
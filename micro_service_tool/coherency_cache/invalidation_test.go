@@ -0,0 +1,240 @@
+package coherency_cache
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInvalidation(t *testing.T) {
+	t.Run("Local Bus", TestLocalInvalidation)
+	t.Run("Out Of Order", TestInvalidationOutOfOrder)
+	t.Run("UDP Bus", TestUDPInvalidation)
+	t.Run("UDP Signed Messages", TestUDPInvalidationSecret)
+	t.Run("UDP Interface", TestUDPInvalidationInterface)
+}
+
+// newInstance creates a CoherencyStorage with its own cache in front of source.
+func newInstance(
+	source IStorage[string, string], bus IInvalidationBus, id string,
+) (*CoherencyStorage[string, string], *MemoryStorage[string, string]) {
+	cache := NewMemoryStorage[string, string]()
+	return NewCoherencyStorageWithConfig[string, string](cache, source, CoherencyConfig{
+		Invalidation: bus,
+		InstanceID:   id,
+	}), cache
+}
+
+func TestLocalInvalidation(t *testing.T) {
+	source := NewMemoryStorage[string, string]()
+	bus := NewLocalInvalidationBus()
+	a, _ := newInstance(source, bus, "a")
+	b, bCache := newInstance(source, bus, "b")
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	key, oldValue, newValue := "key", "old", "new"
+	if err := a.Set(context.Background(), &key, &oldValue, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	if getValue, _ := b.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != oldValue {
+		t.Fatal("Expected old value on b, got:", getValue)
+	}
+
+	// A Set on a drops the key from the cache of b
+	if err := a.Set(context.Background(), &key, &newValue, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	if _, err := bCache.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected key invalidated on b, got:", err)
+	}
+	if getValue, _ := b.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != newValue {
+		t.Fatal("Expected new value on b, got:", getValue)
+	}
+
+	// So does a Del
+	if err := a.Del(context.Background(), &key, time.Second); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	if _, err := b.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected key deleted on b, got:", err)
+	}
+
+	// After Close, b does not receive invalidations
+	_ = b.Close(context.Background())
+	_ = b.Set(context.Background(), &key, &oldValue, time.Second)
+	if err := a.Set(context.Background(), &key, &newValue, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	if getValue, _ := bCache.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != oldValue {
+		t.Fatal("Expected b unsubscribed, got:", getValue)
+	}
+}
+
+func TestInvalidationOutOfOrder(t *testing.T) {
+	source := NewMemoryStorage[string, string]()
+	bus := NewLocalInvalidationBus()
+	storage, cache := newInstance(source, bus, "a")
+	defer storage.Close(context.Background())
+
+	key, value := "key", "value"
+	encodedKey, _ := json.Marshal(key)
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+
+	cached := func() bool {
+		getValue, _ := cache.Get(context.Background(), &key, time.Second)
+		return getValue != nil
+	}
+
+	// clocks of instances are not comparable, an invalidation older than the local write is applied
+	_ = bus.Publish(context.Background(), InvalidationMessage{Instance: "b", Key: encodedKey, Version: 1})
+	if cached() {
+		t.Fatal("Expected an invalidation of another instance applied")
+	}
+
+	// a duplicate or a reordered message of the same origin is dropped
+	newer := InvalidationMessage{Instance: "b", Key: encodedKey, Version: storage.invalidator.clock.Load() + 10}
+	_ = bus.Publish(context.Background(), newer)
+	for _, version := range []uint64{newer.Version, newer.Version - 1} {
+		_ = cache.Set(context.Background(), &key, &value, time.Second)
+		_ = bus.Publish(context.Background(), InvalidationMessage{Instance: "b", Key: encodedKey, Version: version})
+		if !cached() {
+			t.Fatal("Expected the duplicate or reordered invalidation dropped:", version)
+		}
+	}
+
+	// a message of another origin is applied whatever its version
+	_ = bus.Publish(context.Background(), InvalidationMessage{Instance: "c", Key: encodedKey, Version: 2})
+	if cached() {
+		t.Fatal("Expected an invalidation of another origin applied")
+	}
+
+	// Local writes after it get newer versions
+	if version := storage.invalidator.next(); version <= newer.Version {
+		t.Fatal("Expected version above the seen version, got:", version)
+	}
+}
+
+func TestUDPInvalidation(t *testing.T) {
+	aBus, err := NewUDPInvalidationBus("127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer aBus.Close()
+	bBus, err := NewUDPInvalidationBus("127.0.0.1:0", aBus.Addr().String())
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer bBus.Close()
+	if err := aBus.AddPeer(bBus.Addr().String()); err != nil {
+		t.Fatal("AddPeer failed:", err)
+	}
+
+	source := NewMemoryStorage[string, string]()
+	a, aCache := newInstance(source, aBus, "a")
+	b, bCache := newInstance(source, bBus, "b")
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	key, oldValue, newValue := "key", "old", "new"
+	_ = source.Set(context.Background(), &key, &oldValue, time.Second)
+	_, _ = a.Get(context.Background(), &key, time.Second)
+	_, _ = b.Get(context.Background(), &key, time.Second)
+
+	waitInvalidated := func(cache *MemoryStorage[string, string]) {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := cache.Get(context.Background(), &key, time.Second); IsNotFound(err) {
+				return
+			}
+			time.Sleep(time.Millisecond * 5)
+		}
+		t.Fatal("Expected key invalidated over UDP")
+	}
+
+	if err := a.Set(context.Background(), &key, &newValue, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	waitInvalidated(bCache)
+	if getValue, _ := b.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != newValue {
+		t.Fatal("Expected new value on b, got:", getValue)
+	}
+
+	if err := b.Del(context.Background(), &key, time.Second); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	waitInvalidated(aCache)
+}
+
+func TestUDPInvalidationSecret(t *testing.T) {
+	receiver, err := NewUDPInvalidationBusWithConfig(UDPInvalidationConfig{
+		ListenAddr: "127.0.0.1:0",
+		Secret:     []byte("secret"),
+	})
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer receiver.Close()
+	var received atomic.Int64
+	receiver.Subscribe(func(msg InvalidationMessage) {
+		received.Add(int64(msg.Version))
+	})
+
+	for _, secret := range []string{"", "other", "secret"} {
+		sender, err := NewUDPInvalidationBusWithConfig(UDPInvalidationConfig{
+			ListenAddr: "127.0.0.1:0",
+			Peers:      []string{receiver.Addr().String()},
+			Secret:     []byte(secret),
+		})
+		if err != nil {
+			t.Fatal("Failed to listen:", err)
+		}
+		// the version tells the senders apart
+		version := uint64(len(secret) + 1)
+		if err := sender.Publish(context.Background(), InvalidationMessage{Key: json.RawMessage(`"key"`), Version: version}); err != nil {
+			t.Fatal("Publish failed:", err)
+		}
+		_ = sender.Close()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for received.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	// datagrams are received in order on the loopback, the signed one is the last
+	time.Sleep(time.Millisecond * 20)
+	if versions := received.Load(); versions != int64(len("secret")+1) {
+		t.Fatal("Expected only the message signed with the secret, got versions:", versions)
+	}
+}
+
+func TestUDPInvalidationInterface(t *testing.T) {
+	interfaces, _ := net.Interfaces()
+	var loopback *net.Interface
+	for i := range interfaces {
+		if interfaces[i].Flags&net.FlagLoopback != 0 {
+			loopback = &interfaces[i]
+		}
+	}
+	if loopback == nil {
+		t.Skip("No loopback interface")
+	}
+
+	bus, err := NewUDPInvalidationBusWithConfig(UDPInvalidationConfig{ListenAddr: ":0", Interface: loopback.Name})
+	if err != nil {
+		t.Fatal("Failed to listen on the loopback interface:", err)
+	}
+	defer bus.Close()
+	if ip := bus.Addr().(*net.UDPAddr).IP; !ip.IsLoopback() {
+		t.Fatal("Expected the bus bound to the loopback interface, got:", ip)
+	}
+
+	if _, err := NewUDPInvalidationBusWithConfig(UDPInvalidationConfig{ListenAddr: ":0", Interface: "missing0"}); err == nil {
+		t.Fatal("Expected an error for a missing interface")
+	}
+}
@@ -0,0 +1,219 @@
+package coherency_cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// udpMaxMessageSize bounds a message to one UDP datagram.
+const udpMaxMessageSize = 64 * 1024
+
+/*
+UDPInvalidationBus:
+    1. UDPInvalidationBus sends each message as one JSON datagram to every peer,
+        and passes the datagrams received on its listen address to the handlers.
+    2. Peers are host:port addresses of the other instances, a broadcast or multicast address
+        reaches several instances. Peers may include the bus itself, its own messages are
+        ignored by the CoherencyStorage.
+    3. UDP may lose or reorder datagrams, see IInvalidationBus.
+    4. Anyone who can send a datagram to the listen address can drop keys from the cache,
+        so the bus must only be reachable from a trusted network:
+        - Interface or the host of ListenAddr binds it to the interface of that network.
+        - Secret signs every datagram with HMAC-SHA256, datagrams without a valid signature
+            are dropped. All the instances must share the secret. A captured datagram can still
+            be replayed, which only drops a key once more.
+*/
+// UDPInvalidationConfig configures a UDPInvalidationBus.
+type UDPInvalidationConfig struct {
+	// ListenAddr is the host:port to listen on, ":0" picks a free port on every interface.
+	ListenAddr string
+	// Interface is the name of a network interface, like eth1, the bus listens on its first
+	// address with the port of ListenAddr. Empty means the host of ListenAddr.
+	Interface string
+	// Peers are the host:port addresses messages are sent to.
+	Peers []string
+	// Secret is the HMAC-SHA256 key of the datagrams, empty sends them unsigned.
+	Secret []byte
+}
+
+// UDPInvalidationBus is an IInvalidationBus over UDP.
+type UDPInvalidationBus struct {
+	LocalInvalidationBus
+
+	conn   *net.UDPConn
+	secret []byte
+
+	peersMu sync.RWMutex
+	peers   []*net.UDPAddr
+
+	closeOnce sync.Once
+}
+
+// NewUDPInvalidationBus listens on listenAddr, ":0" picks a free port, and sends unsigned messages to peers.
+func NewUDPInvalidationBus(listenAddr string, peers ...string) (*UDPInvalidationBus, error) {
+	return NewUDPInvalidationBusWithConfig(UDPInvalidationConfig{ListenAddr: listenAddr, Peers: peers})
+}
+
+// NewUDPInvalidationBusWithConfig listens on the address of config and sends to its peers.
+func NewUDPInvalidationBusWithConfig(config UDPInvalidationConfig) (*UDPInvalidationBus, error) {
+	listenAddr, err := udpListenAddr(config)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b := &UDPInvalidationBus{
+		LocalInvalidationBus: LocalInvalidationBus{
+			handlers: map[int]func(msg InvalidationMessage){},
+		},
+		conn:   conn,
+		secret: config.Secret,
+	}
+	for _, peer := range config.Peers {
+		if err := b.AddPeer(peer); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	go b.receive()
+	return b, nil
+}
+
+// udpListenAddr returns ListenAddr, with the host replaced by the address of Interface if it is set.
+func udpListenAddr(config UDPInvalidationConfig) (string, error) {
+	if config.Interface == "" {
+		return config.ListenAddr, nil
+	}
+	_, port, err := net.SplitHostPort(config.ListenAddr)
+	if err != nil {
+		return "", err
+	}
+	iface, err := net.InterfaceByName(config.Interface)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			return net.JoinHostPort(ipNet.IP.String(), port), nil
+		}
+	}
+	return "", fmt.Errorf("no address on interface %q", config.Interface)
+}
+
+// Addr returns the listen address.
+func (b *UDPInvalidationBus) Addr() net.Addr {
+	return b.conn.LocalAddr()
+}
+
+// AddPeer adds an address messages are sent to.
+func (b *UDPInvalidationBus) AddPeer(peer string) error {
+	addr, err := net.ResolveUDPAddr("udp", peer)
+	if err != nil {
+		return err
+	}
+	b.peersMu.Lock()
+	defer b.peersMu.Unlock()
+	b.peers = append(b.peers, addr)
+	return nil
+}
+
+// Publish sends msg to every peer, it returns the errors of the peers which could not be sent to.
+func (b *UDPInvalidationBus) Publish(ctx context.Context, msg InvalidationMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = b.sign(data)
+	if len(data) > udpMaxMessageSize {
+		return errors.New("coherency_cache: invalidation message too large for UDP")
+	}
+
+	b.peersMu.RLock()
+	peers := b.peers
+	b.peersMu.RUnlock()
+
+	var errs []error
+	for _, peer := range peers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := b.conn.WriteToUDP(data, peer); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *UDPInvalidationBus) receive() {
+	buffer := make([]byte, udpMaxMessageSize)
+	for {
+		n, _, err := b.conn.ReadFromUDP(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		data, ok := b.verify(buffer[:n])
+		if !ok {
+			continue
+		}
+		var msg InvalidationMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		// LocalInvalidationBus.Publish passes msg to the handlers
+		_ = b.LocalInvalidationBus.Publish(context.Background(), msg)
+	}
+}
+
+// sign prepends the HMAC of data if the bus has a secret.
+func (b *UDPInvalidationBus) sign(data []byte) []byte {
+	if len(b.secret) == 0 {
+		return data
+	}
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write(data)
+	return append(mac.Sum(nil), data...)
+}
+
+// verify checks and strips the HMAC of a datagram if the bus has a secret.
+func (b *UDPInvalidationBus) verify(datagram []byte) ([]byte, bool) {
+	if len(b.secret) == 0 {
+		return datagram, true
+	}
+	if len(datagram) < sha256.Size {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write(datagram[sha256.Size:])
+	if !hmac.Equal(mac.Sum(nil), datagram[:sha256.Size]) {
+		return nil, false
+	}
+	return datagram[sha256.Size:], true
+}
+
+// Close stops listening.
+func (b *UDPInvalidationBus) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		err = b.conn.Close()
+	})
+	return err
+}
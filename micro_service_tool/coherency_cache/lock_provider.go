@@ -0,0 +1,228 @@
+package coherency_cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLockTTL is the default CoherencyConfig.LockTTL.
+	DefaultLockTTL = time.Second * 10
+	// DefaultLockRetryInterval is the interval of AcquireLock between two TryAcquire.
+	DefaultLockRetryInterval = time.Millisecond * 10
+	// MinLockTTL is the smallest CoherencyConfig.LockTTL, leases are renewed every LockTTL / 3
+	// and Redis expirations are in milliseconds.
+	MinLockTTL = time.Millisecond * 3
+)
+
+var (
+	// ErrLockHeld is returned by TryAcquire when another owner holds the lock.
+	ErrLockHeld = errors.New("coherency_cache: lock held")
+	// ErrLockLost is returned by Renew and Release when the lease expired or the lock has another owner.
+	ErrLockLost = errors.New("coherency_cache: lock lost")
+	// ErrFenced is returned by the writes of a fencing storage whose fencing token is older
+	// than the last token written to the key.
+	ErrFenced = errors.New("coherency_cache: write fenced by a newer lock")
+)
+
+// Check if providers implement ILockProvider
+var (
+	_ ILockProvider = (*MemoryLockProvider)(nil)
+	_ ILockProvider = (*RedisLockProvider)(nil)
+)
+
+/*
+ILockProvider:
+    1. ILockProvider grants named locks as leases, which expire after their TTL unless renewed,
+        so the lock of a crashed owner is freed.
+    2. Each grant carries a fencing token larger than every previous token of the provider.
+        An owner whose lease expired while it was paused may still write,
+        a storage comparing tokens (see FenceFromContext) rejects such late writes with ErrFenced:
+        RedisStorageConfig.Fencing, SQLStorageConfig.Fenced and SQLTableConfig.FenceColumn.
+    3. With CoherencyConfig.LockProvider, CoherencyStorage locks each key with one lease
+        named LockPrefix + fmt.Sprint(key), instead of the process-local locks of its layers:
+        - Loads, Sets and Dels of a key are serialized across instances sharing the provider.
+        - The lease is renewed every LockTTL / 3 while held, if a renewal fails the ctx
+            of the operation is cancelled with ErrLockLost.
+        - The ctx passed to the layers carries the fencing token.
+        - Nested CoherencyStorages sharing a provider need different LockPrefix,
+            otherwise the inner storage waits for the lease of the outer one.
+*/
+// ILockProvider is a lock service with leases and fencing tokens.
+type ILockProvider interface {
+	// TryAcquire takes the lock of name for ttl, it returns ErrLockHeld if it is held.
+	TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error)
+	// Renew extends lease for ttl from now.
+	Renew(ctx context.Context, lease *Lease, ttl time.Duration) error
+	Release(ctx context.Context, lease *Lease) error
+}
+
+// Lease is a granted lock.
+type Lease struct {
+	Name string
+	// Token identifies the owner of the lease
+	Token string
+	// Fence is the fencing token
+	Fence uint64
+}
+
+// AcquireLock waits until the lock of name is taken or ctx is done.
+func AcquireLock(
+	ctx context.Context, provider ILockProvider, name string, ttl time.Duration,
+) (*Lease, error) {
+	for {
+		lease, err := provider.TryAcquire(ctx, name, ttl)
+		if !errors.Is(err, ErrLockHeld) {
+			return lease, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(DefaultLockRetryInterval):
+		}
+	}
+}
+
+type fenceKey struct{}
+
+// WithFence returns a ctx carrying the fencing token of a lease.
+func WithFence(ctx context.Context, fence uint64) context.Context {
+	return context.WithValue(ctx, fenceKey{}, fence)
+}
+
+// FenceFromContext returns the fencing token of the lease the operation runs under.
+func FenceFromContext(ctx context.Context) (uint64, bool) {
+	fence, ok := ctx.Value(fenceKey{}).(uint64)
+	return fence, ok
+}
+
+func newLeaseToken() string {
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// lockKey locks key with the LockProvider if it is set, otherwise with the lock of layer.
+// The returned ctx is the ctx of the operations under the lock, unlock must be called once done.
+func (c *CoherencyStorage[KT, VT]) lockKey(
+	ctx context.Context, layer IStorage[KT, VT], key *KT,
+) (context.Context, func(), error) {
+	provider := c.config.LockProvider
+	if provider == nil {
+		if err := layer.Lock(ctx, key); err != nil {
+			return ctx, nil, err
+		}
+		return ctx, func() { layer.Unlock(ctx, key) }, nil
+	}
+
+	ttl := c.config.LockTTL
+	lease, err := AcquireLock(ctx, provider, c.config.LockPrefix+fmt.Sprint(*key), ttl)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	lockCtx, cancel := context.WithCancelCause(WithFence(ctx, lease.Fence))
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				renewCtx, cancelRenew := context.WithTimeout(context.Background(), ttl/3)
+				err := provider.Renew(renewCtx, lease, ttl)
+				cancelRenew()
+				if err != nil {
+					cancel(fmt.Errorf("%w: %v", ErrLockLost, err))
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return lockCtx, func() {
+		once.Do(func() {
+			close(stop)
+			<-renewed
+			cancel(nil)
+			releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), ttl/3)
+			defer cancelRelease()
+			_ = provider.Release(releaseCtx, lease)
+		})
+	}, nil
+}
+
+// MemoryLockProvider is an in-process ILockProvider, for tests and single instances.
+type MemoryLockProvider struct {
+	mu    sync.Mutex
+	locks map[string]*memoryLock
+	fence uint64
+	now   func() time.Time
+}
+
+type memoryLock struct {
+	token    string
+	expireAt time.Time
+}
+
+func NewMemoryLockProvider() *MemoryLockProvider {
+	return &MemoryLockProvider{
+		locks: map[string]*memoryLock{},
+		now:   time.Now,
+	}
+}
+
+func (m *MemoryLockProvider) TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if lock, ok := m.locks[name]; ok && now.Before(lock.expireAt) {
+		return nil, ErrLockHeld
+	}
+	token := newLeaseToken()
+	m.locks[name] = &memoryLock{token: token, expireAt: now.Add(ttl)}
+	m.fence++
+	return &Lease{Name: name, Token: token, Fence: m.fence}, nil
+}
+
+func (m *MemoryLockProvider) Renew(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	lock, ok := m.locks[lease.Name]
+	if !ok || lock.token != lease.Token || !now.Before(lock.expireAt) {
+		return ErrLockLost
+	}
+	lock.expireAt = now.Add(ttl)
+	return nil
+}
+
+func (m *MemoryLockProvider) Release(ctx context.Context, lease *Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.locks[lease.Name]
+	if !ok || lock.token != lease.Token {
+		return ErrLockLost
+	}
+	delete(m.locks, lease.Name)
+	if !m.now().Before(lock.expireAt) {
+		return ErrLockLost
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package coherency_cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	// redisAcquireScript sets the lock if it is free and issues a fencing token.
+	redisAcquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+	// redisRenewScript extends the lock if it is still owned by the token.
+	redisRenewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	// redisReleaseScript deletes the lock if it is still owned by the token.
+	redisReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+/*
+RedisLockProvider:
+    1. The lock of a name is the Redis key Prefix + "lock:" + name holding the token of its owner,
+        with the lease TTL as Redis expiration.
+    2. Fencing tokens come from INCR of the key Prefix + "{fence}:counter", shared by every name,
+        which no lock key can collide with.
+    3. Acquire, Renew and Release are Lua scripts, so checking the owner and changing the key are atomic.
+        A single Redis is assumed, locks are not safe across a failover of a replicated Redis.
+*/
+// RedisLockProvider is an ILockProvider backed by Redis.
+type RedisLockProvider struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisLockProvider(client *redis.Client, prefix string) *RedisLockProvider {
+	return &RedisLockProvider{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (r *RedisLockProvider) lockKey(name string) string {
+	return r.prefix + "lock:" + name
+}
+
+func (r *RedisLockProvider) TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	token := newLeaseToken()
+	fence, err := redisAcquireScript.Run(
+		r.client.WithContext(ctx), []string{r.lockKey(name), r.prefix + "{fence}:counter"}, token, ttl.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if fence == 0 {
+		return nil, ErrLockHeld
+	}
+	return &Lease{Name: name, Token: token, Fence: uint64(fence)}, nil
+}
+
+func (r *RedisLockProvider) Renew(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	return r.run(ctx, redisRenewScript, lease, ttl.Milliseconds())
+}
+
+func (r *RedisLockProvider) Release(ctx context.Context, lease *Lease) error {
+	return r.run(ctx, redisReleaseScript, lease)
+}
+
+// run runs a script checking the owner of lease, it returns ErrLockLost if the script returns 0.
+func (r *RedisLockProvider) run(ctx context.Context, script *redis.Script, lease *Lease, args ...any) error {
+	result, err := script.Run(
+		r.client.WithContext(ctx), []string{r.lockKey(lease.Name)}, append([]any{lease.Token}, args...)...,
+	).Int64()
+	if err != nil {
+		return err
+	}
+	if result == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
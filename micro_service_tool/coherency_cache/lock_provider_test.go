@@ -0,0 +1,296 @@
+package coherency_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+// newTestRedis starts an in-process Redis stand-in, closed when the test ends.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: 0})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+// instanceStorage gives a storage shared by several instances its own process-local locks.
+type instanceStorage[KT any, VT any] struct {
+	IStorage[KT, VT]
+	locks *BaseStorage[KT]
+}
+
+func newInstanceStorage[KT any, VT any](storage IStorage[KT, VT]) *instanceStorage[KT, VT] {
+	return &instanceStorage[KT, VT]{IStorage: storage, locks: &BaseStorage[KT]{}}
+}
+
+func (s *instanceStorage[KT, VT]) Lock(ctx context.Context, key *KT) error {
+	return s.locks.Lock(ctx, key)
+}
+
+func (s *instanceStorage[KT, VT]) LockWithTimeout(ctx context.Context, key *KT, timeout time.Duration) error {
+	return s.locks.LockWithTimeout(ctx, key, timeout)
+}
+
+func (s *instanceStorage[KT, VT]) TryLock(ctx context.Context, key *KT) bool {
+	return s.locks.TryLock(ctx, key)
+}
+
+func (s *instanceStorage[KT, VT]) Unlock(ctx context.Context, key *KT) {
+	s.locks.Unlock(ctx, key)
+}
+
+// lateStorage is a MemoryStorage whose Get returns the value read before a delay.
+type lateStorage[KT any, VT any] struct {
+	*MemoryStorage[KT, VT]
+	delay time.Duration
+}
+
+func (s *lateStorage[KT, VT]) Get(ctx context.Context, key *KT, timeout time.Duration) (*VT, error) {
+	value, err := s.MemoryStorage.Get(ctx, key, timeout)
+	time.Sleep(s.delay)
+	return value, err
+}
+
+// fencedStorage is a MemoryStorage rejecting writes with an older fencing token than the last write.
+type fencedStorage[KT any, VT any] struct {
+	*MemoryStorage[KT, VT]
+	delay time.Duration
+
+	mu        sync.Mutex
+	lastFence uint64
+	// cause is the cause of the cancellation of the ctx of the last write
+	cause error
+}
+
+var errStaleFence = errors.New("stale fencing token")
+
+func (s *fencedStorage[KT, VT]) Set(ctx context.Context, key *KT, value *VT, timeout time.Duration) error {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.cause = context.Cause(ctx)
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+
+	fence, _ := FenceFromContext(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fence <= s.lastFence {
+		return errStaleFence
+	}
+	s.lastFence = fence
+	return s.MemoryStorage.Set(ctx, key, value, timeout)
+}
+
+func TestLockProvider(t *testing.T) {
+	t.Run("Memory Provider", TestMemoryLockProvider)
+	t.Run("Redis Provider", TestRedisLockProvider)
+	t.Run("Coherency Across Instances", TestLockProviderCoherency)
+	t.Run("Fencing Token", TestLockProviderFencing)
+	t.Run("Lost Lease", TestLockProviderLostLease)
+	t.Run("Lock TTL", TestLockTTLValidation)
+}
+
+// testLockProvider checks the contract of ILockProvider, expire makes the leases held expire.
+func testLockProvider(t *testing.T, provider ILockProvider, expire func(d time.Duration)) {
+	ctx := context.Background()
+	lease, err := provider.TryAcquire(ctx, "key", time.Second)
+	if err != nil {
+		t.Fatal("TryAcquire failed:", err)
+	}
+	if _, err := provider.TryAcquire(ctx, "key", time.Second); !errors.Is(err, ErrLockHeld) {
+		t.Fatal("Expected ErrLockHeld, got:", err)
+	}
+	other, err := provider.TryAcquire(ctx, "other", time.Second)
+	if err != nil || other.Fence <= lease.Fence {
+		t.Fatal("Expected a larger fencing token for another name, got:", other, err)
+	}
+
+	// Renew keeps the lease past its first TTL
+	if err := provider.Renew(ctx, lease, time.Second*3); err != nil {
+		t.Fatal("Renew failed:", err)
+	}
+	expire(time.Second * 2)
+	if _, err := provider.TryAcquire(ctx, "key", time.Second); !errors.Is(err, ErrLockHeld) {
+		t.Fatal("Expected renewed lease held, got:", err)
+	}
+	if err := provider.Release(ctx, lease); err != nil {
+		t.Fatal("Release failed:", err)
+	}
+	if err := provider.Release(ctx, lease); !errors.Is(err, ErrLockLost) {
+		t.Fatal("Expected ErrLockLost on a second Release, got:", err)
+	}
+
+	// An expired lease is lost, the next owner gets a larger fencing token
+	lease, _ = provider.TryAcquire(ctx, "key", time.Second)
+	expire(time.Second * 2)
+	next, err := provider.TryAcquire(ctx, "key", time.Second)
+	if err != nil || next.Fence <= lease.Fence {
+		t.Fatal("Expected the expired lock taken with a larger fencing token, got:", next, err)
+	}
+	if err := provider.Renew(ctx, lease, time.Second); !errors.Is(err, ErrLockLost) {
+		t.Fatal("Expected ErrLockLost renewing an expired lease, got:", err)
+	}
+	if err := provider.Release(ctx, lease); !errors.Is(err, ErrLockLost) {
+		t.Fatal("Expected ErrLockLost releasing an expired lease, got:", err)
+	}
+	if _, err := provider.TryAcquire(ctx, "key", time.Second); !errors.Is(err, ErrLockHeld) {
+		t.Fatal("Expected the lock of the next owner kept, got:", err)
+	}
+}
+
+func TestMemoryLockProvider(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	provider := NewMemoryLockProvider()
+	provider.now = clock.Now
+	testLockProvider(t, provider, clock.Advance)
+}
+
+func TestRedisLockProvider(t *testing.T) {
+	server, client := newTestRedis(t)
+	testLockProvider(t, NewRedisLockProvider(client, "lock:"), server.FastForward)
+
+	// AcquireLock waits for the release
+	provider := NewRedisLockProvider(client, "wait:")
+	lease, _ := provider.TryAcquire(context.Background(), "key", time.Minute)
+	go func() {
+		time.Sleep(time.Millisecond * 30)
+		_ = provider.Release(context.Background(), lease)
+	}()
+	if _, err := AcquireLock(context.Background(), provider, "key", time.Minute); err != nil {
+		t.Fatal("AcquireLock failed:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*30)
+	defer cancel()
+	if _, err := AcquireLock(ctx, provider, "key", time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected DeadlineExceeded, got:", err)
+	}
+
+	// the names of locks cannot collide with the fencing counter
+	provider = NewRedisLockProvider(client, "names:")
+	for _, name := range []string{"fence", "{fence}:counter", "other"} {
+		if _, err := provider.TryAcquire(context.Background(), name, time.Minute); err != nil {
+			t.Fatal("TryAcquire failed for lock", name, err)
+		}
+	}
+	if counter, _ := server.Get("names:{fence}:counter"); counter != "3" || !server.Exists("names:lock:fence") {
+		t.Fatal("Expected 3 fencing tokens and lock keys under lock:, got:", server.Keys())
+	}
+}
+
+func TestLockProviderCoherency(t *testing.T) {
+	sharedCache := NewMemoryStorage[string, string]()
+	sharedSource := &lateStorage[string, string]{
+		MemoryStorage: NewMemoryStorage[string, string](),
+		delay:         time.Millisecond * 50,
+	}
+	provider := NewMemoryLockProvider()
+	newStorage := func() *CoherencyStorage[string, string] {
+		return NewCoherencyStorageWithConfig[string, string](
+			newInstanceStorage[string, string](sharedCache),
+			newInstanceStorage[string, string](sharedSource),
+			CoherencyConfig{LockProvider: provider},
+		)
+	}
+	a, b := newStorage(), newStorage()
+
+	key, oldValue, newValue := "key", "old", "new"
+	_ = sharedSource.MemoryStorage.Set(context.Background(), &key, &oldValue, time.Second)
+
+	// b loads the old value while a writes the new one, the lease orders them
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		_, _ = b.Get(context.Background(), &key, time.Second)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	if err := a.Set(context.Background(), &key, &newValue, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	<-loaded
+
+	if getValue, _ := sharedCache.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != newValue {
+		t.Fatal("Expected the shared cache to hold the new value, got:", getValue)
+	}
+}
+
+func TestLockProviderFencing(t *testing.T) {
+	source := &fencedStorage[string, string]{MemoryStorage: NewMemoryStorage[string, string]()}
+	provider := NewMemoryLockProvider()
+	storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), source, CoherencyConfig{
+		LockProvider: provider,
+	})
+
+	key, value := "key", "value"
+	for i := 0; i < 3; i++ {
+		if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+			t.Fatal("Set failed:", err)
+		}
+	}
+
+	// A writer with an older lease is rejected by the source
+	ctx := WithFence(context.Background(), source.lastFence-1)
+	if err := source.Set(ctx, &key, &value, time.Second); !errors.Is(err, errStaleFence) {
+		t.Fatal("Expected stale fencing token rejected, got:", err)
+	}
+}
+
+func TestLockProviderLostLease(t *testing.T) {
+	source := &fencedStorage[string, string]{
+		MemoryStorage: NewMemoryStorage[string, string](),
+		delay:         time.Second,
+	}
+	provider := NewMemoryLockProvider()
+	storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), source, CoherencyConfig{
+		LockProvider: provider,
+		LockTTL:      time.Millisecond * 30,
+	})
+
+	// Another owner takes the lock while the source write is running
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		provider.mu.Lock()
+		delete(provider.locks, "key")
+		provider.mu.Unlock()
+	}()
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second*2); err == nil {
+		t.Fatal("Expected Set to fail after losing the lease")
+	}
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if !errors.Is(source.cause, ErrLockLost) {
+		t.Fatal("Expected the write cancelled with ErrLockLost, got:", source.cause)
+	}
+}
+
+func TestLockTTLValidation(t *testing.T) {
+	for _, ttl := range []time.Duration{time.Nanosecond * 2, time.Millisecond * 2, -time.Second} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Expected a panic for LockTTL", ttl)
+				}
+			}()
+			NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), NewMemoryStorage[string, string](), CoherencyConfig{
+				LockProvider: NewMemoryLockProvider(),
+				LockTTL:      ttl,
+			})
+		}()
+	}
+
+	storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), NewMemoryStorage[string, string](), CoherencyConfig{
+		LockProvider: NewMemoryLockProvider(),
+	})
+	if storage.config.LockTTL != DefaultLockTTL {
+		t.Fatal("Expected DefaultLockTTL, got:", storage.config.LockTTL)
+	}
+}
@@ -7,24 +7,94 @@ import (
 )
 
 var (
-	_ IStorage[any, any] = (*MemoryStorage[any, any])(nil)
+	_ IStorage[any, any]          = (*MemoryStorage[any, any])(nil)
+	_ ITTLStorage[any, any]       = (*MemoryStorage[any, any])(nil)
+	_ ITombstoneStorage[any, any] = (*MemoryStorage[any, any])(nil)
 )
 
+/*
+MemoryStorage:
+    1. Entries set with Set live for the default TTL, 0 means forever.
+        SetWithTTL overrides the default TTL of one entry.
+    2. Expired entries are dropped lazily on Get,
+        and by a background janitor every janitorInterval if it is > 0, stop it with Close.
+    3. Tombstones are entries without value, Get returns ErrTombstone for them.
+*/
+// MemoryStorage is an IStorage on a sync.Map.
 type MemoryStorage[KT any, VT any] struct {
 	BaseStorage[KT]
 
-	cache sync.Map
+	cache      sync.Map
+	defaultTTL time.Duration
+	now        func() time.Time
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type memoryEntry[VT any] struct {
+	value *VT
+	// expireAt is zero for entries without TTL
+	expireAt  time.Time
+	tombstone bool
+}
+
+func (e *memoryEntry[VT]) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
 }
 
 func NewMemoryStorage[KT any, VT any]() *MemoryStorage[KT, VT] {
-	return &MemoryStorage[KT, VT]{
-		BaseStorage: BaseStorage[KT]{
-			lockMap: sync.Map{},
-		},
-		cache: sync.Map{},
+	return NewMemoryStorageWithTTL[KT, VT](0, 0)
+}
+
+// NewMemoryStorageWithTTL creates a MemoryStorage with a default TTL,
+// and a janitor dropping expired entries every janitorInterval if it is > 0.
+func NewMemoryStorageWithTTL[KT any, VT any](
+	defaultTTL time.Duration, janitorInterval time.Duration,
+) *MemoryStorage[KT, VT] {
+	m := &MemoryStorage[KT, VT]{
+		cache:      sync.Map{},
+		defaultTTL: defaultTTL,
+		now:        time.Now,
+		closed:     make(chan struct{}),
+	}
+	if janitorInterval > 0 {
+		go m.janitor(janitorInterval)
+	}
+	return m
+}
+
+func (m *MemoryStorage[KT, VT]) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.closed:
+			return
+		case <-ticker.C:
+			m.DeleteExpired()
+		}
 	}
 }
 
+// DeleteExpired drops every expired entry.
+func (m *MemoryStorage[KT, VT]) DeleteExpired() {
+	now := m.now()
+	m.cache.Range(func(key, value any) bool {
+		if value.(*memoryEntry[VT]).expired(now) {
+			m.cache.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// Close stops the janitor.
+func (m *MemoryStorage[KT, VT]) Close() {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+	})
+}
+
 func (m *MemoryStorage[KT, VT]) Get(
 	ctx context.Context, key *KT, timeout time.Duration,
 ) (*VT, error) {
@@ -36,15 +106,44 @@ func (m *MemoryStorage[KT, VT]) Get(
 	}
 
 	if !ok {
-		return nil, nil
+		return nil, ErrNotFound
+	}
+	entry := value.(*memoryEntry[VT])
+	if entry.expired(m.now()) {
+		m.cache.CompareAndDelete(*key, value)
+		return nil, ErrNotFound
 	}
-	return value.(*VT), nil
+	if entry.tombstone {
+		return nil, ErrTombstone
+	}
+	return entry.value, nil
 }
 
 func (m *MemoryStorage[KT, VT]) Set(
 	ctx context.Context, key *KT, value *VT, timeout time.Duration,
 ) error {
-	m.cache.Store(*key, value)
+	return m.SetWithTTL(ctx, key, value, m.defaultTTL, timeout)
+}
+
+func (m *MemoryStorage[KT, VT]) SetWithTTL(
+	ctx context.Context, key *KT, value *VT, ttl time.Duration, timeout time.Duration,
+) error {
+	return m.set(ctx, key, &memoryEntry[VT]{value: value}, ttl)
+}
+
+func (m *MemoryStorage[KT, VT]) SetTombstone(
+	ctx context.Context, key *KT, ttl time.Duration, timeout time.Duration,
+) error {
+	return m.set(ctx, key, &memoryEntry[VT]{tombstone: true}, ttl)
+}
+
+func (m *MemoryStorage[KT, VT]) set(
+	ctx context.Context, key *KT, entry *memoryEntry[VT], ttl time.Duration,
+) error {
+	if ttl > 0 {
+		entry.expireAt = m.now().Add(ttl)
+	}
+	m.cache.Store(*key, entry)
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
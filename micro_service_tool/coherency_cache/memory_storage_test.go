@@ -0,0 +1,130 @@
+package coherency_cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryStorageTTL(t *testing.T) {
+	t.Run("Default TTL", TestMemoryStorageDefaultTTL)
+	t.Run("Per Entry TTL", TestMemoryStoragePerEntryTTL)
+	t.Run("Janitor", TestMemoryStorageJanitor)
+	t.Run("Coherency TTL", TestCoherencyStorageTTL)
+}
+
+func TestMemoryStorageDefaultTTL(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	storage := NewMemoryStorageWithTTL[string, string](time.Second, 0)
+	storage.now = clock.Now
+
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, storage.Timeout()); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+
+	clock.Advance(time.Millisecond * 999)
+	if getValue, _ := storage.Get(context.Background(), &key, storage.Timeout()); getValue == nil {
+		t.Fatal("Expected value before TTL")
+	}
+	clock.Advance(time.Millisecond)
+	if getValue, _ := storage.Get(context.Background(), &key, storage.Timeout()); getValue != nil {
+		t.Fatal("Expected nil after TTL, got:", *getValue)
+	}
+	if _, ok := storage.cache.Load(key); ok {
+		t.Fatal("Expected expired entry to be dropped on Get")
+	}
+}
+
+func TestMemoryStoragePerEntryTTL(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	storage := NewMemoryStorageWithTTL[string, string](time.Second, 0)
+	storage.now = clock.Now
+
+	short, long, forever := "short", "long", "forever"
+	value := "value"
+	_ = storage.SetWithTTL(context.Background(), &short, &value, time.Millisecond*100, storage.Timeout())
+	_ = storage.SetWithTTL(context.Background(), &long, &value, time.Hour, storage.Timeout())
+	// Without default TTL entries never expire
+	noTTL := NewMemoryStorage[string, string]()
+	noTTL.now = clock.Now
+	_ = noTTL.Set(context.Background(), &forever, &value, noTTL.Timeout())
+
+	clock.Advance(time.Minute)
+	if getValue, _ := storage.Get(context.Background(), &short, storage.Timeout()); getValue != nil {
+		t.Fatal("Expected short entry to expire")
+	}
+	if getValue, _ := storage.Get(context.Background(), &long, storage.Timeout()); getValue == nil {
+		t.Fatal("Expected long entry to outlive the default TTL")
+	}
+	if getValue, _ := noTTL.Get(context.Background(), &forever, noTTL.Timeout()); getValue == nil {
+		t.Fatal("Expected entry without TTL to stay")
+	}
+}
+
+func TestMemoryStorageJanitor(t *testing.T) {
+	storage := NewMemoryStorageWithTTL[string, string](time.Millisecond*10, time.Millisecond*10)
+	defer storage.Close()
+
+	key, value := "key", "value"
+	_ = storage.Set(context.Background(), &key, &value, storage.Timeout())
+
+	// The janitor drops the entry without any Get
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := storage.cache.Load(key); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected janitor to drop the expired entry")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestCoherencyStorageTTL(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	cache := NewMemoryStorage[string, string]()
+	cache.now = clock.Now
+	source := NewMemoryStorage[string, string]()
+	source.now = clock.Now
+	storage := NewCoherencyStorageWithConfig[string, string](cache, source, CoherencyConfig{
+		CacheTTL:  time.Second,
+		SourceTTL: time.Hour,
+	})
+
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+
+	// The cache expires first, Get reloads it from the source
+	clock.Advance(time.Minute)
+	if getValue, _ := cache.Get(context.Background(), &key, cache.Timeout()); getValue != nil {
+		t.Fatal("Expected cache entry to expire")
+	}
+	if getValue, _ := storage.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected value from the source, got:", getValue)
+	}
+	if getValue, _ := cache.Get(context.Background(), &key, cache.Timeout()); getValue == nil {
+		t.Fatal("Expected cache to be filled again")
+	}
+
+	// Then the source expires
+	clock.Advance(time.Hour)
+	if getValue, _ := storage.Get(context.Background(), &key, time.Second); getValue != nil {
+		t.Fatal("Expected nil after source TTL, got:", *getValue)
+	}
+}
@@ -0,0 +1,227 @@
+package coherency_cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	_ IStorage[any, any]          = (*RedisStorage[any, any])(nil)
+	_ ITTLStorage[any, any]       = (*RedisStorage[any, any])(nil)
+	_ ITombstoneStorage[any, any] = (*RedisStorage[any, any])(nil)
+)
+
+// redisTombstone is the value of tombstones, see ICodec.
+var redisTombstone = []byte("\x00tombstone")
+
+// DefaultFenceTTL is the default RedisStorageConfig.FenceTTL.
+const DefaultFenceTTL = time.Hour
+
+var (
+	// redisFencedSetScript sets KEYS[1] unless KEYS[2] holds a newer fencing token.
+	redisFencedSetScript = redis.NewScript(`
+local last = tonumber(redis.call("GET", KEYS[2]) or "0")
+if last > tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[1], "PX", ARGV[2])
+if ARGV[4] == "0" then
+	redis.call("SET", KEYS[1], ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[3], "PX", ARGV[4])
+end
+return 1`)
+	// redisFencedDelScript deletes KEYS[1] unless KEYS[2] holds a newer fencing token.
+	redisFencedDelScript = redis.NewScript(`
+local last = tonumber(redis.call("GET", KEYS[2]) or "0")
+if last > tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[1], "PX", ARGV[2])
+redis.call("DEL", KEYS[1])
+return 1`)
+)
+
+/*
+RedisStorage:
+    1. RedisStorage keeps values in Redis encoded by Codec, under Prefix + KeyFormatter(key).
+    2. A missing key is ErrNotFound. Set uses DefaultTTL, SetWithTTL its own TTL, as Redis expiration.
+    3. Lock and Unlock only lock in the current process, see ILockProvider for locks across instances.
+    4. With Fencing, a write whose ctx carries a fencing token (see FenceFromContext) keeps the token
+        for FenceTTL, and a write with an older token returns ErrFenced.
+        Keys are then "{" + Prefix + KeyFormatter(key) + "}" and tokens are kept in the key + ":fence",
+        so a value and its token share a Redis Cluster slot and no value key is a token key.
+        Writes without a token are not checked. FenceTTL must exceed the longest pause of a writer,
+        a token expired before the late write cannot reject it.
+*/
+// RedisStorageConfig configures a RedisStorage.
+type RedisStorageConfig[KT any] struct {
+	Prefix string
+	// Timeout is the default timeout of each Redis call.
+	Timeout time.Duration
+	// KeyFormatter formats keys, nil means fmt.Sprint.
+	KeyFormatter func(key *KT) string
+	// Codec encodes values, nil means JSONCodec.
+	Codec ICodec
+	// DefaultTTL is the TTL of Set, 0 means no expiration.
+	DefaultTTL time.Duration
+	// Fencing rejects writes with older fencing tokens than the last write of the key.
+	Fencing bool
+	// FenceTTL is how long the last fencing token of a key is kept, 0 means DefaultFenceTTL.
+	FenceTTL time.Duration
+}
+
+// RedisStorage is an IStorage backed by Redis.
+type RedisStorage[KT any, VT any] struct {
+	BaseStorage[KT]
+
+	client *redis.Client
+	config RedisStorageConfig[KT]
+}
+
+// NewRedisStorage creates a RedisStorage of JSON values, timeout is the default timeout of each Redis call.
+func NewRedisStorage[KT any, VT any](
+	client *redis.Client, prefix string, timeout time.Duration,
+) *RedisStorage[KT, VT] {
+	return NewRedisStorageWithConfig[KT, VT](client, RedisStorageConfig[KT]{
+		Prefix:  prefix,
+		Timeout: timeout,
+	})
+}
+
+func NewRedisStorageWithConfig[KT any, VT any](
+	client *redis.Client, config RedisStorageConfig[KT],
+) *RedisStorage[KT, VT] {
+	if config.KeyFormatter == nil {
+		config.KeyFormatter = func(key *KT) string {
+			return fmt.Sprint(*key)
+		}
+	}
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+	if config.FenceTTL <= 0 {
+		config.FenceTTL = DefaultFenceTTL
+	}
+	return &RedisStorage[KT, VT]{
+		client: client,
+		config: config,
+	}
+}
+
+func (r *RedisStorage[KT, VT]) redisKey(key *KT) string {
+	if r.config.Fencing {
+		// the hash tag keeps the value and its fencing token in one slot
+		return "{" + r.config.Prefix + r.config.KeyFormatter(key) + "}"
+	}
+	return r.config.Prefix + r.config.KeyFormatter(key)
+}
+
+func (r *RedisStorage[KT, VT]) Get(
+	ctx context.Context, key *KT, timeout time.Duration,
+) (*VT, error) {
+	subCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	content, err := r.client.WithContext(subCtx).Get(r.redisKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(content, redisTombstone) {
+		return nil, ErrTombstone
+	}
+
+	value := new(VT)
+	if err := r.config.Codec.Unmarshal(content, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (r *RedisStorage[KT, VT]) Set(
+	ctx context.Context, key *KT, value *VT, timeout time.Duration,
+) error {
+	return r.SetWithTTL(ctx, key, value, r.config.DefaultTTL, timeout)
+}
+
+// SetWithTTL sets the value with a Redis expiration, ttl <= 0 means no expiration.
+func (r *RedisStorage[KT, VT]) SetWithTTL(
+	ctx context.Context, key *KT, value *VT, ttl time.Duration, timeout time.Duration,
+) error {
+	subCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	content, err := r.config.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.set(subCtx, key, content, ttl)
+}
+
+func (r *RedisStorage[KT, VT]) SetTombstone(
+	ctx context.Context, key *KT, ttl time.Duration, timeout time.Duration,
+) error {
+	subCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return r.set(subCtx, key, redisTombstone, ttl)
+}
+
+// set writes content, checking the fencing token of ctx with Fencing.
+func (r *RedisStorage[KT, VT]) set(ctx context.Context, key *KT, content []byte, ttl time.Duration) error {
+	fence, ok := r.fence(ctx)
+	if !ok {
+		return r.client.WithContext(ctx).Set(r.redisKey(key), content, max(ttl, 0)).Err()
+	}
+	return r.runFenced(ctx, redisFencedSetScript, key, fence, content, max(ttl, 0).Milliseconds())
+}
+
+func (r *RedisStorage[KT, VT]) Del(
+	ctx context.Context, key *KT, timeout time.Duration,
+) error {
+	subCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fence, ok := r.fence(subCtx)
+	if !ok {
+		return r.client.WithContext(subCtx).Del(r.redisKey(key)).Err()
+	}
+	return r.runFenced(subCtx, redisFencedDelScript, key, fence)
+}
+
+// fence returns the fencing token of ctx if the storage checks them.
+func (r *RedisStorage[KT, VT]) fence(ctx context.Context) (uint64, bool) {
+	if !r.config.Fencing {
+		return 0, false
+	}
+	return FenceFromContext(ctx)
+}
+
+// runFenced runs a fenced write script, it returns ErrFenced if the script returns 0.
+func (r *RedisStorage[KT, VT]) runFenced(
+	ctx context.Context, script *redis.Script, key *KT, fence uint64, args ...any,
+) error {
+	redisKey := r.redisKey(key)
+	result, err := script.Run(
+		r.client.WithContext(ctx), []string{redisKey, redisKey + ":fence"},
+		append([]any{fence, r.config.FenceTTL.Milliseconds()}, args...)...,
+	).Int64()
+	if err != nil {
+		return err
+	}
+	if result == 0 {
+		return ErrFenced
+	}
+	return nil
+}
+
+func (r *RedisStorage[KT, VT]) Timeout() time.Duration {
+	return r.config.Timeout
+}
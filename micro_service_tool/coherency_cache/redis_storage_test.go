@@ -0,0 +1,255 @@
+package coherency_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type redisTestValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestRedisStorage(t *testing.T) {
+	t.Run("Basic Operations", TestRedisBasicOperations)
+	t.Run("Coherency With Redis Source", TestRedisCoherencyStorage)
+	t.Run("Redis Down", TestRedisDown)
+	t.Run("Tombstone", TestRedisTombstone)
+	t.Run("Codecs", TestRedisCodecs)
+	t.Run("Key Formatter And Default TTL", TestRedisKeyFormatter)
+	t.Run("Fencing", TestRedisFencing)
+}
+
+func TestRedisBasicOperations(t *testing.T) {
+	server, client := newTestRedis(t)
+	storage := NewRedisStorage[string, redisTestValue](client, "test:", time.Millisecond*100)
+
+	key := "key"
+	value := redisTestValue{Name: "value", Count: 1}
+	if err := storage.Set(context.Background(), &key, &value, storage.Timeout()); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	if !server.Exists("test:key") {
+		t.Fatal("Expected key test:key in Redis, got:", server.Keys())
+	}
+
+	getValue, err := storage.Get(context.Background(), &key, storage.Timeout())
+	if err != nil {
+		t.Fatal("Get failed:", err)
+	}
+	if getValue == nil || *getValue != value {
+		t.Fatal("value not equal, expected:", value, "got:", getValue)
+	}
+
+	if err := storage.Del(context.Background(), &key, storage.Timeout()); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	getValue, err = storage.Get(context.Background(), &key, storage.Timeout())
+	if !IsNotFound(err) || getValue != nil {
+		t.Fatal("Expected ErrNotFound after deletion, got:", getValue, err)
+	}
+}
+
+func TestRedisCoherencyStorage(t *testing.T) {
+	_, client := newTestRedis(t)
+	source := NewRedisStorage[string, redisTestValue](client, "source:", time.Millisecond*100)
+
+	// Two instances share the Redis source, each with its own memory cache
+	first := NewCoherencyStorage[string, redisTestValue](NewMemoryStorage[string, redisTestValue](), source)
+	second := NewCoherencyStorage[string, redisTestValue](NewMemoryStorage[string, redisTestValue](), source)
+
+	key := "shared"
+	value := redisTestValue{Name: "shared", Count: 2}
+	if err := first.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+
+	getValue, err := second.Get(context.Background(), &key, time.Second)
+	if err != nil {
+		t.Fatal("Get failed:", err)
+	}
+	if getValue == nil || *getValue != value {
+		t.Fatal("Expected value from the shared source, got:", getValue)
+	}
+}
+
+func TestRedisDown(t *testing.T) {
+	server, client := newTestRedis(t)
+	storage := NewRedisStorage[string, redisTestValue](client, "test:", time.Millisecond*100)
+
+	server.Close()
+	key := "key"
+	if _, err := storage.Get(context.Background(), &key, storage.Timeout()); err == nil {
+		t.Fatal("Expected an error while Redis is down")
+	}
+
+	// The same client works again once Redis is back
+	if err := server.Restart(); err != nil {
+		t.Fatal("Restart failed:", err)
+	}
+	value := redisTestValue{Name: "back"}
+	if err := storage.Set(context.Background(), &key, &value, storage.Timeout()); err != nil {
+		t.Fatal("Set after restart failed:", err)
+	}
+}
+
+func TestRedisTombstone(t *testing.T) {
+	server, client := newTestRedis(t)
+	storage := NewRedisStorage[string, redisTestValue](client, "test:", time.Millisecond*100)
+
+	key := "missing"
+	if err := storage.SetTombstone(context.Background(), &key, time.Minute, storage.Timeout()); err != nil {
+		t.Fatal("SetTombstone failed:", err)
+	}
+	if _, err := storage.Get(context.Background(), &key, storage.Timeout()); !errors.Is(err, ErrTombstone) {
+		t.Fatal("Expected ErrTombstone, got:", err)
+	}
+	if ttl := server.TTL("test:missing"); ttl != time.Minute {
+		t.Fatal("Expected tombstone TTL of 1m, got:", ttl)
+	}
+}
+
+func TestRedisCodecs(t *testing.T) {
+	server, client := newTestRedis(t)
+
+	for _, name := range []string{CodecJSON, CodecGob, CodecMsgpack} {
+		codec, err := NewCodec(name)
+		if err != nil {
+			t.Fatal("NewCodec failed:", err)
+		}
+		storage := NewRedisStorageWithConfig[string, redisTestValue](client, RedisStorageConfig[string]{
+			Prefix:  name + ":",
+			Timeout: time.Millisecond * 100,
+			Codec:   codec,
+		})
+
+		key := "key"
+		value := redisTestValue{Name: name, Count: 3}
+		if err := storage.Set(context.Background(), &key, &value, storage.Timeout()); err != nil {
+			t.Fatal("Set failed with codec", name, err)
+		}
+		content, _ := server.Get(name + ":key")
+		var decoded redisTestValue
+		if err := codec.Unmarshal([]byte(content), &decoded); err != nil || decoded != value {
+			t.Fatal("Expected value stored with codec", name, "got:", decoded, err)
+		}
+		getValue, err := storage.Get(context.Background(), &key, storage.Timeout())
+		if err != nil || getValue == nil || *getValue != value {
+			t.Fatal("Unexpected Get result with codec", name, getValue, err)
+		}
+
+		// Tombstones are told apart from values of every codec
+		if err := storage.SetTombstone(context.Background(), &key, time.Minute, storage.Timeout()); err != nil {
+			t.Fatal("SetTombstone failed:", err)
+		}
+		if _, err := storage.Get(context.Background(), &key, storage.Timeout()); !errors.Is(err, ErrTombstone) {
+			t.Fatal("Expected ErrTombstone with codec", name, "got:", err)
+		}
+	}
+
+	if _, err := NewCodec("xml"); err == nil {
+		t.Fatal("Expected error for an unknown codec")
+	}
+}
+
+func TestRedisKeyFormatter(t *testing.T) {
+	server, client := newTestRedis(t)
+	type userKey struct {
+		Tenant string
+		ID     int
+	}
+	storage := NewRedisStorageWithConfig[userKey, redisTestValue](client, RedisStorageConfig[userKey]{
+		Prefix:  "user:",
+		Timeout: time.Millisecond * 100,
+		KeyFormatter: func(key *userKey) string {
+			return fmt.Sprintf("%s:%d", key.Tenant, key.ID)
+		},
+		DefaultTTL: time.Minute,
+	})
+
+	key := userKey{Tenant: "acme", ID: 7}
+	value := redisTestValue{Name: "user", Count: 7}
+	if err := storage.Set(context.Background(), &key, &value, storage.Timeout()); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	if !server.Exists("user:acme:7") {
+		t.Fatal("Expected key user:acme:7 in Redis, got:", server.Keys())
+	}
+	if ttl := server.TTL("user:acme:7"); ttl != time.Minute {
+		t.Fatal("Expected DefaultTTL on Set, got:", ttl)
+	}
+
+	// SetWithTTL overrides DefaultTTL
+	if err := storage.SetWithTTL(context.Background(), &key, &value, time.Second, storage.Timeout()); err != nil {
+		t.Fatal("SetWithTTL failed:", err)
+	}
+	if ttl := server.TTL("user:acme:7"); ttl != time.Second {
+		t.Fatal("Expected the TTL of SetWithTTL, got:", ttl)
+	}
+	server.FastForward(time.Second * 2)
+	if _, err := storage.Get(context.Background(), &key, storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound after the TTL, got:", err)
+	}
+}
+
+func TestRedisFencing(t *testing.T) {
+	server, client := newTestRedis(t)
+	storage := NewRedisStorageWithConfig[string, redisTestValue](client, RedisStorageConfig[string]{
+		Prefix:  "test:",
+		Timeout: time.Millisecond * 100,
+		Fencing: true,
+	})
+
+	key := "key"
+	set := func(fence uint64, name string) error {
+		ctx := context.Background()
+		if fence > 0 {
+			ctx = WithFence(ctx, fence)
+		}
+		return storage.SetWithTTL(ctx, &key, &redisTestValue{Name: name}, time.Minute, storage.Timeout())
+	}
+	for _, write := range []struct {
+		fence uint64
+		name  string
+		err   error
+	}{
+		{5, "first", nil},
+		{5, "same lease", nil},
+		{4, "late", ErrFenced},
+		// a write without a token is not checked
+		{0, "unfenced", nil},
+		{4, "late", ErrFenced},
+		{6, "next", nil},
+	} {
+		if err := set(write.fence, write.name); !errors.Is(err, write.err) {
+			t.Fatalf("Expected %v writing %q with fence %d, got: %v", write.err, write.name, write.fence, err)
+		}
+	}
+	if getValue, _ := storage.Get(context.Background(), &key, storage.Timeout()); getValue == nil || getValue.Name != "next" {
+		t.Fatal("Expected the value of the newest lease, got:", getValue)
+	}
+	if ttl := server.TTL("{test:key}"); ttl != time.Minute {
+		t.Fatal("Expected the TTL of the value, got:", ttl)
+	}
+	if ttl := server.TTL("{test:key}:fence"); ttl != DefaultFenceTTL {
+		t.Fatal("Expected the token kept for DefaultFenceTTL, got:", ttl)
+	}
+
+	// a late Del or tombstone leaves the value
+	if err := storage.Del(WithFence(context.Background(), 5), &key, storage.Timeout()); !errors.Is(err, ErrFenced) {
+		t.Fatal("Expected a late Del fenced, got:", err)
+	}
+	if err := storage.SetTombstone(WithFence(context.Background(), 5), &key, 0, storage.Timeout()); !errors.Is(err, ErrFenced) {
+		t.Fatal("Expected a late tombstone fenced, got:", err)
+	}
+	if err := storage.Del(WithFence(context.Background(), 7), &key, storage.Timeout()); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	// the token outlives the value, a late Set cannot bring it back
+	if err := set(6, "late"); !errors.Is(err, ErrFenced) || server.Exists("{test:key}") {
+		t.Fatal("Expected a late Set after Del fenced, got:", err)
+	}
+}
@@ -0,0 +1,136 @@
+package coherency_cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+/*
+CoherencyStorage refresh:
+    1. With SoftTTL, the time a value was cached is kept per key in meta.
+        IStorage values carry no metadata, so values cached by another writer are never stale.
+    2. A Get of a value older than SoftTTL - RefreshAhead returns the cached value
+        and starts one background refresh of the key, which shares the singleflight of misses.
+    3. The refresh reads the source under the cache lock and replaces the cached value,
+        a failed refresh keeps the stale value until the next Get retries it or CacheTTL expires it.
+    4. Meta is dropped by Del, by a Get which misses the cache after an eviction,
+        and by a sweep at most once per CacheTTL for keys past CacheTTL.
+        Without CacheTTL, the sweep runs at most once per SoftTTL and drops the meta and the value
+        of keys not refreshed for SoftTTL after they became stale, so no value stays stale forever.
+*/
+// cacheMeta is the refresh state of a cached key.
+type cacheMeta struct {
+	refreshAt    time.Time
+	hardExpireAt time.Time
+	refreshing   atomic.Bool
+}
+
+// touch records that key was just cached.
+func (c *CoherencyStorage[KT, VT]) touch(key *KT) {
+	if c.config.SoftTTL <= 0 {
+		return
+	}
+	now := c.now()
+	meta := &cacheMeta{refreshAt: now.Add(c.config.SoftTTL)}
+	if c.config.RefreshAhead > 0 && c.config.RefreshAhead < c.config.SoftTTL {
+		meta.refreshAt = meta.refreshAt.Add(-c.config.RefreshAhead)
+	}
+	if c.config.CacheTTL > 0 {
+		meta.hardExpireAt = now.Add(c.config.CacheTTL)
+	}
+	c.meta.Store(any(*key), meta)
+	c.sweepMeta(now)
+}
+
+// sweepMeta drops the meta of keys past CacheTTL, at most once per CacheTTL,
+// or of keys stale for SoftTTL and their values, at most once per SoftTTL without CacheTTL.
+func (c *CoherencyStorage[KT, VT]) sweepMeta(now time.Time) {
+	interval := c.config.CacheTTL
+	if interval <= 0 {
+		interval = c.config.SoftTTL
+	}
+	last := c.lastSweep.Load()
+	if now.UnixNano()-last < int64(interval) || !c.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	var idle []KT
+	c.meta.Range(func(key, value any) bool {
+		meta := value.(*cacheMeta)
+		switch {
+		case c.config.CacheTTL > 0:
+			if !now.Before(meta.hardExpireAt) {
+				c.meta.CompareAndDelete(key, value)
+			}
+		case !now.Before(meta.refreshAt.Add(c.config.SoftTTL)) && !meta.refreshing.Load():
+			if c.meta.CompareAndDelete(key, value) {
+				idle = append(idle, key.(KT))
+			}
+		}
+		return true
+	})
+	if len(idle) > 0 {
+		// the caller holds the lock of another key
+		go c.dropIdle(idle)
+	}
+}
+
+// dropIdle drops the values of keys whose meta was swept, unless they were cached again since.
+func (c *CoherencyStorage[KT, VT]) dropIdle(keys []KT) {
+	for i := range keys {
+		key := &keys[i]
+		func() {
+			ctx, cancel := context.WithTimeout(context.Background(), c.cache.Timeout())
+			defer cancel()
+			ctx, unlock, err := c.lockKey(ctx, c.cache, key)
+			if err != nil {
+				return
+			}
+			defer unlock()
+
+			if _, ok := c.meta.Load(any(*key)); !ok {
+				_ = c.cache.Del(ctx, key, c.cache.Timeout())
+			}
+		}()
+	}
+}
+
+// dropMeta drops the meta of key after a cache miss, meta is the meta loaded before the Get,
+// so the meta of a concurrent Set is kept.
+func (c *CoherencyStorage[KT, VT]) dropMeta(key *KT, meta any) {
+	if meta != nil {
+		c.meta.CompareAndDelete(any(*key), meta)
+	}
+}
+
+// refreshIfStale starts a background refresh if the cached value of key is due.
+func (c *CoherencyStorage[KT, VT]) refreshIfStale(ctx context.Context, key *KT, timeout time.Duration) {
+	if c.config.SoftTTL <= 0 {
+		return
+	}
+	value, ok := c.meta.Load(any(*key))
+	if !ok {
+		return
+	}
+	meta := value.(*cacheMeta)
+	if c.now().Before(meta.refreshAt) || !meta.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+
+	refreshKey := *key
+	refreshCtx := context.WithoutCancel(ctx)
+	go func() {
+		_, _, err := c.flight.Do(refreshCtx, any(refreshKey), timeout, func(loadCtx context.Context) (*VT, error) {
+			loadCtx, unlock, err := c.lockKey(loadCtx, c.cache, &refreshKey)
+			if err != nil {
+				return nil, err
+			}
+			defer unlock()
+			return c.fill(loadCtx, &refreshKey, true)
+		})
+		if err != nil && !IsNotFound(err) {
+			// retried by the next Get
+			meta.refreshing.Store(false)
+		}
+	}()
+}
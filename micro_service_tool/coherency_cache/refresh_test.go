@@ -0,0 +1,199 @@
+package coherency_cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoherencyStorageRefresh(t *testing.T) {
+	t.Run("Stale While Revalidate", TestStaleWhileRevalidate)
+	t.Run("Refresh Ahead", TestRefreshAhead)
+	t.Run("Refresh Deleted Source Value", TestRefreshDeletedSourceValue)
+	t.Run("Evicted Meta", TestRefreshMetaEvicted)
+	t.Run("Sweep Without CacheTTL", TestRefreshMetaSweep)
+}
+
+func newRefreshStorage(
+	clock *testClock, delay time.Duration, config CoherencyConfig,
+) (*CoherencyStorage[string, string], *MemoryStorage[string, string], *countingStorage[string, string]) {
+	cache := NewMemoryStorage[string, string]()
+	source := newCountingStorage[string, string](delay)
+	storage := NewCoherencyStorageWithConfig[string, string](cache, source, config)
+	storage.now = clock.Now
+	return storage, cache, source
+}
+
+// waitCacheValue waits until the cache holds want, nil meaning no value.
+func waitCacheValue(t *testing.T, cache *MemoryStorage[string, string], key string, want *string) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		value, _ := cache.Get(context.Background(), &key, cache.Timeout())
+		if (value == nil && want == nil) || (value != nil && want != nil && *value == *want) {
+			return
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	t.Fatal("Cache was not refreshed to:", want)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	storage, cache, source := newRefreshStorage(clock, time.Millisecond*50, CoherencyConfig{
+		CacheTTL: time.Hour,
+		SoftTTL:  time.Second,
+	})
+
+	key, oldValue, newValue := "key", "old", "new"
+	_ = source.MemoryStorage.Set(context.Background(), &key, &oldValue, source.Timeout())
+	if getValue, err := storage.Get(context.Background(), &key, time.Second); err != nil || *getValue != oldValue {
+		t.Fatal("Unexpected Get result:", getValue, err)
+	}
+	_ = source.MemoryStorage.Set(context.Background(), &key, &newValue, source.Timeout())
+
+	// Fresh values are not refreshed
+	if getValue, _ := storage.Get(context.Background(), &key, time.Second); *getValue != oldValue {
+		t.Fatal("Expected cached value, got:", *getValue)
+	}
+	if gets := source.gets.Load(); gets != 1 {
+		t.Fatal("Expected 1 source Get before SoftTTL, got:", gets)
+	}
+
+	// Stale values are returned at once, with one refresh for concurrent Gets
+	clock.Advance(time.Second * 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			getValue, err := storage.Get(context.Background(), &key, time.Second)
+			if err != nil || *getValue != oldValue {
+				t.Error("Expected stale value, got:", getValue, err)
+			}
+			if elapsed := time.Since(start); elapsed >= source.delay {
+				t.Error("Stale Get waited for the source:", elapsed)
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitCacheValue(t, cache, key, &newValue)
+	if gets := source.gets.Load(); gets != 2 {
+		t.Fatal("Expected 1 background refresh, got source Gets:", gets)
+	}
+	if getValue, _ := storage.Get(context.Background(), &key, time.Second); *getValue != newValue {
+		t.Fatal("Expected refreshed value, got:", *getValue)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	storage, cache, source := newRefreshStorage(clock, 0, CoherencyConfig{
+		CacheTTL:     time.Hour,
+		SoftTTL:      time.Second * 10,
+		RefreshAhead: time.Second * 3,
+	})
+
+	key, oldValue, newValue := "key", "old", "new"
+	if err := storage.Set(context.Background(), &key, &oldValue, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	_ = source.MemoryStorage.Set(context.Background(), &key, &newValue, source.Timeout())
+
+	clock.Advance(time.Second * 5)
+	_, _ = storage.Get(context.Background(), &key, time.Second)
+	if gets := source.gets.Load(); gets != 0 {
+		t.Fatal("Expected no refresh before the refresh-ahead window, got source Gets:", gets)
+	}
+
+	// Within RefreshAhead of SoftTTL, the value is still fresh but refreshed
+	clock.Advance(time.Second * 3)
+	if getValue, _ := storage.Get(context.Background(), &key, time.Second); *getValue != oldValue {
+		t.Fatal("Expected cached value, got:", *getValue)
+	}
+	waitCacheValue(t, cache, key, &newValue)
+}
+
+func TestRefreshDeletedSourceValue(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	storage, cache, source := newRefreshStorage(clock, 0, CoherencyConfig{
+		CacheTTL: time.Hour,
+		SoftTTL:  time.Second,
+	})
+
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	_ = source.MemoryStorage.Del(context.Background(), &key, source.Timeout())
+
+	clock.Advance(time.Second * 2)
+	if getValue, _ := storage.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected stale value, got:", getValue)
+	}
+	waitCacheValue(t, cache, key, nil)
+	if _, err := storage.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound after refresh, got:", err)
+	}
+}
+
+func hasMeta(storage *CoherencyStorage[string, string], key string) bool {
+	_, ok := storage.meta.Load(key)
+	return ok
+}
+
+func TestRefreshMetaEvicted(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	storage, cache, _ := newRefreshStorage(clock, 0, CoherencyConfig{SoftTTL: time.Second})
+
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	// an eviction of the cache does not go through the CoherencyStorage
+	_ = cache.Del(context.Background(), &key, cache.Timeout())
+	storage.meta.Store("missing", &cacheMeta{})
+
+	if _, err := storage.Get(context.Background(), &key, time.Second); err != nil {
+		t.Fatal("Expected the value loaded from the source, got:", err)
+	}
+	missing := "missing"
+	_, _ = storage.Get(context.Background(), &missing, time.Second)
+	if !hasMeta(storage, key) || hasMeta(storage, missing) {
+		t.Fatal("Expected the meta of the evicted key replaced and the missing key dropped")
+	}
+}
+
+func TestRefreshMetaSweep(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	storage, cache, _ := newRefreshStorage(clock, 0, CoherencyConfig{SoftTTL: time.Second})
+
+	idle, read, value := "idle", "read", "value"
+	for _, key := range []string{idle, read} {
+		if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+			t.Fatal("Set failed:", err)
+		}
+	}
+	// read is refreshed once stale, idle is never read again
+	clock.Advance(time.Millisecond * 1500)
+	stale, _ := storage.meta.Load(read)
+	_, _ = storage.Get(context.Background(), &read, time.Second)
+	deadline := time.Now().Add(time.Second)
+	for meta, _ := storage.meta.Load(read); meta == stale && time.Now().Before(deadline); meta, _ = storage.meta.Load(read) {
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	// a stale key not refreshed for SoftTTL is swept with its value
+	clock.Advance(time.Millisecond * 1100)
+	other := "other"
+	if err := storage.Set(context.Background(), &other, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	if hasMeta(storage, idle) || !hasMeta(storage, read) || !hasMeta(storage, other) {
+		t.Fatal("Expected only the meta of the idle key swept")
+	}
+	waitCacheValue(t, cache, idle, nil)
+	waitCacheValue(t, cache, read, &value)
+}
@@ -0,0 +1,76 @@
+package coherency_cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+flightGroup:
+    1. flightGroup collapses concurrent loads of the same key into one call,
+        whose result is shared with every caller waiting for it.
+    2. The call runs with a context detached from the cancellation of the caller which started it,
+        with its own timeout, so a cancelled caller does not fail the load of the others.
+    3. Each caller waits until the call is done or its own ctx is done.
+*/
+// flightGroup is a singleflight group keyed by storage keys.
+type flightGroup[VT any] struct {
+	mu    sync.Mutex
+	calls map[any]*flightCall[VT]
+}
+
+type flightCall[VT any] struct {
+	done  chan struct{}
+	value *VT
+	err   error
+}
+
+func newFlightGroup[VT any]() *flightGroup[VT] {
+	return &flightGroup[VT]{
+		calls: map[any]*flightCall[VT]{},
+	}
+}
+
+// Do runs load once for concurrent callers of key, shared reports whether the result
+// comes from a call started by another caller.
+func (g *flightGroup[VT]) Do(
+	ctx context.Context, key any, timeout time.Duration,
+	load func(ctx context.Context) (*VT, error),
+) (value *VT, shared bool, err error) {
+	g.mu.Lock()
+	call, shared := g.calls[key]
+	if !shared {
+		call = &flightCall[VT]{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(ctx, key, timeout, call, load)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, shared, call.err
+	case <-ctx.Done():
+		return nil, shared, ctx.Err()
+	}
+}
+
+func (g *flightGroup[VT]) run(
+	ctx context.Context, key any, timeout time.Duration, call *flightCall[VT],
+	load func(ctx context.Context) (*VT, error),
+) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("load panic: %v", r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	call.value, call.err = load(loadCtx)
+}
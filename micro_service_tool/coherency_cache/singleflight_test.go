@@ -0,0 +1,111 @@
+package coherency_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStorage counts the Get calls of a MemoryStorage and delays them.
+type countingStorage[KT any, VT any] struct {
+	*MemoryStorage[KT, VT]
+	delay     time.Duration
+	gets      atomic.Int64
+	cancelled atomic.Int64
+}
+
+func newCountingStorage[KT any, VT any](delay time.Duration) *countingStorage[KT, VT] {
+	return &countingStorage[KT, VT]{MemoryStorage: NewMemoryStorage[KT, VT](), delay: delay}
+}
+
+func (s *countingStorage[KT, VT]) Get(ctx context.Context, key *KT, timeout time.Duration) (*VT, error) {
+	s.gets.Add(1)
+	time.Sleep(s.delay)
+	if ctx.Err() != nil {
+		s.cancelled.Add(1)
+	}
+	return s.MemoryStorage.Get(ctx, key, timeout)
+}
+
+func TestSingleflight(t *testing.T) {
+	t.Run("Collapse Misses", TestSingleflightCollapse)
+	t.Run("Cancelled Caller", TestSingleflightCancelledCaller)
+	t.Run("Panic", TestSingleflightPanic)
+}
+
+func TestSingleflightCollapse(t *testing.T) {
+	source := newCountingStorage[string, string](time.Millisecond * 50)
+	storage := NewCoherencyStorage[string, string](NewMemoryStorage[string, string](), source)
+
+	key, value := "key", "value"
+	_ = source.MemoryStorage.Set(context.Background(), &key, &value, source.Timeout())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			getValue, err := storage.Get(context.Background(), &key, time.Second)
+			if err != nil || getValue == nil || *getValue != value {
+				t.Error("Unexpected Get result:", getValue, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if gets := source.gets.Load(); gets != 1 {
+		t.Fatal("Expected 1 source Get for 20 concurrent misses, got:", gets)
+	}
+}
+
+func TestSingleflightCancelledCaller(t *testing.T) {
+	source := newCountingStorage[string, string](time.Millisecond * 100)
+	storage := NewCoherencyStorage[string, string](NewMemoryStorage[string, string](), source)
+
+	key, value := "key", "value"
+	_ = source.MemoryStorage.Set(context.Background(), &key, &value, source.Timeout())
+
+	// The first caller starts the load and gives up early
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	firstErr := make(chan error)
+	go func() {
+		_, err := storage.Get(ctx, &key, time.Second)
+		firstErr <- err
+	}()
+	time.Sleep(time.Millisecond * 5)
+
+	// The second caller shares the load, which is not cancelled with the first caller
+	getValue, err := storage.Get(context.Background(), &key, time.Second)
+	if err != nil || getValue == nil || *getValue != value {
+		t.Fatal("Unexpected Get result of the waiting caller:", getValue, err)
+	}
+	if err := <-firstErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected DeadlineExceeded for the cancelled caller, got:", err)
+	}
+	if gets, cancelled := source.gets.Load(), source.cancelled.Load(); gets != 1 || cancelled != 0 {
+		t.Fatal("Expected 1 load which is not cancelled, got:", gets, cancelled)
+	}
+}
+
+func TestSingleflightPanic(t *testing.T) {
+	group := newFlightGroup[string]()
+	_, _, err := group.Do(context.Background(), "key", time.Second, func(context.Context) (*string, error) {
+		panic("boom")
+	})
+	if err == nil {
+		t.Fatal("Expected an error from a panicking load")
+	}
+
+	// The key can be loaded again
+	value := "value"
+	getValue, _, err := group.Do(context.Background(), "key", time.Second, func(context.Context) (*string, error) {
+		return &value, nil
+	})
+	if err != nil || *getValue != value {
+		t.Fatal("Unexpected result after panic:", getValue, err)
+	}
+}
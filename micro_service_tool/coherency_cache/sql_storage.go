@@ -0,0 +1,295 @@
+package coherency_cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	SQLDialectSQLite   = "sqlite"
+	SQLDialectPostgres = "postgres"
+	SQLDialectMySQL    = "mysql"
+)
+
+var (
+	_ IStorage[any, any] = (*SQLStorage[any, any])(nil)
+)
+
+/*
+SQLStorage:
+    1. SQLStorage maps the keys and values of a storage to the rows of a table with three queries:
+        - GetQuery selects the value of a key, its arguments are KeyArgs(key),
+            Scan reads the row, no row is ErrNotFound.
+        - SetQuery inserts or updates the row of a key, its arguments are KeyArgs(key) then ValueArgs(value).
+        - DelQuery deletes the row of a key, its arguments are KeyArgs(key).
+        The queries use the placeholders of the driver.
+    2. NewSQLStorageForStruct builds the queries and mappings from the db tags of the fields of VT,
+        see SQLTableConfig.
+    3. Every query runs with the ctx of the operation and its timeout, the driver cancels it once ctx is done.
+    4. Lock and Unlock only lock in the current process, see ILockProvider for locks across instances.
+    5. With Fenced, SetQuery and DelQuery take the fencing token of the ctx (see FenceFromContext)
+        as their last argument, 0 without a token. They must keep the last token of the row
+        and change no row holding a newer one, a Set which changes no row returns ErrFenced.
+        A deleted row keeps no token.
+*/
+// SQLStorageConfig configures a SQLStorage.
+type SQLStorageConfig[KT any, VT any] struct {
+	GetQuery string
+	SetQuery string
+	DelQuery string
+
+	KeyArgs   func(key *KT) []any
+	ValueArgs func(value *VT) ([]any, error)
+	// Scan reads the value of key from a row of GetQuery with scan, which is sql.Row.Scan.
+	Scan func(key *KT, scan func(dest ...any) error) (*VT, error)
+
+	// Timeout is the default timeout of each query, 0 means 1s.
+	Timeout time.Duration
+	// Fenced passes the fencing token to SetQuery and DelQuery.
+	Fenced bool
+}
+
+// SQLStorage is an IStorage over a table of a database/sql database.
+type SQLStorage[KT any, VT any] struct {
+	BaseStorage[KT]
+
+	db     *sql.DB
+	config SQLStorageConfig[KT, VT]
+}
+
+func NewSQLStorage[KT any, VT any](db *sql.DB, config SQLStorageConfig[KT, VT]) (*SQLStorage[KT, VT], error) {
+	if config.GetQuery == "" || config.SetQuery == "" || config.DelQuery == "" {
+		return nil, errors.New("GetQuery, SetQuery and DelQuery are required")
+	}
+	if config.KeyArgs == nil || config.ValueArgs == nil || config.Scan == nil {
+		return nil, errors.New("KeyArgs, ValueArgs and Scan are required")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	return &SQLStorage[KT, VT]{
+		db:     db,
+		config: config,
+	}, nil
+}
+
+func (s *SQLStorage[KT, VT]) Get(
+	ctx context.Context, key *KT, timeout time.Duration,
+) (*VT, error) {
+	subCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	row := s.db.QueryRowContext(subCtx, s.config.GetQuery, s.config.KeyArgs(key)...)
+	value, err := s.config.Scan(key, row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *SQLStorage[KT, VT]) Set(
+	ctx context.Context, key *KT, value *VT, timeout time.Duration,
+) error {
+	subCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	valueArgs, err := s.config.ValueArgs(value)
+	if err != nil {
+		return err
+	}
+	args := append(s.config.KeyArgs(key), valueArgs...)
+	if !s.config.Fenced {
+		_, err = s.db.ExecContext(subCtx, s.config.SetQuery, args...)
+		return err
+	}
+	result, err := s.db.ExecContext(subCtx, s.config.SetQuery, append(args, fenceArg(subCtx))...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrFenced
+	}
+	return nil
+}
+
+func (s *SQLStorage[KT, VT]) Del(
+	ctx context.Context, key *KT, timeout time.Duration,
+) error {
+	subCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := s.config.KeyArgs(key)
+	if s.config.Fenced {
+		args = append(args, fenceArg(subCtx))
+	}
+	_, err := s.db.ExecContext(subCtx, s.config.DelQuery, args...)
+	return err
+}
+
+// fenceArg returns the fencing token of ctx as a query argument, 0 without a token.
+func fenceArg(ctx context.Context) int64 {
+	fence, _ := FenceFromContext(ctx)
+	return int64(fence)
+}
+
+func (s *SQLStorage[KT, VT]) Timeout() time.Duration {
+	return s.config.Timeout
+}
+
+/*
+SQLTableConfig:
+    1. The value columns are the fields of VT with a db tag, `db:"-"` and untagged fields are skipped.
+        Embedded structs are not flattened.
+    2. The key is one column, KeyColumn, holding the key itself. A field of VT tagged with KeyColumn
+        is not a value column, it is filled with the key on Get.
+    3. Set is an upsert in the syntax of Dialect, placeholders are ? or $n (postgres).
+    4. Table and column names are put in the queries as they are, they must not come from user input.
+    5. FenceColumn is an integer column of the table holding the last fencing token of the row,
+        it enables SQLStorageConfig.Fenced. Only sqlite and postgres support it.
+*/
+// SQLTableConfig maps a struct to a table for NewSQLStorageForStruct.
+type SQLTableConfig struct {
+	Table     string
+	KeyColumn string
+	// Dialect is one of SQLDialect*, empty means sqlite.
+	Dialect string
+	Timeout time.Duration
+	// FenceColumn is the column of fencing tokens, empty disables fencing.
+	FenceColumn string
+}
+
+// NewSQLStorageForStruct creates a SQLStorage of the struct VT, see SQLTableConfig.
+func NewSQLStorageForStruct[KT any, VT any](db *sql.DB, table SQLTableConfig) (*SQLStorage[KT, VT], error) {
+	valueType := reflect.TypeOf((*VT)(nil)).Elem()
+	if valueType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("value type %v is not a struct", valueType)
+	}
+	if table.Table == "" || table.KeyColumn == "" {
+		return nil, errors.New("Table and KeyColumn are required")
+	}
+
+	var columns []string
+	var fields []int
+	keyField := -1
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		column, _, _ := strings.Cut(field.Tag.Get("db"), ",")
+		if !field.IsExported() || column == "" || column == "-" || column == table.FenceColumn {
+			continue
+		}
+		if column == table.KeyColumn {
+			keyField = i
+			continue
+		}
+		columns = append(columns, column)
+		fields = append(fields, i)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("value type %v has no db tagged field", valueType)
+	}
+	if keyField >= 0 && valueType.Field(keyField).Type != reflect.TypeOf((*KT)(nil)).Elem() {
+		return nil, fmt.Errorf("field %s is not of the key type", valueType.Field(keyField).Name)
+	}
+
+	queries, err := sqlTableQueries[KT, VT](table, columns)
+	if err != nil {
+		return nil, err
+	}
+	queries.Timeout = table.Timeout
+	queries.Fenced = table.FenceColumn != ""
+	queries.KeyArgs = func(key *KT) []any {
+		return []any{*key}
+	}
+	queries.ValueArgs = func(value *VT) ([]any, error) {
+		if value == nil {
+			return nil, errors.New("nil value")
+		}
+		v := reflect.ValueOf(value).Elem()
+		args := make([]any, len(fields))
+		for i, field := range fields {
+			args[i] = v.Field(field).Interface()
+		}
+		return args, nil
+	}
+
+	queries.Scan = func(key *KT, scan func(dest ...any) error) (*VT, error) {
+		value := new(VT)
+		v := reflect.ValueOf(value).Elem()
+		dest := make([]any, len(fields))
+		for i, field := range fields {
+			dest[i] = v.Field(field).Addr().Interface()
+		}
+		if err := scan(dest...); err != nil {
+			return nil, err
+		}
+		if keyField >= 0 {
+			v.Field(keyField).Set(reflect.ValueOf(*key))
+		}
+		return value, nil
+	}
+	return NewSQLStorage[KT, VT](db, queries)
+}
+
+// sqlTableQueries builds the queries of a table with the key column first.
+func sqlTableQueries[KT any, VT any](table SQLTableConfig, columns []string) (SQLStorageConfig[KT, VT], error) {
+	placeholder := func(i int) string { return "?" }
+	var upsert string
+	assignments := make([]string, len(columns))
+	switch table.Dialect {
+	case "", SQLDialectSQLite, SQLDialectPostgres:
+		if table.Dialect == SQLDialectPostgres {
+			placeholder = func(i int) string { return fmt.Sprintf("$%d", i) }
+		}
+		for i, column := range columns {
+			assignments[i] = fmt.Sprintf("%s = excluded.%s", column, column)
+		}
+		upsert = fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", table.KeyColumn, strings.Join(assignments, ", "))
+		if table.FenceColumn != "" {
+			// the row keeps the largest token, a write without a token (0) is not checked
+			fence, stored := "excluded."+table.FenceColumn, table.Table+"."+table.FenceColumn
+			upsert += fmt.Sprintf(", %s = CASE WHEN %s > %s THEN %s ELSE %s END WHERE %s <= COALESCE(NULLIF(%s, 0), %s)",
+				table.FenceColumn, fence, stored, fence, stored, stored, fence, stored)
+		}
+	case SQLDialectMySQL:
+		if table.FenceColumn != "" {
+			return SQLStorageConfig[KT, VT]{}, errors.New("FenceColumn is not supported by the mysql dialect")
+		}
+		for i, column := range columns {
+			assignments[i] = fmt.Sprintf("%s = VALUES(%s)", column, column)
+		}
+		upsert = "ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	default:
+		return SQLStorageConfig[KT, VT]{}, fmt.Errorf("unknown sql dialect: %q", table.Dialect)
+	}
+
+	getColumns := strings.Join(columns, ", ")
+	delQuery := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", table.Table, table.KeyColumn, placeholder(1))
+	if table.FenceColumn != "" {
+		columns = append(columns, table.FenceColumn)
+		delQuery += fmt.Sprintf(" AND %s <= COALESCE(NULLIF(%s, 0), %s)",
+			table.FenceColumn, placeholder(2), table.FenceColumn)
+	}
+	placeholders := make([]string, len(columns)+1)
+	for i := range placeholders {
+		placeholders[i] = placeholder(i + 1)
+	}
+	return SQLStorageConfig[KT, VT]{
+		GetQuery: fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s",
+			getColumns, table.Table, table.KeyColumn, placeholder(1)),
+		SetQuery: fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES (%s) %s",
+			table.Table, table.KeyColumn, strings.Join(columns, ", "), strings.Join(placeholders, ", "), upsert),
+		DelQuery: delQuery,
+	}, nil
+}
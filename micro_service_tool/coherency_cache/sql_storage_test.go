@@ -0,0 +1,306 @@
+package coherency_cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type sqlTestUser struct {
+	ID      string `db:"id"`
+	Name    string `db:"name"`
+	Count   int    `db:"count"`
+	Ignored string `db:"-"`
+	Note    string
+}
+
+// newTestDB opens an embedded SQLite database with a users table, closed when the test ends.
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE users (id TEXT PRIMARY KEY, name TEXT NOT NULL, count INTEGER NOT NULL)"); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	return db
+}
+
+func TestSQLStorage(t *testing.T) {
+	t.Run("Query Templates", TestSQLStorageQueries)
+	t.Run("Struct Mapping", TestSQLStorageStruct)
+	t.Run("Invalid Config", TestSQLStorageInvalidConfig)
+	t.Run("Context Deadline", TestSQLStorageDeadline)
+	t.Run("Coherency With SQL Source", TestSQLCoherencyStorage)
+	t.Run("Fencing", TestSQLStorageFencing)
+}
+
+func TestSQLStorageQueries(t *testing.T) {
+	db := newTestDB(t)
+	storage, err := NewSQLStorage[string, redisTestValue](db, SQLStorageConfig[string, redisTestValue]{
+		GetQuery: "SELECT name, count FROM users WHERE id = ?",
+		SetQuery: "INSERT OR REPLACE INTO users (id, name, count) VALUES (?, ?, ?)",
+		DelQuery: "DELETE FROM users WHERE id = ?",
+		KeyArgs: func(key *string) []any {
+			return []any{*key}
+		},
+		ValueArgs: func(value *redisTestValue) ([]any, error) {
+			return []any{value.Name, value.Count}, nil
+		},
+		Scan: func(key *string, scan func(dest ...any) error) (*redisTestValue, error) {
+			value := &redisTestValue{}
+			if err := scan(&value.Name, &value.Count); err != nil {
+				return nil, err
+			}
+			return value, nil
+		},
+	})
+	if err != nil {
+		t.Fatal("NewSQLStorage failed:", err)
+	}
+	if storage.Timeout() != time.Second {
+		t.Fatal("Expected default timeout of 1s, got:", storage.Timeout())
+	}
+
+	ctx := context.Background()
+	key := "key"
+	if _, err := storage.Get(ctx, &key, storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound for a missing row, got:", err)
+	}
+	value := redisTestValue{Name: "value", Count: 1}
+	if err := storage.Set(ctx, &key, &value, storage.Timeout()); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM users WHERE id = ?", key).Scan(&name); err != nil || name != value.Name {
+		t.Fatal("Expected row in the table, got:", name, err)
+	}
+	if getValue, err := storage.Get(ctx, &key, storage.Timeout()); err != nil || *getValue != value {
+		t.Fatal("Unexpected Get result:", getValue, err)
+	}
+	if err := storage.Del(ctx, &key, storage.Timeout()); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	if _, err := storage.Get(ctx, &key, storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound after deletion, got:", err)
+	}
+}
+
+func TestSQLStorageStruct(t *testing.T) {
+	db := newTestDB(t)
+	storage, err := NewSQLStorageForStruct[string, sqlTestUser](db, SQLTableConfig{
+		Table:     "users",
+		KeyColumn: "id",
+		Timeout:   time.Millisecond * 500,
+	})
+	if err != nil {
+		t.Fatal("NewSQLStorageForStruct failed:", err)
+	}
+
+	ctx := context.Background()
+	key := "u1"
+	value := sqlTestUser{Name: "first", Count: 1, Ignored: "ignored", Note: "note"}
+	if err := storage.Set(ctx, &key, &value, storage.Timeout()); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	// Set is an upsert
+	value = sqlTestUser{Name: "second", Count: 2}
+	if err := storage.Set(ctx, &key, &value, storage.Timeout()); err != nil {
+		t.Fatal("Set of an existing row failed:", err)
+	}
+	var rows int
+	_ = db.QueryRow("SELECT COUNT(*) FROM users").Scan(&rows)
+	if rows != 1 {
+		t.Fatal("Expected 1 row after the upsert, got:", rows)
+	}
+
+	// The key field is filled from the key, untagged fields are left empty
+	getValue, err := storage.Get(ctx, &key, storage.Timeout())
+	if err != nil {
+		t.Fatal("Get failed:", err)
+	}
+	expected := sqlTestUser{ID: key, Name: "second", Count: 2}
+	if *getValue != expected {
+		t.Fatal("value not equal, expected:", expected, "got:", *getValue)
+	}
+
+	if err := storage.Del(ctx, &key, storage.Timeout()); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	if _, err := storage.Get(ctx, &key, storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound after deletion, got:", err)
+	}
+}
+
+func TestSQLStorageInvalidConfig(t *testing.T) {
+	db := newTestDB(t)
+	table := SQLTableConfig{Table: "users", KeyColumn: "id"}
+	if _, err := NewSQLStorageForStruct[string, string](db, table); err == nil {
+		t.Fatal("Expected error for a value type that is not a struct")
+	}
+	if _, err := NewSQLStorageForStruct[string, redisTestValue](db, table); err == nil {
+		t.Fatal("Expected error for a struct without db tags")
+	}
+	if _, err := NewSQLStorageForStruct[int, sqlTestUser](db, table); err == nil {
+		t.Fatal("Expected error for a key field not of the key type")
+	}
+	if _, err := NewSQLStorageForStruct[string, sqlTestUser](db, SQLTableConfig{KeyColumn: "id"}); err == nil {
+		t.Fatal("Expected error without a table")
+	}
+	if _, err := NewSQLStorageForStruct[string, sqlTestUser](db, SQLTableConfig{
+		Table: "users", KeyColumn: "id", Dialect: "oracle",
+	}); err == nil {
+		t.Fatal("Expected error for an unknown dialect")
+	}
+	if _, err := NewSQLStorageForStruct[string, sqlTestUser](db, SQLTableConfig{
+		Table: "users", KeyColumn: "id", Dialect: SQLDialectMySQL, FenceColumn: "fence",
+	}); err == nil {
+		t.Fatal("Expected error for fencing on mysql")
+	}
+	if _, err := NewSQLStorage[string, string](db, SQLStorageConfig[string, string]{GetQuery: "SELECT 1"}); err == nil {
+		t.Fatal("Expected error for missing queries")
+	}
+
+	// The queries of the other dialects
+	postgres, _ := sqlTableQueries[string, sqlTestUser](SQLTableConfig{
+		Table: "users", KeyColumn: "id", Dialect: SQLDialectPostgres,
+	}, []string{"name", "count"})
+	if expected := "INSERT INTO users (id, name, count) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (id) DO UPDATE SET name = excluded.name, count = excluded.count"; postgres.SetQuery != expected {
+		t.Fatal("Unexpected postgres SetQuery:", postgres.SetQuery)
+	}
+	mysql, _ := sqlTableQueries[string, sqlTestUser](SQLTableConfig{
+		Table: "users", KeyColumn: "id", Dialect: SQLDialectMySQL,
+	}, []string{"name", "count"})
+	if expected := "INSERT INTO users (id, name, count) VALUES (?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE name = VALUES(name), count = VALUES(count)"; mysql.SetQuery != expected {
+		t.Fatal("Unexpected mysql SetQuery:", mysql.SetQuery)
+	}
+}
+
+func TestSQLStorageDeadline(t *testing.T) {
+	db := newTestDB(t)
+	storage, _ := NewSQLStorageForStruct[string, sqlTestUser](db, SQLTableConfig{Table: "users", KeyColumn: "id"})
+
+	key := "key"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := storage.Get(ctx, &key, storage.Timeout()); !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected context.Canceled for a cancelled ctx, got:", err)
+	}
+	if err := storage.Set(ctx, &key, &sqlTestUser{Name: "value"}, storage.Timeout()); !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected context.Canceled on Set, got:", err)
+	}
+
+	// A slow query is cancelled by the timeout of the operation
+	slow, _ := NewSQLStorage[string, int](db, SQLStorageConfig[string, int]{
+		GetQuery: "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 100000000) " +
+			"SELECT COUNT(*) FROM n WHERE ? IS NOT NULL",
+		SetQuery:  "SELECT ?, ?",
+		DelQuery:  "SELECT ?",
+		KeyArgs:   func(key *string) []any { return []any{*key} },
+		ValueArgs: func(value *int) ([]any, error) { return []any{*value}, nil },
+		Scan: func(key *string, scan func(dest ...any) error) (*int, error) {
+			value := new(int)
+			return value, scan(value)
+		},
+	})
+	start := time.Now()
+	if _, err := slow.Get(context.Background(), &key, time.Millisecond*50); err == nil {
+		t.Fatal("Expected the slow query to fail past its timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("Expected the slow query cancelled at its timeout, took:", elapsed)
+	}
+}
+
+func TestSQLCoherencyStorage(t *testing.T) {
+	db := newTestDB(t)
+	source, _ := NewSQLStorageForStruct[string, sqlTestUser](db, SQLTableConfig{Table: "users", KeyColumn: "id"})
+	storage := NewCoherencyStorage[string, sqlTestUser](NewMemoryStorage[string, sqlTestUser](), source)
+
+	ctx := context.Background()
+	key := "u1"
+	value := sqlTestUser{ID: key, Name: "user", Count: 1}
+	if err := storage.Set(ctx, &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	// The row is served from the cache once loaded
+	_, _ = db.Exec("UPDATE users SET name = 'changed' WHERE id = ?", key)
+	if getValue, err := storage.Get(ctx, &key, time.Second); err != nil || *getValue != value {
+		t.Fatal("Expected the cached value, got:", getValue, err)
+	}
+
+	missing := "missing"
+	if _, err := storage.Get(ctx, &missing, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound for a missing row, got:", err)
+	}
+	if err := storage.Del(ctx, &key, time.Second); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	var rows int
+	_ = db.QueryRow("SELECT COUNT(*) FROM users").Scan(&rows)
+	if rows != 0 {
+		t.Fatal("Expected the row deleted from the source, got rows:", rows)
+	}
+}
+
+func TestSQLStorageFencing(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("ALTER TABLE users ADD COLUMN fence INTEGER NOT NULL DEFAULT 0"); err != nil {
+		t.Fatal("Failed to add the fence column:", err)
+	}
+	storage, err := NewSQLStorageForStruct[string, sqlTestUser](db, SQLTableConfig{
+		Table:       "users",
+		KeyColumn:   "id",
+		FenceColumn: "fence",
+	})
+	if err != nil {
+		t.Fatal("NewSQLStorageForStruct failed:", err)
+	}
+
+	key := "u1"
+	set := func(fence uint64, name string) error {
+		ctx := context.Background()
+		if fence > 0 {
+			ctx = WithFence(ctx, fence)
+		}
+		return storage.Set(ctx, &key, &sqlTestUser{Name: name}, storage.Timeout())
+	}
+	for _, write := range []struct {
+		fence uint64
+		name  string
+		err   error
+	}{
+		{5, "first", nil},
+		{5, "same lease", nil},
+		{4, "late", ErrFenced},
+		// a write without a token is not checked and keeps the token of the row
+		{0, "unfenced", nil},
+		{4, "late", ErrFenced},
+		{6, "next", nil},
+	} {
+		if err := set(write.fence, write.name); !errors.Is(err, write.err) {
+			t.Fatalf("Expected %v writing %q with fence %d, got: %v", write.err, write.name, write.fence, err)
+		}
+	}
+	if getValue, _ := storage.Get(context.Background(), &key, storage.Timeout()); getValue == nil || getValue.Name != "next" {
+		t.Fatal("Expected the value of the newest lease, got:", getValue)
+	}
+
+	// a late Del leaves the row
+	_ = storage.Del(WithFence(context.Background(), 5), &key, storage.Timeout())
+	if _, err := storage.Get(context.Background(), &key, storage.Timeout()); err != nil {
+		t.Fatal("Expected the row kept after a late Del, got:", err)
+	}
+	_ = storage.Del(WithFence(context.Background(), 6), &key, storage.Timeout())
+	if _, err := storage.Get(context.Background(), &key, storage.Timeout()); !IsNotFound(err) {
+		t.Fatal("Expected the row deleted, got:", err)
+	}
+}
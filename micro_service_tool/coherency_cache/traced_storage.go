@@ -0,0 +1,147 @@
+package coherency_cache
+
+import (
+	"context"
+	"time"
+)
+
+// Check if TracedStorage implements IStorage, ITTLStorage and ITombstoneStorage
+var (
+	_ IStorage[any, any]          = (*TracedStorage[any, any])(nil)
+	_ ITTLStorage[any, any]       = (*TracedStorage[any, any])(nil)
+	_ ITombstoneStorage[any, any] = (*TracedStorage[any, any])(nil)
+	_ IStorageTracer              = StorageTracerFunc(nil)
+)
+
+/*
+TracedStorage:
+    1. TracedStorage wraps an IStorage and reports every Get/Set/SetTombstone/Del to an IStorageTracer,
+        so the storages do not depend on a tracing library.
+    2. Wrap both the CoherencyStorage and its layers to see which layer served a request,
+        since CoherencyStorage passes ctx down to its layers.
+    3. Locks are not traced.
+*/
+// StorageOperation is an operation of a TracedStorage.
+type StorageOperation struct {
+	// Storage is the name of the TracedStorage.
+	Storage string
+	// Operation is Get, Set, SetTombstone or Del.
+	Operation string
+	Key       any
+	// TTL is only set by SetWithTTL and SetTombstone.
+	TTL time.Duration
+}
+
+// IStorageTracer traces the operations of a TracedStorage.
+type IStorageTracer interface {
+	// Start is called before an operation, the returned ctx is passed to the wrapped storage
+	// and end is called with the error of the operation.
+	Start(ctx context.Context, operation StorageOperation) (_ context.Context, end func(err error))
+}
+
+// StorageTracerFunc is a function IStorageTracer.
+type StorageTracerFunc func(ctx context.Context, operation StorageOperation) (context.Context, func(err error))
+
+func (f StorageTracerFunc) Start(ctx context.Context, operation StorageOperation) (context.Context, func(err error)) {
+	return f(ctx, operation)
+}
+
+// TracedStorage is an IStorage with tracing.
+type TracedStorage[KT any, VT any] struct {
+	name    string
+	storage IStorage[KT, VT]
+	tracer  IStorageTracer
+}
+
+func NewTracedStorage[KT any, VT any](
+	name string, storage IStorage[KT, VT], tracer IStorageTracer,
+) *TracedStorage[KT, VT] {
+	return &TracedStorage[KT, VT]{
+		name:    name,
+		storage: storage,
+		tracer:  tracer,
+	}
+}
+
+func (t *TracedStorage[KT, VT]) Get(
+	ctx context.Context, key *KT, timeout time.Duration,
+) (*VT, error) {
+	ctx, end := t.start(ctx, "Get", key, 0)
+	value, err := t.storage.Get(ctx, key, timeout)
+	end(err)
+	return value, err
+}
+
+func (t *TracedStorage[KT, VT]) Set(
+	ctx context.Context, key *KT, value *VT, timeout time.Duration,
+) error {
+	ctx, end := t.start(ctx, "Set", key, 0)
+	err := t.storage.Set(ctx, key, value, timeout)
+	end(err)
+	return err
+}
+
+// SetWithTTL sets with ttl if the wrapped storage is an ITTLStorage, otherwise with Set.
+func (t *TracedStorage[KT, VT]) SetWithTTL(
+	ctx context.Context, key *KT, value *VT, ttl time.Duration, timeout time.Duration,
+) error {
+	ctx, end := t.start(ctx, "Set", key, ttl)
+	err := setWithTTL(ctx, t.storage, key, value, ttl, timeout)
+	end(err)
+	return err
+}
+
+// SetTombstone sets a tombstone if the wrapped storage is an ITombstoneStorage,
+// otherwise it deletes the key.
+func (t *TracedStorage[KT, VT]) SetTombstone(
+	ctx context.Context, key *KT, ttl time.Duration, timeout time.Duration,
+) error {
+	ctx, end := t.start(ctx, "SetTombstone", key, ttl)
+	var err error
+	if tombstoneStorage, ok := t.storage.(ITombstoneStorage[KT, VT]); ok {
+		err = tombstoneStorage.SetTombstone(ctx, key, ttl, timeout)
+	} else {
+		err = t.storage.Del(ctx, key, timeout)
+	}
+	end(err)
+	return err
+}
+
+func (t *TracedStorage[KT, VT]) Del(
+	ctx context.Context, key *KT, timeout time.Duration,
+) error {
+	ctx, end := t.start(ctx, "Del", key, 0)
+	err := t.storage.Del(ctx, key, timeout)
+	end(err)
+	return err
+}
+
+func (t *TracedStorage[KT, VT]) Lock(ctx context.Context, key *KT) error {
+	return t.storage.Lock(ctx, key)
+}
+
+func (t *TracedStorage[KT, VT]) LockWithTimeout(ctx context.Context, key *KT, timeout time.Duration) error {
+	return t.storage.LockWithTimeout(ctx, key, timeout)
+}
+
+func (t *TracedStorage[KT, VT]) TryLock(ctx context.Context, key *KT) bool {
+	return t.storage.TryLock(ctx, key)
+}
+
+func (t *TracedStorage[KT, VT]) Unlock(ctx context.Context, key *KT) {
+	t.storage.Unlock(ctx, key)
+}
+
+func (t *TracedStorage[KT, VT]) Timeout() time.Duration {
+	return t.storage.Timeout()
+}
+
+func (t *TracedStorage[KT, VT]) start(
+	ctx context.Context, operation string, key *KT, ttl time.Duration,
+) (context.Context, func(err error)) {
+	storageOperation := StorageOperation{Storage: t.name, Operation: operation, TTL: ttl}
+	if key != nil {
+		storageOperation.Key = *key
+	}
+	return t.tracer.Start(ctx, storageOperation)
+}
@@ -0,0 +1,102 @@
+package coherency_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type traceContextKey struct{}
+
+// tracedOperation is an operation recorded by recordingTracer.
+type tracedOperation struct {
+	StorageOperation
+	err error
+	// parent is the operation in the ctx of the operation, "" for none
+	parent string
+}
+
+// recordingTracer records the ended operations, the ctx of an operation carries its name.
+type recordingTracer struct {
+	mu         sync.Mutex
+	operations []tracedOperation
+}
+
+func (r *recordingTracer) Start(ctx context.Context, operation StorageOperation) (context.Context, func(err error)) {
+	parent, _ := ctx.Value(traceContextKey{}).(string)
+	name := operation.Storage + "." + operation.Operation
+	return context.WithValue(ctx, traceContextKey{}, name), func(err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.operations = append(r.operations, tracedOperation{StorageOperation: operation, err: err, parent: parent})
+	}
+}
+
+func (r *recordingTracer) ended() []tracedOperation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	operations := r.operations
+	r.operations = nil
+	return operations
+}
+
+func TestTracedStorage(t *testing.T) {
+	tracer := &recordingTracer{}
+	cache := NewTracedStorage[string, string]("cache", NewMemoryStorage[string, string](), tracer)
+	source := NewTracedStorage[string, string]("source", NewMemoryStorage[string, string](), tracer)
+	storage := NewTracedStorage[string, string]("coherency", NewCoherencyStorageWithConfig[string, string](
+		cache, source, CoherencyConfig{NegativeTTL: time.Minute},
+	), tracer)
+
+	ctx := context.Background()
+	key, value := "key", "value"
+	if err := storage.Set(ctx, &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	operations := tracer.ended()
+	if last := operations[len(operations)-1]; last.Storage != "coherency" || last.Operation != "Set" || last.Key != key {
+		t.Fatal("Expected the coherency Set traced last, got:", last)
+	}
+	for _, operation := range operations[:len(operations)-1] {
+		if operation.parent != "coherency.Set" {
+			t.Fatal("Expected the layer operations traced under coherency.Set, got:", operation)
+		}
+	}
+
+	// a hit of the cache does not reach the source
+	if _, err := storage.Get(ctx, &key, time.Second); err != nil {
+		t.Fatal("Get failed:", err)
+	}
+	operations = tracer.ended()
+	if len(operations) != 2 || operations[0].Storage != "cache" || operations[0].parent != "coherency.Get" {
+		t.Fatal("Expected only the cache Get traced under coherency.Get, got:", operations)
+	}
+
+	// a miss reaches the source, which sets a tombstone in the cache
+	missing := "missing"
+	if _, err := storage.Get(ctx, &missing, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected ErrNotFound, got:", err)
+	}
+	traced := map[string]tracedOperation{}
+	for _, operation := range tracer.ended() {
+		traced[operation.Storage+"."+operation.Operation] = operation
+	}
+	if get := traced["source.Get"]; !IsNotFound(get.err) || get.parent != "coherency.Get" {
+		t.Fatal("Expected the source Get traced with ErrNotFound, got:", get)
+	}
+	if tombstone, ok := traced["cache.SetTombstone"]; !ok || tombstone.TTL != time.Minute {
+		t.Fatal("Expected the tombstone traced with its TTL, got:", traced)
+	}
+
+	// errors of the wrapped storage are passed to end
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := cache.Set(canceled, &key, &value, time.Second); err == nil {
+		t.Fatal("Expected the Set to fail")
+	}
+	if operations := tracer.ended(); len(operations) != 1 || !errors.Is(operations[0].err, context.Canceled) {
+		t.Fatal("Expected the error traced, got:", operations)
+	}
+}
@@ -0,0 +1,98 @@
+package coherency_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Check if journals implement IWriteBehindJournal
+var (
+	_ IWriteBehindJournal = (*FileWriteBehindJournal[any, any])(nil)
+)
+
+/*
+IWriteBehindJournal:
+    1. A journal keeps the writes of the write-behind queue which did not reach the source,
+        so they survive a crash or a restart of the process.
+    2. Record is called for every queued write before Set or Del returns, a failed Record fails them.
+        Done is called once the last write of a key reached the source or was dropped after MaxRetries.
+        Replay is called when the queue is created, the writes it returns are queued again.
+    3. Keys are the KT and values the *VT of the CoherencyStorage.
+    4. A write replayed after a crash may already be in the source, writes are at least once.
+    5. The journal is not closed by the CoherencyStorage, close it after Close of the storage.
+*/
+// IWriteBehindJournal persists the pending writes of WriteModeBehind.
+type IWriteBehindJournal interface {
+	Record(key any, value any, del bool) error
+	Done(key any) error
+	Replay(fn func(key any, value any, del bool)) error
+}
+
+// FileWriteBehindJournal is an IWriteBehindJournal on a FileStorage, a Del is kept as a tombstone.
+type FileWriteBehindJournal[KT any, VT any] struct {
+	storage *FileStorage[KT, VT]
+}
+
+// NewFileWriteBehindJournal opens the journal at config.Path, use FsyncAlways
+// for writes which survive a crash of the machine.
+func NewFileWriteBehindJournal[KT any, VT any](config FileStorageConfig) (*FileWriteBehindJournal[KT, VT], error) {
+	storage, err := NewFileStorage[KT, VT](config)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWriteBehindJournal[KT, VT]{storage: storage}, nil
+}
+
+func (j *FileWriteBehindJournal[KT, VT]) Record(key any, value any, del bool) error {
+	typedKey, ok := key.(KT)
+	if !ok {
+		return fmt.Errorf("unexpected journal key type: %T", key)
+	}
+	if del {
+		return j.storage.SetTombstone(context.Background(), &typedKey, 0, j.storage.Timeout())
+	}
+	typedValue, ok := value.(*VT)
+	if !ok {
+		return fmt.Errorf("unexpected journal value type: %T", value)
+	}
+	return j.storage.SetWithTTL(context.Background(), &typedKey, typedValue, 0, j.storage.Timeout())
+}
+
+func (j *FileWriteBehindJournal[KT, VT]) Done(key any) error {
+	typedKey, ok := key.(KT)
+	if !ok {
+		return fmt.Errorf("unexpected journal key type: %T", key)
+	}
+	return j.storage.Del(context.Background(), &typedKey, j.storage.Timeout())
+}
+
+func (j *FileWriteBehindJournal[KT, VT]) Replay(fn func(key any, value any, del bool)) error {
+	keys, err := j.storage.Keys()
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		value, err := j.storage.Get(context.Background(), &keys[i], j.storage.Timeout())
+		switch {
+		case errors.Is(err, ErrTombstone):
+			fn(keys[i], nil, true)
+		case err != nil:
+			return err
+		default:
+			fn(keys[i], value, false)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of recorded writes.
+func (j *FileWriteBehindJournal[KT, VT]) Len() int {
+	keys, _ := j.storage.Keys()
+	return len(keys)
+}
+
+// Close closes the file of the journal.
+func (j *FileWriteBehindJournal[KT, VT]) Close() error {
+	return j.storage.Close()
+}
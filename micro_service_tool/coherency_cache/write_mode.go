@@ -0,0 +1,372 @@
+package coherency_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// WriteModeThrough writes the source, then the cache.
+	WriteModeThrough = "through"
+	// WriteModeAround writes the source, then drops the key from the cache.
+	WriteModeAround = "around"
+	// WriteModeBehind writes the cache and queues the write of the source.
+	WriteModeBehind = "behind"
+)
+
+const (
+	DefaultWriteBehindQueueSize     = 10000
+	DefaultWriteBehindBatchSize     = 100
+	DefaultWriteBehindFlushInterval = time.Millisecond * 100
+	DefaultWriteBehindRetryBackoff  = time.Millisecond * 100
+	DefaultWriteBehindMaxRetries    = 3
+	DefaultWriteBehindBatchTimeout  = time.Second * 10
+)
+
+var (
+	// ErrWriteBehindQueueFull is returned by Set and Del when QueueSize keys are waiting for the source.
+	ErrWriteBehindQueueFull = errors.New("coherency_cache: write-behind queue full")
+	// ErrWriteBehindClosed is returned by Set and Del after Close.
+	ErrWriteBehindClosed = errors.New("coherency_cache: write-behind queue closed")
+)
+
+/*
+Write modes:
+    1. through: Set and Del return once both the source and the cache are written,
+        a Get after them never sees the old value.
+    2. around: Set writes the source and drops the key from the cache, the next Get reads the source.
+        Writes do not fill the cache with values which may never be read.
+    3. behind: Set and Del write the cache and return, the source is written in the background.
+        - Writes of a key waiting in the queue are coalesced, only the last one reaches the source.
+        - The queue is flushed every FlushInterval, or once BatchSize keys are waiting.
+        - A failed write is retried MaxRetries times after RetryBackoff * attempts,
+            then dropped and reported to OnError, unless a newer write of the key replaced it.
+        - Gets of queued keys read the queue instead of the source, so they see the last write
+            even if the cache dropped it. Other instances read the source and see the old value
+            until the flush.
+        - The queue is in memory: Flush or Close it on shutdown, queued writes are lost on a crash
+            unless Journal records them, see IWriteBehindJournal.
+        - The journal is written outside the lock of the queue, so Gets of queued keys
+            never wait for its file I/O.
+        - Each batch is written within BatchTimeout, a write which times out is retried.
+*/
+// WriteBehindConfig configures the queue of WriteModeBehind, zero values mean the defaults.
+type WriteBehindConfig struct {
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+	// MaxRetries is the number of retries of a failed write, < 0 disables retries.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// BatchTimeout is the deadline of the writes of a batch to the source.
+	BatchTimeout time.Duration
+	// Journal persists the queued writes, nil keeps them only in memory.
+	Journal IWriteBehindJournal
+	// OnError is called with the key of a write dropped after MaxRetries.
+	OnError func(key any, err error)
+}
+
+type writeOp[KT any, VT any] struct {
+	key       KT
+	value     *VT
+	del       bool
+	attempts  int
+	notBefore time.Time
+}
+
+// writeBehindQueue holds the writes of the source which are not done yet.
+type writeBehindQueue[KT any, VT any] struct {
+	config WriteBehindConfig
+	source IStorage[KT, VT]
+	ttl    time.Duration
+	// lock locks a key of the source
+	lock func(ctx context.Context, key *KT) (context.Context, func(), error)
+
+	// journalMu orders the journal writes with the changes of pending,
+	// it is taken before mu and held during the file I/O of the journal instead of mu
+	journalMu sync.Mutex
+	mu        sync.Mutex
+	// pending is the last unwritten op of each key
+	pending map[any]*writeOp[KT, VT]
+	// queue holds the keys of pending which are not being written, in write order
+	queue    []any
+	queued   map[any]bool
+	inflight map[any]bool
+	closed   bool
+
+	flushMu sync.Mutex
+	signal  chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newWriteBehindQueue[KT any, VT any](
+	source IStorage[KT, VT], ttl time.Duration, config WriteBehindConfig,
+	lock func(ctx context.Context, key *KT) (context.Context, func(), error),
+) (*writeBehindQueue[KT, VT], error) {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultWriteBehindQueueSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultWriteBehindBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultWriteBehindFlushInterval
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DefaultWriteBehindRetryBackoff
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultWriteBehindMaxRetries
+	}
+	if config.BatchTimeout <= 0 {
+		config.BatchTimeout = DefaultWriteBehindBatchTimeout
+	}
+	q := &writeBehindQueue[KT, VT]{
+		config:   config,
+		source:   source,
+		ttl:      ttl,
+		lock:     lock,
+		pending:  map[any]*writeOp[KT, VT]{},
+		queued:   map[any]bool{},
+		inflight: map[any]bool{},
+		signal:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+	go q.run()
+	return q, nil
+}
+
+// replay queues the writes of the journal.
+func (q *writeBehindQueue[KT, VT]) replay() error {
+	if q.config.Journal == nil {
+		return nil
+	}
+	var replayErr error
+	err := q.config.Journal.Replay(func(key any, value any, del bool) {
+		op := &writeOp[KT, VT]{del: del}
+		var ok bool
+		if op.key, ok = key.(KT); !ok {
+			replayErr = fmt.Errorf("unexpected journal key type: %T", key)
+			return
+		}
+		if !del {
+			if op.value, ok = value.(*VT); !ok {
+				replayErr = fmt.Errorf("unexpected journal value type: %T", value)
+				return
+			}
+		}
+		q.pending[key] = op
+		q.push(key)
+	})
+	return errors.Join(err, replayErr)
+}
+
+// enqueue queues op, replacing the pending op of its key.
+func (q *writeBehindQueue[KT, VT]) enqueue(op *writeOp[KT, VT]) error {
+	q.journalMu.Lock()
+	defer q.journalMu.Unlock()
+
+	mapKey := any(op.key)
+	q.mu.Lock()
+	_, replaced := q.pending[mapKey]
+	full, closed := !replaced && len(q.pending) >= q.config.QueueSize, q.closed
+	q.mu.Unlock()
+	switch {
+	case closed:
+		return ErrWriteBehindClosed
+	case full:
+		return ErrWriteBehindQueueFull
+	}
+	// pending only shrinks until journalMu is released, the checks above still hold
+	if q.config.Journal != nil {
+		if err := q.config.Journal.Record(mapKey, op.value, op.del); err != nil {
+			return err
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending[mapKey] = op
+	q.push(mapKey)
+	if len(q.queue) >= q.config.BatchSize {
+		select {
+		case q.signal <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// push queues mapKey unless it is queued or being written, it must be called with q.mu held.
+func (q *writeBehindQueue[KT, VT]) push(mapKey any) {
+	if q.queued[mapKey] || q.inflight[mapKey] {
+		return
+	}
+	q.queued[mapKey] = true
+	q.queue = append(q.queue, mapKey)
+}
+
+// lookup returns the pending op of key.
+func (q *writeBehindQueue[KT, VT]) lookup(key *KT) (*writeOp[KT, VT], bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	op, ok := q.pending[any(*key)]
+	return op, ok
+}
+
+// Len returns the number of keys waiting for the source.
+func (q *writeBehindQueue[KT, VT]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *writeBehindQueue[KT, VT]) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		case <-q.signal:
+		}
+		q.flushOnce(context.Background(), false)
+	}
+}
+
+// flushOnce writes every queued key once, force ignores the retry backoff.
+// It returns whether some keys are still pending.
+func (q *writeBehindQueue[KT, VT]) flushOnce(ctx context.Context, force bool) bool {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	for {
+		batch := q.take(force)
+		if len(batch) == 0 {
+			break
+		}
+		q.writeBatch(ctx, batch)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return q.Len() > 0
+}
+
+// writeBatch writes the ops of batch within BatchTimeout.
+func (q *writeBehindQueue[KT, VT]) writeBatch(ctx context.Context, batch []*writeOp[KT, VT]) {
+	ctx, cancel := context.WithTimeout(ctx, q.config.BatchTimeout)
+	defer cancel()
+	for _, op := range batch {
+		q.finish(op, q.write(ctx, op))
+	}
+}
+
+// take removes up to BatchSize keys due for a write from the queue.
+func (q *writeBehindQueue[KT, VT]) take(force bool) []*writeOp[KT, VT] {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var batch []*writeOp[KT, VT]
+	var later []any
+	for len(q.queue) > 0 && len(batch) < q.config.BatchSize {
+		mapKey := q.queue[0]
+		q.queue = q.queue[1:]
+		op := q.pending[mapKey]
+		if !force && now.Before(op.notBefore) {
+			later = append(later, mapKey)
+			continue
+		}
+		delete(q.queued, mapKey)
+		q.inflight[mapKey] = true
+		batch = append(batch, op)
+	}
+	q.queue = append(q.queue, later...)
+	return batch
+}
+
+func (q *writeBehindQueue[KT, VT]) write(ctx context.Context, op *writeOp[KT, VT]) error {
+	ctx, unlock, err := q.lock(ctx, &op.key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if op.del {
+		return q.source.Del(ctx, &op.key, q.source.Timeout())
+	}
+	return setWithTTL(ctx, q.source, &op.key, op.value, q.ttl, q.source.Timeout())
+}
+
+// finish records the result of writing op.
+func (q *writeBehindQueue[KT, VT]) finish(op *writeOp[KT, VT], err error) {
+	mapKey := any(op.key)
+	var done, dropped bool
+
+	q.journalMu.Lock()
+	q.mu.Lock()
+	delete(q.inflight, mapKey)
+	switch {
+	case q.pending[mapKey] != op:
+		// a newer write of the key came in while op was written
+		q.push(mapKey)
+	case err == nil:
+		delete(q.pending, mapKey)
+		done = true
+	case op.attempts >= q.config.MaxRetries:
+		delete(q.pending, mapKey)
+		done, dropped = true, true
+	default:
+		op.attempts++
+		op.notBefore = time.Now().Add(q.config.RetryBackoff * time.Duration(op.attempts))
+		q.push(mapKey)
+	}
+	q.mu.Unlock()
+
+	// a newer write of the key waits for journalMu, so its Record comes after Done
+	if done && q.config.Journal != nil {
+		// a failure only means the write is replayed once more
+		_ = q.config.Journal.Done(mapKey)
+	}
+	q.journalMu.Unlock()
+
+	if dropped && q.config.OnError != nil {
+		q.config.OnError(op.key, err)
+	}
+}
+
+// Flush writes the pending keys until none is left or ctx is done, retries do not wait for backoff.
+func (q *writeBehindQueue[KT, VT]) Flush(ctx context.Context) error {
+	for q.flushOnce(ctx, true) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Close rejects new writes, stops the worker and flushes the pending keys.
+func (q *writeBehindQueue[KT, VT]) Close(ctx context.Context) error {
+	// journalMu waits for the writes being recorded
+	q.journalMu.Lock()
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+	}
+	q.mu.Unlock()
+	q.journalMu.Unlock()
+	<-q.done
+	return q.Flush(ctx)
+}
@@ -0,0 +1,498 @@
+package coherency_cache
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errSourceDown = errors.New("source down")
+
+// flakyStorage counts the writes of a MemoryStorage, and fails the next failures writes.
+type flakyStorage[KT any, VT any] struct {
+	*MemoryStorage[KT, VT]
+	writes   atomic.Int64
+	failures atomic.Int64
+}
+
+func newFlakyStorage[KT any, VT any]() *flakyStorage[KT, VT] {
+	return &flakyStorage[KT, VT]{MemoryStorage: NewMemoryStorage[KT, VT]()}
+}
+
+func (s *flakyStorage[KT, VT]) fail() bool {
+	for {
+		failures := s.failures.Load()
+		if failures <= 0 {
+			return false
+		}
+		if s.failures.CompareAndSwap(failures, failures-1) {
+			return true
+		}
+	}
+}
+
+func (s *flakyStorage[KT, VT]) Set(ctx context.Context, key *KT, value *VT, timeout time.Duration) error {
+	s.writes.Add(1)
+	if s.fail() {
+		return errSourceDown
+	}
+	return s.MemoryStorage.Set(ctx, key, value, timeout)
+}
+
+func (s *flakyStorage[KT, VT]) Del(ctx context.Context, key *KT, timeout time.Duration) error {
+	s.writes.Add(1)
+	if s.fail() {
+		return errSourceDown
+	}
+	return s.MemoryStorage.Del(ctx, key, timeout)
+}
+
+// hangingStorage is a MemoryStorage whose writes wait until ctx is done.
+type hangingStorage[KT any, VT any] struct {
+	*MemoryStorage[KT, VT]
+}
+
+func (s *hangingStorage[KT, VT]) Set(ctx context.Context, key *KT, value *VT, timeout time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// blockingJournal keeps nothing, Record waits for release while block is set.
+type blockingJournal struct {
+	block     atomic.Bool
+	recording chan struct{}
+	release   chan struct{}
+}
+
+func (j *blockingJournal) Record(key any, value any, del bool) error {
+	if j.block.Load() {
+		j.recording <- struct{}{}
+		<-j.release
+	}
+	return nil
+}
+
+func (j *blockingJournal) Done(key any) error {
+	return nil
+}
+
+func (j *blockingJournal) Replay(fn func(key any, value any, del bool)) error {
+	return nil
+}
+
+func TestWriteMode(t *testing.T) {
+	t.Run("Write Through", TestWriteThrough)
+	t.Run("Write Around", TestWriteAround)
+	t.Run("Write Behind", TestWriteBehind)
+	t.Run("Write Behind Coalesce", TestWriteBehindCoalesce)
+	t.Run("Write Behind Retry", TestWriteBehindRetry)
+	t.Run("Write Behind Close", TestWriteBehindClose)
+	t.Run("Write Behind Queue Full", TestWriteBehindQueueFull)
+	t.Run("Write Behind Default Retries", TestWriteBehindDefaultRetries)
+	t.Run("Write Behind Journal", TestWriteBehindJournal)
+	t.Run("Write Behind Journal Lock", TestWriteBehindJournalLock)
+	t.Run("Write Behind Batch Timeout", TestWriteBehindBatchTimeout)
+}
+
+func TestWriteThrough(t *testing.T) {
+	cache := NewMemoryStorage[string, string]()
+	source := newFlakyStorage[string, string]()
+	storage := NewCoherencyStorageWithConfig[string, string](cache, source, CoherencyConfig{
+		WriteMode: WriteModeThrough,
+	})
+
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	// Both layers hold the value once Set returns
+	if getValue, _ := source.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected value in source, got:", getValue)
+	}
+	if getValue, _ := cache.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected value in cache, got:", getValue)
+	}
+
+	// A failed source write leaves the cache untouched
+	source.failures.Store(1)
+	newValue := "new"
+	if err := storage.Set(context.Background(), &key, &newValue, time.Second); !errors.Is(err, errSourceDown) {
+		t.Fatal("Expected source error, got:", err)
+	}
+	if getValue, _ := cache.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected old value in cache, got:", getValue)
+	}
+}
+
+func TestWriteAround(t *testing.T) {
+	cache := NewMemoryStorage[string, string]()
+	source := newFlakyStorage[string, string]()
+	storage := NewCoherencyStorageWithConfig[string, string](cache, source, CoherencyConfig{
+		WriteMode: WriteModeAround,
+	})
+
+	key, oldValue, newValue := "key", "old", "new"
+	_ = cache.Set(context.Background(), &key, &oldValue, time.Second)
+	if err := storage.Set(context.Background(), &key, &newValue, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+
+	// The cache is invalidated instead of filled
+	if _, err := cache.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected key dropped from cache, got:", err)
+	}
+	if getValue, _ := storage.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != newValue {
+		t.Fatal("Expected new value from source, got:", getValue)
+	}
+	if getValue, _ := cache.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != newValue {
+		t.Fatal("Expected cache filled by Get, got:", getValue)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	cache := NewMemoryStorage[string, string]()
+	source := newFlakyStorage[string, string]()
+	storage := NewCoherencyStorageWithConfig[string, string](cache, source, CoherencyConfig{
+		WriteMode:   WriteModeBehind,
+		WriteBehind: WriteBehindConfig{FlushInterval: time.Hour},
+	})
+	defer storage.Close(context.Background())
+
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	// The source is written later
+	if _, err := source.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected source not written yet, got:", err)
+	}
+	if pending := storage.Pending(); pending != 1 {
+		t.Fatal("Expected 1 pending key, got:", pending)
+	}
+
+	// Reads see the queued write even if the cache dropped it
+	_ = cache.Del(context.Background(), &key, time.Second)
+	if getValue, _ := storage.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected queued value, got:", getValue)
+	}
+
+	// So do reads of a queued Del
+	_ = source.MemoryStorage.Set(context.Background(), &key, &value, time.Second)
+	if err := storage.Del(context.Background(), &key, time.Second); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	if _, err := storage.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected queued Del to hide the source value, got:", err)
+	}
+
+	if err := storage.Flush(context.Background()); err != nil {
+		t.Fatal("Flush failed:", err)
+	}
+	if _, err := source.Get(context.Background(), &key, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected key deleted from source after Flush, got:", err)
+	}
+	if pending := storage.Pending(); pending != 0 {
+		t.Fatal("Expected no pending key after Flush, got:", pending)
+	}
+}
+
+func TestWriteBehindCoalesce(t *testing.T) {
+	source := newFlakyStorage[string, string]()
+	storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), source, CoherencyConfig{
+		WriteMode:   WriteModeBehind,
+		WriteBehind: WriteBehindConfig{FlushInterval: time.Hour},
+	})
+	defer storage.Close(context.Background())
+
+	key := "key"
+	for _, value := range []string{"a", "b", "c"} {
+		if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+			t.Fatal("Set failed:", err)
+		}
+	}
+	if err := storage.Flush(context.Background()); err != nil {
+		t.Fatal("Flush failed:", err)
+	}
+
+	if writes := source.writes.Load(); writes != 1 {
+		t.Fatal("Expected 1 source write for 3 queued Sets, got:", writes)
+	}
+	if getValue, _ := source.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != "c" {
+		t.Fatal("Expected last value in source, got:", getValue)
+	}
+}
+
+func TestWriteBehindRetry(t *testing.T) {
+	source := newFlakyStorage[string, string]()
+	var dropped atomic.Int64
+	storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), source, CoherencyConfig{
+		WriteMode: WriteModeBehind,
+		WriteBehind: WriteBehindConfig{
+			BatchSize:     1,
+			FlushInterval: time.Millisecond * 10,
+			MaxRetries:    3,
+			RetryBackoff:  time.Millisecond * 10,
+			OnError: func(key any, err error) {
+				dropped.Add(1)
+			},
+		},
+	})
+	defer storage.Close(context.Background())
+
+	// Retried until the source is back
+	source.failures.Store(2)
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for storage.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if getValue, _ := source.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected value written after retries, got:", getValue)
+	}
+	if writes := source.writes.Load(); writes != 3 {
+		t.Fatal("Expected 3 source writes, got:", writes)
+	}
+
+	// Dropped after MaxRetries
+	source.failures.Store(100)
+	otherKey := "other"
+	if err := storage.Set(context.Background(), &otherKey, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for dropped.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if dropped.Load() != 1 || storage.Pending() != 0 {
+		t.Fatal("Expected write dropped after MaxRetries, dropped:", dropped.Load())
+	}
+}
+
+func TestWriteBehindClose(t *testing.T) {
+	source := newFlakyStorage[string, string]()
+	storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), source, CoherencyConfig{
+		WriteMode:   WriteModeBehind,
+		WriteBehind: WriteBehindConfig{FlushInterval: time.Hour},
+	})
+
+	keys := []string{"a", "b", "c"}
+	for i := range keys {
+		if err := storage.Set(context.Background(), &keys[i], &keys[i], time.Second); err != nil {
+			t.Fatal("Set failed:", err)
+		}
+	}
+	if err := storage.Close(context.Background()); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	// Close flushes the queue
+	for i := range keys {
+		if getValue, _ := source.Get(context.Background(), &keys[i], time.Second); getValue == nil || *getValue != keys[i] {
+			t.Fatal("Expected value flushed on Close, got:", getValue)
+		}
+	}
+	if err := storage.Set(context.Background(), &keys[0], &keys[0], time.Second); !errors.Is(err, ErrWriteBehindClosed) {
+		t.Fatal("Expected ErrWriteBehindClosed after Close, got:", err)
+	}
+}
+
+func TestWriteBehindQueueFull(t *testing.T) {
+	cache := NewMemoryStorage[string, string]()
+	storage := NewCoherencyStorageWithConfig[string, string](cache, newFlakyStorage[string, string](), CoherencyConfig{
+		WriteMode:   WriteModeBehind,
+		WriteBehind: WriteBehindConfig{QueueSize: 1, FlushInterval: time.Hour},
+	})
+	defer storage.Close(context.Background())
+
+	key, otherKey, value := "key", "other", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	// A queued key can still be written
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set of a queued key failed:", err)
+	}
+	if err := storage.Set(context.Background(), &otherKey, &value, time.Second); !errors.Is(err, ErrWriteBehindQueueFull) {
+		t.Fatal("Expected ErrWriteBehindQueueFull, got:", err)
+	}
+	if _, err := cache.Get(context.Background(), &otherKey, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected rejected write not cached, got:", err)
+	}
+}
+
+func TestWriteBehindDefaultRetries(t *testing.T) {
+	for _, c := range []struct {
+		maxRetries int
+		writes     int64
+	}{
+		{0, 1 + DefaultWriteBehindMaxRetries},
+		{-1, 1},
+	} {
+		source := newFlakyStorage[string, string]()
+		source.failures.Store(100)
+		var dropped atomic.Int64
+		storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), source, CoherencyConfig{
+			WriteMode: WriteModeBehind,
+			WriteBehind: WriteBehindConfig{
+				FlushInterval: time.Millisecond * 5,
+				MaxRetries:    c.maxRetries,
+				RetryBackoff:  time.Millisecond,
+				OnError: func(key any, err error) {
+					dropped.Add(1)
+				},
+			},
+		})
+
+		key, value := "key", "value"
+		if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+			t.Fatal("Set failed:", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for dropped.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 5)
+		}
+		if writes := source.writes.Load(); dropped.Load() != 1 || writes != c.writes {
+			t.Fatalf("Expected %d source writes with MaxRetries %d, got: %d", c.writes, c.maxRetries, writes)
+		}
+		_ = storage.Close(context.Background())
+	}
+}
+
+func TestWriteBehindJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.log")
+	journal, err := NewFileWriteBehindJournal[string, string](FileStorageConfig{Path: path, Fsync: FsyncAlways})
+	if err != nil {
+		t.Fatal("NewFileWriteBehindJournal failed:", err)
+	}
+	storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), newFlakyStorage[string, string](), CoherencyConfig{
+		WriteMode:   WriteModeBehind,
+		WriteBehind: WriteBehindConfig{FlushInterval: time.Hour, Journal: journal},
+	})
+	defer storage.Close(context.Background())
+
+	key, deleted, value := "key", "deleted", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	if err := storage.Del(context.Background(), &deleted, time.Second); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	// the process stops before the flush
+	_ = journal.Close()
+
+	journal, err = NewFileWriteBehindJournal[string, string](FileStorageConfig{Path: path})
+	if err != nil {
+		t.Fatal("NewFileWriteBehindJournal failed:", err)
+	}
+	defer journal.Close()
+	if journal.Len() != 2 {
+		t.Fatal("Expected 2 recorded writes, got:", journal.Len())
+	}
+	source := newFlakyStorage[string, string]()
+	_ = source.MemoryStorage.Set(context.Background(), &deleted, &value, time.Second)
+	restarted := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), source, CoherencyConfig{
+		WriteMode:   WriteModeBehind,
+		WriteBehind: WriteBehindConfig{FlushInterval: time.Hour, Journal: journal},
+	})
+	defer restarted.Close(context.Background())
+
+	if pending := restarted.Pending(); pending != 2 {
+		t.Fatal("Expected 2 replayed writes, got:", pending)
+	}
+	if getValue, _ := restarted.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected the replayed value, got:", getValue)
+	}
+	if err := restarted.Flush(context.Background()); err != nil {
+		t.Fatal("Flush failed:", err)
+	}
+	if getValue, _ := source.Get(context.Background(), &key, time.Second); getValue == nil || *getValue != value {
+		t.Fatal("Expected the replayed Set in the source, got:", getValue)
+	}
+	if _, err := source.Get(context.Background(), &deleted, time.Second); !IsNotFound(err) {
+		t.Fatal("Expected the replayed Del in the source, got:", err)
+	}
+	if journal.Len() != 0 {
+		t.Fatal("Expected the journal emptied by the flush, got:", journal.Len())
+	}
+}
+
+// TestWriteBehindJournalLock checks that Gets of queued keys do not wait for the journal.
+func TestWriteBehindJournalLock(t *testing.T) {
+	journal := &blockingJournal{recording: make(chan struct{}), release: make(chan struct{})}
+	cache := NewMemoryStorage[string, string]()
+	storage := NewCoherencyStorageWithConfig[string, string](cache, newFlakyStorage[string, string](), CoherencyConfig{
+		WriteMode:   WriteModeBehind,
+		WriteBehind: WriteBehindConfig{FlushInterval: time.Hour, Journal: journal},
+	})
+	defer storage.Close(context.Background())
+
+	key, other, value := "key", "other", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	// the cache drops the key, Get reads the queue
+	_ = cache.Del(context.Background(), &key, time.Second)
+
+	journal.block.Store(true)
+	setErr := make(chan error, 1)
+	go func() {
+		setErr <- storage.Set(context.Background(), &other, &value, time.Second)
+	}()
+	<-journal.recording
+
+	got := make(chan *string, 1)
+	go func() {
+		getValue, _ := storage.Get(context.Background(), &key, time.Second)
+		got <- getValue
+	}()
+	select {
+	case getValue := <-got:
+		if getValue == nil || *getValue != value {
+			t.Fatal("Expected the queued value, got:", getValue)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Get not to wait for the journal")
+	}
+
+	journal.block.Store(false)
+	close(journal.release)
+	if err := <-setErr; err != nil {
+		t.Fatal("Set failed:", err)
+	}
+}
+
+func TestWriteBehindBatchTimeout(t *testing.T) {
+	dropped := make(chan error, 1)
+	source := &hangingStorage[string, string]{MemoryStorage: NewMemoryStorage[string, string]()}
+	storage := NewCoherencyStorageWithConfig[string, string](NewMemoryStorage[string, string](), source, CoherencyConfig{
+		WriteMode: WriteModeBehind,
+		WriteBehind: WriteBehindConfig{
+			FlushInterval: time.Millisecond * 5,
+			BatchTimeout:  time.Millisecond * 20,
+			MaxRetries:    -1,
+			OnError: func(key any, err error) {
+				dropped <- err
+			},
+		},
+	})
+	defer storage.Close(context.Background())
+
+	key, value := "key", "value"
+	if err := storage.Set(context.Background(), &key, &value, time.Second); err != nil {
+		t.Fatal("Set failed:", err)
+	}
+	select {
+	case err := <-dropped:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("Expected the write to time out, got:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the batch to time out")
+	}
+}
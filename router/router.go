@@ -1,11 +1,32 @@
 package router
 
 import (
+	"time"
+
+	"github.com/Steve-Lee-CST/go-gin-student-tool/config"
+	"github.com/Steve-Lee-CST/go-gin-student-tool/gin_tool"
+	"github.com/Steve-Lee-CST/go-gin-student-tool/handler"
 	"github.com/Steve-Lee-CST/go-gin-student-tool/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterRouter(engine *gin.Engine) {
+	// Concurrency limit, sheds load before any other work is done
+	engine.Use(gin_tool.ConcurrencyLimitTool{}.Middleware(gin_tool.ConcurrencyLimitConfig{
+		GlobalLimit:      config.ConcurrencyLimit.GlobalLimit,
+		RouteLimit:       config.ConcurrencyLimit.RouteLimit,
+		MaxQueue:         config.ConcurrencyLimit.MaxQueue,
+		QueueTimeout:     time.Duration(config.ConcurrencyLimit.QueueTimeout) * time.Millisecond,
+		Adaptive:         config.ConcurrencyLimit.Adaptive,
+		MinLimitRatio:    config.ConcurrencyLimit.MinLimitRatio,
+		MaxLimitRatio:    config.ConcurrencyLimit.MaxLimitRatio,
+		LatencyThreshold: time.Duration(config.ConcurrencyLimit.LatencyThreshold) * time.Millisecond,
+		RetryAfter:       time.Duration(config.ConcurrencyLimit.RetryAfter) * time.Second,
+	}))
+
+	// Rate limit policies, must be attached before the routes are registered
+	engine.Use(handler.RateLimitMiddleware)
+
 	toolRouter(engine.Group("tool"))
 
 	engine.NoRoute(middleware.NotFoundHandler)
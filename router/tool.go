@@ -15,7 +15,13 @@ func toolRouter(engine *gin.RouterGroup) {
 		Handler: handler.PingHandler,
 		Middleware: []gin.HandlerFunc{
 			// Request ID middleware
-			gin_tool.RequestIDTool{}.Middleware(config.Base.ServiceName),
+			handler.RequestIDMiddleware,
+			// Request ID and trace context propagation middleware
+			gin_tool.PropagationTool{}.Middleware(),
+			// Tracing middleware
+			gin_tool.TracingTool{}.Middleware(handler.Tracer),
+			// Quota middleware
+			handler.QuotaManager.Middleware(),
 			// HTTP Logger middleware
 			gin_tool.HttpLoggerTool{}.Middleware(gin_tool.DefaultHttpLogger),
 			// HTTP helper middleware
@@ -26,9 +32,35 @@ func toolRouter(engine *gin.RouterGroup) {
 		Handler: handler.PingHandler,
 		Middleware: []gin.HandlerFunc{
 			// Request ID middleware
-			gin_tool.RequestIDTool{}.Middleware(config.Base.ServiceName),
+			handler.RequestIDMiddleware,
+			// Request ID and trace context propagation middleware
+			gin_tool.PropagationTool{}.Middleware(),
+			// Tracing middleware
+			gin_tool.TracingTool{}.Middleware(handler.Tracer),
+			// Quota middleware
+			handler.QuotaManager.Middleware(),
 			// HTTP helper middleware
 			gin_tool.HttpHelper{}.Middleware(),
 		},
 	}.ToChain()...)
+
+	// tool/quota/usage: GET quota usage of the caller, or ?identity= for admin plans, not counted
+	engine.GET("quota/usage", handler.QuotaUsageHandler)
+	// tool/quota/reset: POST reset quota usage, admin plans only
+	engine.POST("quota/reset", handler.QuotaManager.Middleware(), handler.QuotaResetHandler)
+	if config.RateLimit.ReportToken != "" {
+		// tool/rate_limit/shadow: GET shadow rate limit report
+		engine.GET("rate_limit/shadow", handler.RateLimitReportAuth, handler.RateLimitShadowHandler)
+		// tool/rate_limit/shadow/reset: POST drop the shadow rate limit report, the dropped report is returned
+		engine.POST("rate_limit/shadow/reset", handler.RateLimitReportAuth, handler.RateLimitShadowResetHandler)
+	}
+	// tool/health/rate_limit: GET state of the Redis rate limiters, degraded while limited locally
+	engine.GET("health/rate_limit", handler.RateLimitHealthHandler)
+
+	if handler.SpanCollector != nil {
+		// tool/v1/traces: POST OTLP/HTTP JSON spans to the local collector stand-in
+		engine.POST("v1/traces", handler.CollectorAuth, handler.TraceReceiveHandler)
+		// tool/traces: GET spans received by the collector, filter by ?trace_id=
+		engine.GET("traces", handler.CollectorAuth, handler.TraceListHandler)
+	}
 }